@@ -2,17 +2,23 @@ package main
 
 import (
 	"github.com/jgautheron/exago/internal/server"
+	"github.com/jgautheron/exago/internal/shutdown"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
 	server.InitializeConfig()
 
-	s, err := server.New()
+	ctx, cancel := shutdown.Context()
+	defer cancel()
+
+	s, err := server.New(ctx)
 	if err != nil {
-		panic(err)
+		logrus.WithError(err).Fatal("Could not initialize the server")
 	}
+	defer s.Close()
 
-	if err := s.ListenAndServe(); err != nil {
-		panic(err)
+	if err := s.ListenAndServe(ctx); err != nil {
+		logrus.WithError(err).Error("The server stopped")
 	}
 }
@@ -1,39 +1,91 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 
-	exago "github.com/jgautheron/exago/pkg"
-
+	"github.com/jgautheron/exago/internal/config"
+	"github.com/jgautheron/exago/internal/consumer"
+	"github.com/jgautheron/exago/internal/database/backend"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/internal/github"
+	"github.com/jgautheron/exago/internal/shutdown"
 	"github.com/jgautheron/exago/pkg/analysis/task"
+	"github.com/sirupsen/logrus"
 )
 
+// GoVersion is the version of Go the consumer analyzes with, set at build time.
 var GoVersion string
 
+var Config Cfg
+
+type Cfg struct {
+	config.LogConfig
+	config.ShutdownConfig
+	config.RetryConfig
+	config.DeduplicationConfig
+	config.ModulesConfig
+	config.TimeoutConfig
+	config.PushConfig
+	config.DatabaseConfig
+	config.GitHubConfig
+	config.GoogleCloudConfig
+}
+
 func main() {
-	repo := "github.com/pkg/errors"
-	m := task.NewManager(repo)
+	config.InitializeConfig(&Config)
+	config.InitializeLogging(Config.LogLevel, Config.LogFormat)
 
-	//m.UseReference(c.String("ref"))
+	ctx, cancel := shutdown.Context()
+	defer cancel()
 
-	res := m.ExecuteRunners()
-	if res.Success {
-		fmt.Printf("%#v", res.Runners)
+	db, err := backend.NewFromConfig(context.Background(), &Config.DatabaseConfig, &Config.GoogleCloudConfig)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not initialize the database")
 	}
+	defer db.Close()
 
-	out, err := json.Marshal(res.Runners)
+	evp, err := eventpub.NewFromConfig(context.Background(), &Config.GoogleCloudConfig)
 	if err != nil {
-		panic(err)
+		logrus.WithError(err).Fatal("Could not initialize the event publisher")
 	}
+	defer evp.Close()
 
-	fmt.Println(string(out))
+	host, err := github.NewWithConfig(context.Background(), &Config.GitHubConfig)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not initialize the GitHub client")
+	}
 
-	var foo exago.Results
-	err = json.Unmarshal(out, &foo)
+	c, err := consumer.New(db, evp, host, GoVersion)
 	if err != nil {
-		panic(err)
+		logrus.WithError(err).Fatal("Could not initialize the consumer")
 	}
+	c.SetRetryPolicy(consumer.RetryPolicy{
+		MaxAttempts: Config.RetryMaxAttempts,
+		Backoff:     Config.RetryBackoff,
+		MaxBackoff:  Config.RetryMaxBackoff,
+	})
+	c.SetClaimTTL(Config.ClaimTTL)
+	c.SetClaimLease(Config.ClaimLease)
+	c.SetGoProxy(Config.AnalysisGoProxy, Config.AnalysisGoSumDB)
+	c.SetTimeouts(task.Timeouts{
+		Analysis: Config.AnalysisTimeout,
+		Runner:   Config.RunnerTimeout,
+		Runners:  Config.RunnerTimeouts,
+	})
+	c.SetCancelPollInterval(Config.CancelPollInterval)
 
-	fmt.Printf("%#v\n", foo)
+	if Config.PushEnabled {
+		addr := fmt.Sprintf("%s:%d", Config.PushBind, Config.PushPort)
+		err = c.ServePush(ctx, addr, consumer.PushOptions{
+			Token:         Config.PushToken,
+			MaxConcurrent: Config.PushMaxConcurrent,
+			AckDeadline:   Config.PushAckDeadline,
+		}, Config.ShutdownTimeout)
+	} else {
+		err = c.Run(ctx, evp, Config.ShutdownTimeout)
+	}
+	if err != nil {
+		logrus.WithError(err).Error("The consumer stopped")
+	}
 }
@@ -0,0 +1,101 @@
+// Command exago runs the API and a consumer in a single process, connected
+// by an in-process queue, so that no Google Cloud project is needed.
+// The repositories are analyzed with the local Go toolchain.
+package main
+
+import (
+	"context"
+
+	"github.com/jgautheron/exago/internal/config"
+	"github.com/jgautheron/exago/internal/consumer"
+	"github.com/jgautheron/exago/internal/database/backend"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/internal/github"
+	"github.com/jgautheron/exago/internal/server"
+	"github.com/jgautheron/exago/internal/shutdown"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+	"github.com/sirupsen/logrus"
+)
+
+var Config Cfg
+
+type Cfg struct {
+	config.LogConfig
+	config.HTTPConfig
+	config.ShutdownConfig
+	config.RetryConfig
+	config.DeduplicationConfig
+	config.ModulesConfig
+	config.TimeoutConfig
+	config.UploadConfig
+	config.GitHubConfig
+
+	// The results are kept on disk by default rather than in Firestore
+	DatabaseBackend string `envconfig:"DATABASE_BACKEND" default:"leveldb"`
+	DatabasePath    string `envconfig:"DATABASE_PATH" default:"exago.db"`
+}
+
+func main() {
+	config.InitializeConfig(&Config)
+	config.InitializeLogging(Config.LogLevel, Config.LogFormat)
+	server.Config.HTTPConfig = Config.HTTPConfig
+	server.Config.ShutdownConfig = Config.ShutdownConfig
+	server.Config.GitHubConfig = Config.GitHubConfig
+	server.Config.UploadConfig = Config.UploadConfig
+	server.Config.ModulesConfig = Config.ModulesConfig
+	server.Config.TimeoutConfig = Config.TimeoutConfig
+
+	ctx, cancel := shutdown.Context()
+	defer cancel()
+
+	db, err := backend.NewFromConfig(context.Background(), &config.DatabaseConfig{
+		DatabaseBackend: Config.DatabaseBackend,
+		DatabasePath:    Config.DatabasePath,
+	}, &config.GoogleCloudConfig{})
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not initialize the database")
+	}
+
+	host, err := github.NewWithConfig(context.Background(), &Config.GitHubConfig)
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not initialize the GitHub client")
+	}
+
+	queue := eventpub.NewLocal()
+	s := server.NewWith(ctx, db, queue, host)
+	// Released last, once the analyses in progress completed
+	defer s.Close()
+
+	c, err := consumer.New(db, queue, host, "")
+	if err != nil {
+		logrus.WithError(err).Fatal("Could not initialize the consumer")
+	}
+	c.SetRetryPolicy(consumer.RetryPolicy{
+		MaxAttempts: Config.RetryMaxAttempts,
+		Backoff:     Config.RetryBackoff,
+		MaxBackoff:  Config.RetryMaxBackoff,
+	})
+	c.SetClaimTTL(Config.ClaimTTL)
+	c.SetClaimLease(Config.ClaimLease)
+	c.SetGoProxy(Config.AnalysisGoProxy, Config.AnalysisGoSumDB)
+	c.SetTimeouts(task.Timeouts{
+		Analysis: Config.AnalysisTimeout,
+		Runner:   Config.RunnerTimeout,
+		Runners:  Config.RunnerTimeouts,
+	})
+	c.SetCancelPollInterval(Config.CancelPollInterval)
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		if err := c.Run(ctx, queue, Config.ShutdownTimeout); err != nil {
+			logrus.WithError(err).Error("The consumer stopped")
+		}
+	}()
+
+	if err := s.ListenAndServe(ctx); err != nil {
+		logrus.WithError(err).Error("The server stopped")
+	}
+	// Stop the consumer as well if the server could not start
+	cancel()
+	<-consumed
+}
@@ -0,0 +1,169 @@
+// Package badge renders the SVG badges embedded in the projects READMEs.
+package badge
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"text/template"
+	"unicode/utf8"
+)
+
+// Style defines the look of the badge.
+type Style string
+
+const (
+	StyleFlat    Style = "flat"
+	StylePlastic Style = "plastic"
+)
+
+const (
+	// DefaultTitle is displayed when the badge has no title of its own (e.g. rank)
+	DefaultTitle = "exago"
+	// UnknownValue is displayed when the repository has never been analyzed
+	UnknownValue = "unknown"
+	// UnknownColor is the colour of badges without score
+	UnknownColor = "#9f9f9f"
+	// UnknownColorName is the shields.io name of UnknownColor
+	UnknownColorName = "lightgrey"
+	titleColor       = "#555"
+)
+
+// colors maps the minimum score to reach with its colour and the
+// shields.io name of the colour, from the best to the worst.
+var colors = []struct {
+	score float64
+	color string
+	name  string
+}{
+	{90, "#4c1", "brightgreen"},
+	{80, "#97ca00", "green"},
+	{70, "#a4a61d", "yellowgreen"},
+	{60, "#dfb317", "yellow"},
+	{50, "#fe7d37", "orange"},
+	{0, "#e05d44", "red"},
+}
+
+type styleParams struct {
+	Height   int
+	Radius   int
+	TextY    int
+	Gradient string
+}
+
+var styles = map[Style]styleParams{
+	StyleFlat: {20, 3, 14, `<stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/>`},
+	StylePlastic: {18, 4, 13, `<stop offset="0" stop-color="#fff" stop-opacity=".7"/><stop offset=".1" stop-color="#aaa" stop-opacity=".1"/>` +
+		`<stop offset=".9" stop-opacity=".3"/><stop offset="1" stop-opacity=".5"/>`},
+}
+
+var tpl = template.Must(template.New("badge").Funcs(template.FuncMap{
+	"escape": template.HTMLEscapeString,
+}).Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">` +
+	`<linearGradient id="b" x2="0" y2="100%">{{.Gradient}}</linearGradient>` +
+	`<clipPath id="a"><rect width="{{.Width}}" height="{{.Height}}" rx="{{.Radius}}" fill="#fff"/></clipPath>` +
+	`<g clip-path="url(#a)">` +
+	`<path fill="{{.TitleColor}}" d="M0 0h{{.TitleWidth}}v{{.Height}}H0z"/>` +
+	`<path fill="{{.Color}}" d="M{{.TitleWidth}} 0h{{.ValueWidth}}v{{.Height}}H{{.TitleWidth}}z"/>` +
+	`<path fill="url(#b)" d="M0 0h{{.Width}}v{{.Height}}H0z"/>` +
+	`</g>` +
+	`<g fill="#fff" text-anchor="middle" font-family="DejaVu Sans,Verdana,Geneva,sans-serif" font-size="11">` +
+	`<text x="{{.TitleX}}" y="{{.ShadowY}}" fill="#010101" fill-opacity=".3">{{escape .Title}}</text>` +
+	`<text x="{{.TitleX}}" y="{{.TextY}}">{{escape .Title}}</text>` +
+	`<text x="{{.ValueX}}" y="{{.ShadowY}}" fill="#010101" fill-opacity=".3">{{escape .Value}}</text>` +
+	`<text x="{{.ValueX}}" y="{{.TextY}}">{{escape .Value}}</text>` +
+	`</g></svg>`))
+
+// ParseStyle returns the style matching the given name, flat being the default.
+func ParseStyle(name string) Style {
+	if _, ok := styles[Style(name)]; ok {
+		return Style(name)
+	}
+	return StyleFlat
+}
+
+// Color returns the colour associated to the score.
+func Color(score float64) string {
+	for _, c := range colors {
+		if score >= c.score {
+			return c.color
+		}
+	}
+	return colors[len(colors)-1].color
+}
+
+// ColorName returns the shields.io name of the colour associated to the score.
+func ColorName(score float64) string {
+	for _, c := range colors {
+		if score >= c.score {
+			return c.name
+		}
+	}
+	return colors[len(colors)-1].name
+}
+
+// Render builds the SVG badge.
+func Render(title, value, color string, style Style) ([]byte, error) {
+	if title == "" {
+		title = DefaultTitle
+	}
+	st, ok := styles[style]
+	if !ok {
+		st = styles[StyleFlat]
+	}
+
+	tw, vw := textWidth(title), textWidth(value)
+	var buf bytes.Buffer
+	err := tpl.Execute(&buf, struct {
+		styleParams
+		Title, Value, Color, TitleColor        string
+		Width, TitleWidth, ValueWidth, ShadowY int
+		TitleX, ValueX                         float64
+	}{
+		styleParams: st,
+		Title:       title,
+		Value:       value,
+		Color:       color,
+		TitleColor:  titleColor,
+		Width:       tw + vw,
+		TitleWidth:  tw,
+		ValueWidth:  vw,
+		ShadowY:     st.TextY + 1,
+		TitleX:      float64(tw) / 2,
+		ValueX:      float64(tw) + float64(vw)/2,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write sends the badge coloured according to the score.
+func Write(w http.ResponseWriter, title, value string, score float64, style Style) error {
+	return write(w, title, value, Color(score), style)
+}
+
+// WriteError sends a grey badge for repositories that could not be evaluated.
+func WriteError(w http.ResponseWriter, title string, style Style) error {
+	return write(w, title, UnknownValue, UnknownColor, style)
+}
+
+func write(w http.ResponseWriter, title, value, color string, style Style) error {
+	b, err := Render(title, value, color, style)
+	if err != nil {
+		return err
+	}
+
+	// Prevent proxies such as GitHub's camo from caching stale scores
+	w.Header().Set("Content-Type", "image/svg+xml;charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Expires", "0")
+	_, err = w.Write(b)
+	return err
+}
+
+// textWidth approximates the width in pixels of a text written
+// in 11px Verdana, including the horizontal padding.
+func textWidth(s string) int {
+	return int(math.Ceil(float64(utf8.RuneCountInString(s))*6.5)) + 10
+}
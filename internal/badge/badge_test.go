@@ -0,0 +1,86 @@
+package badge_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jgautheron/exago/internal/badge"
+)
+
+func TestColor(t *testing.T) {
+	var tests = []struct {
+		score    float64
+		expected string
+	}{
+		{100, "#4c1"},
+		{90, "#4c1"},
+		{85, "#97ca00"},
+		{72, "#a4a61d"},
+		{60, "#dfb317"},
+		{55, "#fe7d37"},
+		{10, "#e05d44"},
+		{-1, "#e05d44"},
+	}
+
+	for _, tt := range tests {
+		if c := badge.Color(tt.score); c != tt.expected {
+			t.Errorf("Wrong colour %s for score %.0f", c, tt.score)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	var tests = []struct {
+		title    string
+		value    string
+		style    badge.Style
+		contains []string
+	}{
+		{"coverage", "75.00%", badge.StyleFlat, []string{`height="20"`, ">coverage<", ">75.00%<"}},
+		{"", "A+", badge.StylePlastic, []string{`height="18"`, ">exago<", ">A+<"}},
+		{"<b>", "&", badge.StyleFlat, []string{">&lt;b&gt;<", ">&amp;<"}},
+	}
+
+	for _, tt := range tests {
+		b, err := badge.Render(tt.title, tt.value, "#4c1", tt.style)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range tt.contains {
+			if !strings.Contains(string(b), c) {
+				t.Errorf("The badge should contain %s", c)
+			}
+		}
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := badge.WriteError(w, "LOC", badge.ParseStyle("unknown-style")); err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "image/svg+xml") {
+		t.Errorf("Wrong content type %s", ct)
+	}
+	if !strings.Contains(w.Body.String(), ">"+badge.UnknownValue+"<") || !strings.Contains(w.Body.String(), badge.UnknownColor) {
+		t.Error("The badge should be unknown")
+	}
+}
+
+func TestNewEndpoint(t *testing.T) {
+	var tests = []struct {
+		endpoint badge.Endpoint
+		expected badge.Endpoint
+	}{
+		{badge.NewEndpoint("", "A+", 96), badge.Endpoint{SchemaVersion: 1, Label: "exago", Message: "A+", Color: "brightgreen"}},
+		{badge.NewEndpoint("coverage", "42.00%", 52), badge.Endpoint{SchemaVersion: 1, Label: "coverage", Message: "42.00%", Color: "orange"}},
+		{badge.NewErrorEndpoint("LOC"), badge.Endpoint{SchemaVersion: 1, Label: "LOC", Message: "unknown", Color: "lightgrey", IsError: true}},
+	}
+
+	for _, tt := range tests {
+		if tt.endpoint != tt.expected {
+			t.Errorf("Got %#v, expected %#v", tt.endpoint, tt.expected)
+		}
+	}
+}
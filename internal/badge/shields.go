@@ -0,0 +1,41 @@
+package badge
+
+// shieldsSchemaVersion is the only version of the endpoint schema supported by shields.io
+const shieldsSchemaVersion = 1
+
+// Endpoint is the JSON consumed by the shields.io endpoint badge,
+// see https://shields.io/endpoint.
+type Endpoint struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+	IsError       bool   `json:"isError,omitempty"`
+}
+
+// NewEndpoint builds the shields.io badge coloured according to the score.
+func NewEndpoint(title, value string, score float64) Endpoint {
+	if title == "" {
+		title = DefaultTitle
+	}
+	return Endpoint{
+		SchemaVersion: shieldsSchemaVersion,
+		Label:         title,
+		Message:       value,
+		Color:         ColorName(score),
+	}
+}
+
+// NewErrorEndpoint builds the shields.io badge of repositories that could not be evaluated.
+func NewErrorEndpoint(title string) Endpoint {
+	if title == "" {
+		title = DefaultTitle
+	}
+	return Endpoint{
+		SchemaVersion: shieldsSchemaVersion,
+		Label:         title,
+		Message:       UnknownValue,
+		Color:         UnknownColorName,
+		IsError:       true,
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/sirupsen/logrus"
@@ -16,15 +17,108 @@ type HTTPConfig struct {
 	HTTPPort       int      `envconfig:"HTTP_PORT" default:"8080"`
 	HTTPBind       string   `envconfig:"HTTP_BIND" default:"0.0.0.0"`
 	AllowedOrigins []string `envconfig:"ALLOWED_ORIGINS" default:"*"`
+
+	// Analyses allowed per client (IP) and per API key within the rate limit window, 0 disables the limit
+	RateLimitCount    int           `envconfig:"RATE_LIMIT_COUNT" default:"20"`
+	RateLimitKeyCount int           `envconfig:"RATE_LIMIT_KEY_COUNT" default:"500"`
+	RateLimitWindow   time.Duration `envconfig:"RATE_LIMIT_WINDOW" default:"4h"`
+	APIKeys           []string      `envconfig:"API_KEYS"`
+	// Pending jobs older than this are considered lost and no longer lock the repository
+	RequestLockTimeout time.Duration `envconfig:"REQUEST_LOCK_TIMEOUT" default:"30m"`
+}
+
+type ShutdownConfig struct {
+	// Time given to the requests and analyses in progress to complete on SIGTERM
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"30s"`
+	// Time during which the API reports that it is not ready before it stops
+	// accepting requests, for the load balancer to notice
+	ShutdownDrainDelay time.Duration `envconfig:"SHUTDOWN_DRAIN_DELAY" default:"5s"`
+}
+
+type RetryConfig struct {
+	// Attempts of an analysis failing transiently, unless the message sets maxAttempts
+	RetryMaxAttempts int `envconfig:"RETRY_MAX_ATTEMPTS" default:"3"`
+	// Delay before the first retry, doubled at each attempt up to RetryMaxBackoff
+	RetryBackoff    time.Duration `envconfig:"RETRY_BACKOFF" default:"30s"`
+	RetryMaxBackoff time.Duration `envconfig:"RETRY_MAX_BACKOFF" default:"10m"`
+}
+
+type PushConfig struct {
+	// PushEnabled serves the Pub/Sub push deliveries over HTTP instead of pulling the subscription
+	PushEnabled bool   `envconfig:"PUSH_ENABLED" default:"false"`
+	PushBind    string `envconfig:"PUSH_BIND" default:"0.0.0.0"`
+	PushPort    int    `envconfig:"PUSH_PORT" default:"8080"`
+	// Expected in the token query parameter of the push endpoint URL, the endpoint is open if empty
+	PushToken string `envconfig:"PUSH_TOKEN"`
+	// Analyses run at once, the deliveries beyond are nacked to be redelivered later
+	PushMaxConcurrent int `envconfig:"PUSH_MAX_CONCURRENT" default:"1"`
+	// Ack deadline of the push subscription, the analysis timeout must be lower
+	PushAckDeadline time.Duration `envconfig:"PUSH_ACK_DEADLINE" default:"10m"`
+}
+
+type ModulesConfig struct {
+	// GOPROXY and GOSUMDB of the analyses of modules (e.g. file:///var/cache/goproxy and off
+	// to run offline), those of the process environment are used if empty
+	AnalysisGoProxy string `envconfig:"ANALYSIS_GOPROXY"`
+	AnalysisGoSumDB string `envconfig:"ANALYSIS_GOSUMDB"`
+}
+
+type TimeoutConfig struct {
+	// Duration of a whole analysis, download included, 0 for no limit
+	AnalysisTimeout time.Duration `envconfig:"ANALYSIS_TIMEOUT" default:"20m"`
+	// Duration of each runner, overridden by runner name in RunnerTimeouts (e.g. test:15m,lint:5m)
+	RunnerTimeout  time.Duration            `envconfig:"RUNNER_TIMEOUT" default:"10m"`
+	RunnerTimeouts map[string]time.Duration `envconfig:"RUNNER_TIMEOUTS"`
+	// How often the analyses in progress check whether their job was cancelled through the API
+	CancelPollInterval time.Duration `envconfig:"CANCEL_POLL_INTERVAL" default:"10s"`
+}
+
+type UploadConfig struct {
+	// UploadEnabled lets the API analyze the archives sent to /upload itself, with the local Go toolchain
+	UploadEnabled bool `envconfig:"UPLOAD_ENABLED" default:"false"`
+	// Largest archive accepted, in bytes, compressed as well as extracted
+	UploadMaxSize int64 `envconfig:"UPLOAD_MAX_SIZE" default:"104857600"`
+	// Archives analyzed at once, the uploads beyond are refused
+	UploadMaxConcurrent int `envconfig:"UPLOAD_MAX_CONCURRENT" default:"1"`
+}
+
+type DeduplicationConfig struct {
+	// How long the messages handled are remembered, it should outlast the redeliveries
+	ClaimTTL time.Duration `envconfig:"CLAIM_TTL" default:"1h"`
+	// How long the claims of the messages in progress last unless renewed by the consumer
+	ClaimLease time.Duration `envconfig:"CLAIM_LEASE" default:"2m"`
+}
+
+type DatabaseConfig struct {
+	// DatabaseBackend is one of firestore, leveldb or memory
+	DatabaseBackend string `envconfig:"DATABASE_BACKEND" default:"firestore"`
+	// DatabasePath is the directory of the leveldb database
+	DatabasePath string `envconfig:"DATABASE_PATH" default:"exago.db"`
 }
 
 type GitHubConfig struct {
 	GithubAccessTokens []string `envconfig:"GITHUB_ACCESS_TOKENS" required:"true"`
+	// Secret shared with the GitHub webhooks, /hooks/github is disabled without it
+	GithubWebhookSecret string `envconfig:"GITHUB_WEBHOOK_SECRET"`
+	// Pushes to a branch within this delay trigger a single analysis of the last one
+	GithubWebhookDebounce time.Duration `envconfig:"GITHUB_WEBHOOK_DEBOUNCE" default:"1m"`
+	// Go version of the analyses triggered for branches never analyzed before
+	GithubWebhookGoVersion string `envconfig:"GITHUB_WEBHOOK_GO_VERSION" default:"1.13"`
 }
 
 type GoogleCloudConfig struct {
 	GoogleProjectID             string `envconfig:"GCLOUD_PROJECT_ID" required:"true"`
 	GooglePubSubTopicRepository string `envconfig:"GCLOUD_PUBSUB_TOPIC_REPOSITORY" required:"true"`
+	// Subscription shared by the consumers to pull the repositories to analyze
+	GooglePubSubSubscriptionRepository string `envconfig:"GCLOUD_PUBSUB_SUBSCRIPTION_REPOSITORY" default:"repository-consumer"`
+	GooglePubSubTopicProgress          string `envconfig:"GCLOUD_PUBSUB_TOPIC_PROGRESS" default:"progress"`
+	// Each API instance needs its own subscription to receive every progress event,
+	// it is named after the host and created on startup unless set
+	GooglePubSubSubscriptionProgress string `envconfig:"GCLOUD_PUBSUB_SUBSCRIPTION_PROGRESS"`
+	// Outcome of the analyses: REPOSITORY_PROCESSED, REPOSITORY_FAILED and SCORE_CHANGED
+	GooglePubSubTopicLifecycle string `envconfig:"GCLOUD_PUBSUB_TOPIC_LIFECYCLE" default:"lifecycle"`
+	// Messages the consumers gave up on, with the errors of the last attempt
+	GooglePubSubTopicDeadLetter string `envconfig:"GCLOUD_PUBSUB_TOPIC_DEAD_LETTER" default:"repository-dead-letter"`
 }
 
 func InitializeConfig(target interface{}) {
@@ -0,0 +1,68 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCancelPollInterval is how often the job of an analysis in progress is checked
+const DefaultCancelPollInterval = 10 * time.Second
+
+// DefaultTimeouts are used unless the consumer is given others.
+var DefaultTimeouts = task.Timeouts{
+	Analysis: 20 * time.Minute,
+	Runner:   10 * time.Minute,
+}
+
+// watchCancellation polls the job of the analysis until the context is done,
+// the analysis is cancelled once its job is cancelled through the API.
+func (c *Consumer) watchCancellation(ctx context.Context, ev eventpub.RepositoryAddedEvent, cancel context.CancelFunc) {
+	if c.cancelPoll <= 0 {
+		return
+	}
+	t := time.NewTicker(c.cancelPoll)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		job, err := c.db.GetJob(ctx, ev.Repository, ev.Branch, ev.GoVersion)
+		if err != nil {
+			if err != database.ErrNotFound && ctx.Err() == nil {
+				logrus.WithError(err).Warnf("Could not check whether the analysis of %s was cancelled", ev.Repository)
+			}
+			continue
+		}
+		if cancelled(job, ev.RequestID) {
+			logrus.WithField("repository", ev.Repository).Info("The analysis was cancelled, stopping it")
+			cancel()
+			return
+		}
+	}
+}
+
+// cancelled tells whether the request was cancelled through the API,
+// the job may track a later request meanwhile.
+func cancelled(job *database.Job, requestID string) bool {
+	return job.State == database.JobCancelled && job.RequestID == requestID
+}
+
+// interrupted ends an analysis stopped midway. Cancelled through the API,
+// it is acknowledged without results. Interrupted by the shutdown, it is
+// worth redelivering to another consumer.
+func (c *Consumer) interrupted(ctx context.Context, ev eventpub.RepositoryAddedEvent) error {
+	if err := ctx.Err(); err != nil {
+		return transientError("shutdown", err)
+	}
+	logrus.WithField("repository", ev.Repository).Info("The analysis was cancelled")
+	return nil
+}
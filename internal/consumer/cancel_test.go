@@ -0,0 +1,101 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/jgautheron/exago/internal/eventpub"
+)
+
+func TestWatchCancellation(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	db := memory.New()
+	c, _ := New(db, &fakePublisher{}, fakeHost{}, "1.13")
+	c.SetCancelPollInterval(10 * time.Millisecond)
+
+	job := database.NewJob(ev.Repository, ev.Branch, ev.GoVersion)
+	ev.RequestID = job.RequestID
+	c.saveJobState(context.Background(), job, database.JobRunning, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		c.watchCancellation(ctx, ev, cancel)
+		close(done)
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("The analysis should go on while its job is not cancelled")
+	}
+
+	job.SetState(database.JobCancelled, nil)
+	db.SaveJob(context.Background(), job)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("The analysis should be cancelled along with its job")
+	}
+	if ctx.Err() == nil {
+		t.Error("The context of the analysis should be cancelled")
+	}
+
+	// The state of a cancelled job is final
+	c.saveJobState(context.Background(), job, database.JobFailed, map[string]string{"test": "signal: killed"})
+	if current, _ := db.GetJob(context.Background(), ev.Repository, ev.Branch, ev.GoVersion); current.State != database.JobCancelled {
+		t.Errorf("Got state %s, the cancelled job should be kept", current.State)
+	}
+}
+
+func TestAnalyzeCancelled(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	db := memory.New()
+	evp := &fakePublisher{}
+	c, _ := New(db, evp, fakeHost{}, "1.13")
+
+	job := database.NewJob(ev.Repository, ev.Branch, ev.GoVersion)
+	ev.RequestID = job.RequestID
+	job.SetState(database.JobCancelled, nil)
+	db.SaveJob(context.Background(), job)
+
+	if err := c.analyze(context.Background(), ev, false); err != nil {
+		t.Errorf("Got %v, the cancelled job should be acknowledged", err)
+	}
+	if len(evp.events) != 0 {
+		t.Errorf("Got events %v, the cancelled job should not be analyzed", evp.events)
+	}
+	if current, _ := db.GetJob(context.Background(), ev.Repository, ev.Branch, ev.GoVersion); current.State != database.JobCancelled {
+		t.Errorf("Got state %s, the job should stay cancelled", current.State)
+	}
+}
+
+func TestCancellationOfAnotherRequest(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	db := memory.New()
+	c, _ := New(db, &fakePublisher{}, fakeHost{}, "1.13")
+
+	job := database.NewJob(ev.Repository, ev.Branch, ev.GoVersion)
+	job.SetState(database.JobCancelled, nil)
+	db.SaveJob(context.Background(), job)
+
+	c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+		job := c.loadJob(ctx, ev)
+		if job.State != database.JobQueued || job.RequestID != "2" {
+			t.Errorf("Got job %#v, a new one should track the request", job)
+		}
+		c.saveJobState(ctx, job, database.JobScored, nil)
+		return nil
+	}
+	// Published by another service, the event is tracked by its message
+	if err := c.ProcessRecord(context.Background(), repositoryMessage("2", ev)); err != nil {
+		t.Fatal(err)
+	}
+
+	if current, _ := db.GetJob(context.Background(), ev.Repository, ev.Branch, ev.GoVersion); current.State != database.JobScored {
+		t.Errorf("Got state %s, the cancellation of another request should not apply", current.State)
+	}
+}
@@ -0,0 +1,457 @@
+// Package consumer analyzes the repositories published by the API.
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/internal/github"
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/jgautheron/exago/pkg/analysis/score"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// metadataName is the key of the error raised while loading the repository metadata
+const metadataName = "metadata"
+
+// maxResultSize is the size of the results beyond which the coverage annotations
+// of the files are dropped, a Firestore document being limited to 1 MiB
+const maxResultSize = 900 << 10
+
+// PubSubMessage is the payload of a Pub/Sub event
+type PubSubMessage struct {
+	Message struct {
+		ID         string            `json:"id"`
+		Data       []byte            `json:"data,omitempty"`
+		Attributes map[string]string `json:"attributes"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+	// DeliveryAttempt is set by Pub/Sub, starting at 1, on the push
+	// deliveries of the subscriptions with a dead-letter policy
+	DeliveryAttempt int `json:"deliveryAttempt,omitempty"`
+
+	// push is set on the push deliveries, retried by Pub/Sub rather than in process
+	push bool
+}
+
+// Publisher sends the progress and the outcome of the analyses.
+type Publisher interface {
+	eventpub.ProgressPublisher
+	eventpub.LifecyclePublisher
+	eventpub.DeadLetterPublisher
+}
+
+type Consumer struct {
+	db   database.ResultStore
+	evp  Publisher
+	host github.RepositoryHost
+	// goVersion is the version of Go the consumer analyzes with,
+	// the repositories requested with another version are ignored.
+	// Empty to analyze every repository with the local toolchain.
+	goVersion string
+
+	retry RetryPolicy
+	// claimTTL is how long the messages handled are deduplicated,
+	// claimLease how long the claims of those in progress last unless renewed
+	claimTTL   time.Duration
+	claimLease time.Duration
+	// goProxy and goSumDB are used to download the dependencies of modules
+	goProxy string
+	goSumDB string
+	// timeouts bound the analyses, cancelPoll is how often their job is checked for cancellation
+	timeouts   task.Timeouts
+	cancelPoll time.Duration
+	// handle analyzes a repository, replaced in tests
+	handle func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error
+}
+
+// New creates new Consumer
+func New(db database.ResultStore, evp Publisher, host github.RepositoryHost, goVersion string) (*Consumer, error) {
+	c := &Consumer{
+		db:         db,
+		evp:        evp,
+		host:       host,
+		goVersion:  goVersion,
+		retry:      DefaultRetryPolicy,
+		claimTTL:   DefaultClaimTTL,
+		claimLease: DefaultClaimLease,
+		// Without limits, an analysis may hang forever on a stuck test
+		timeouts:   DefaultTimeouts,
+		cancelPoll: DefaultCancelPollInterval,
+	}
+	c.handle = c.analyze
+	return c, nil
+}
+
+// SetRetryPolicy changes how the analyses failing transiently are retried.
+func (c *Consumer) SetRetryPolicy(p RetryPolicy) {
+	c.retry = p
+}
+
+// SetClaimTTL changes how long the messages handled are deduplicated.
+func (c *Consumer) SetClaimTTL(ttl time.Duration) {
+	c.claimTTL = ttl
+}
+
+// SetClaimLease changes how long the claims of the messages in progress last
+// unless renewed, the messages of a consumer that crashed are redelivered past it.
+func (c *Consumer) SetClaimLease(lease time.Duration) {
+	c.claimLease = lease
+}
+
+// SetGoProxy changes the GOPROXY and GOSUMDB the dependencies of modules are
+// downloaded through, those of the process environment are used if empty.
+func (c *Consumer) SetGoProxy(proxy, sumdb string) {
+	c.goProxy = proxy
+	c.goSumDB = sumdb
+}
+
+// SetTimeouts changes the durations past which the analyses and their runners are interrupted.
+func (c *Consumer) SetTimeouts(t task.Timeouts) {
+	c.timeouts = t
+}
+
+// SetCancelPollInterval changes how often the analyses in progress check
+// whether their job was cancelled, 0 disables the cancellation.
+func (c *Consumer) SetCancelPollInterval(d time.Duration) {
+	c.cancelPoll = d
+}
+
+// Run receives the repositories to analyze until the context is cancelled.
+// The analyses in progress are then given shutdownTimeout to complete,
+// past which they are interrupted and their messages redelivered.
+func (c *Consumer) Run(ctx context.Context, sub eventpub.EventSubscriber, shutdownTimeout time.Duration) error {
+	// The analyses in progress keep their own context, they must not be
+	// interrupted as soon as the shutdown starts
+	work, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sub.ReceiveRepositoryEvents(ctx, func(msg *eventpub.Message) error {
+			var rec PubSubMessage
+			rec.Message.ID = msg.ID
+			rec.Message.Attributes = msg.Attributes
+			rec.Message.Data = msg.Data
+			err := c.ProcessRecord(work, rec)
+			switch {
+			case err == nil:
+			case isTransient(err):
+				// Failed transiently or interrupted by the shutdown, it is nacked
+				logrus.WithError(err).WithField("id", msg.ID).Warn("Could not process the message, it will be redelivered")
+				return err
+			default:
+				// Permanent failures are saved along with the results,
+				// or dead-lettered, there is no point in redelivering them
+				logrus.WithError(err).WithField("id", msg.ID).Error("Could not process the message")
+			}
+			return nil
+		})
+	}()
+
+	select {
+	case err := <-errc:
+		return errors.Wrap(err, "Stopped receiving repository events")
+	case <-ctx.Done():
+	}
+
+	select {
+	case <-errc:
+		logrus.Info("The analyses in progress completed")
+	case <-time.After(shutdownTimeout):
+		// The messages were not acknowledged, they will be redelivered
+		logrus.Warn("The analyses in progress did not complete in time")
+		stopWork()
+	}
+	return nil
+}
+
+// ProcessRecord handles data from a single record
+func (c *Consumer) ProcessRecord(ctx context.Context, r PubSubMessage) error {
+	eventType, _ := r.Message.Attributes["type"]
+	switch eventType {
+	case eventpub.TypeRepositoryAdded:
+		var ev eventpub.RepositoryAddedEvent
+		if err := json.Unmarshal(r.Message.Data, &ev); err != nil {
+			logrus.WithError(err).Error("Cannot unmarshal JSON payload")
+			// It would fail the same way however many times it is delivered
+			c.deadLetter(r, 1, err)
+			return err
+		}
+
+		// If this consumer's Go version is not the wanted one, ignore
+		if c.goVersion != "" && ev.GoVersion != c.goVersion {
+			return nil
+		}
+
+		// The events published by other services are tracked by their message
+		if ev.RequestID == "" {
+			ev.RequestID = r.Message.ID
+		}
+		return c.processOnce(ctx, r, ev)
+	}
+	return nil
+}
+
+// HandleRepositoryAddedEvent analyzes the repository, the job state is saved
+// at each stage so that clients can follow the progress.
+// The results are saved even if some runners failed, along with their errors.
+func (c *Consumer) HandleRepositoryAddedEvent(ctx context.Context, ev eventpub.RepositoryAddedEvent) error {
+	return c.analyze(ctx, ev, true)
+}
+
+// analyze runs the analysis, an *AnalysisError is returned if it failed.
+// Unless it is the last attempt, the job of an analysis failing transiently
+// goes back to the queue instead of failing.
+func (c *Consumer) analyze(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+	start := time.Now()
+	job := c.loadJob(ctx, ev)
+	if job.State == database.JobCancelled {
+		logrus.WithField("repository", ev.Repository).Info("The analysis was cancelled before it started, skipped")
+		return nil
+	}
+
+	// The commands are killed if the job is cancelled meanwhile
+	actx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go c.watchCancellation(actx, ev, cancel)
+
+	c.saveJobState(ctx, job, database.JobDownloading, nil)
+	m := task.NewManager(ev.Repository)
+	// The commit resolved when claiming the analysis is checked out rather than
+	// the branch, which may have moved since
+	ref := ev.Branch
+	if ev.Commit != "" {
+		ref = ev.Commit
+	}
+	m.UseReference(ref)
+	m.UseGoProxy(c.goProxy, c.goSumDB)
+	m.UseTimeouts(c.timeouts)
+	m.OnProgress(c.publishProgress(ev))
+	defer func() {
+		if err := m.Cleanup(); err != nil {
+			logrus.WithError(err).Warnf("Could not clean up the workspace of %s", ev.Repository)
+		}
+	}()
+	if err := m.Download(actx); err != nil {
+		if err == task.ErrCancelled {
+			return c.interrupted(ctx, ev)
+		}
+		return c.fail(ctx, job, ev, m.Commit(), start, newAnalysisError(m.Errors), lastAttempt)
+	}
+
+	c.saveJobState(ctx, job, database.JobRunning, nil)
+	m.Analyze(actx)
+	if actx.Err() != nil {
+		return c.interrupted(ctx, ev)
+	}
+	return c.complete(ctx, job, ev, m, start, lastAttempt)
+}
+
+// complete saves the results of the analysis, then publishes its outcome
+// along with the change of rank compared with the previous result.
+func (c *Consumer) complete(ctx context.Context, job *database.Job, ev eventpub.RepositoryAddedEvent, m *task.Manager, start time.Time, lastAttempt bool) error {
+	data, err := c.buildData(ctx, ev.Repository, m)
+	if err != nil {
+		return c.fail(ctx, job, ev, m.Commit(), start, newAnalysisError(map[string]string{"results": err.Error()}), lastAttempt)
+	}
+
+	previous, err := c.db.GetProject(ctx, ev.Repository, ev.Branch, ev.GoVersion)
+	if err != nil && err != database.ErrNotFound {
+		logrus.WithError(err).Warnf("Could not load the previous result of %s", ev.Repository)
+	}
+
+	p := &database.Project{
+		Repository:  ev.Repository,
+		Branch:      ev.Branch,
+		GoVersion:   ev.GoVersion,
+		Commit:      m.Commit(),
+		CommitDate:  database.OptionalDate(m.CommitDate()),
+		ProcessedAt: time.Now(),
+		Data:        data,
+	}
+	if err := c.db.SaveProject(ctx, p); err != nil {
+		return c.fail(ctx, job, ev, p.Commit, start, transientError("database", err), lastAttempt)
+	}
+
+	if previous != nil && previous.Data.Score.Rank != data.Score.Rank {
+		c.publish(eventpub.TypeScoreChanged, c.evp.ScoreChanged(&eventpub.ScoreChangedEvent{
+			Branch:        ev.Branch,
+			Repository:    ev.Repository,
+			GoVersion:     ev.GoVersion,
+			Commit:        p.Commit,
+			PreviousScore: previous.Data.Score.Value,
+			PreviousRank:  previous.Data.Score.Rank,
+			Score:         data.Score.Value,
+			Rank:          data.Score.Rank,
+		}))
+	}
+
+	if !m.Success {
+		return c.fail(ctx, job, ev, p.Commit, start, newAnalysisError(m.Errors), lastAttempt)
+	}
+	c.saveJobState(ctx, job, database.JobScored, nil)
+	c.publish(eventpub.TypeRepositoryProcessed, c.evp.RepositoryProcessed(&eventpub.RepositoryProcessedEvent{
+		Branch:     ev.Branch,
+		Repository: ev.Repository,
+		GoVersion:  ev.GoVersion,
+		Commit:     p.Commit,
+		Score:      data.Score.Value,
+		Rank:       data.Score.Rank,
+		Duration:   time.Since(start),
+	}))
+	return nil
+}
+
+// fail marks the job as failed and publishes the errors, which are returned.
+// The job is queued again instead if the analysis is to be retried.
+func (c *Consumer) fail(ctx context.Context, job *database.Job, ev eventpub.RepositoryAddedEvent, commit string, start time.Time, err *AnalysisError, lastAttempt bool) error {
+	if err.Transient && !lastAttempt {
+		c.saveJobState(ctx, job, database.JobQueued, err.Errors)
+		return err
+	}
+
+	c.saveJobState(ctx, job, database.JobFailed, err.Errors)
+	c.publish(eventpub.TypeRepositoryFailed, c.evp.RepositoryFailed(&eventpub.RepositoryFailedEvent{
+		Branch:     ev.Branch,
+		Repository: ev.Repository,
+		GoVersion:  ev.GoVersion,
+		Commit:     commit,
+		Errors:     err.Errors,
+		Duration:   time.Since(start),
+	}))
+	return err
+}
+
+// publish logs the failure to publish a lifecycle event,
+// the analysis itself is not affected.
+func (c *Consumer) publish(typ string, err error) {
+	if err != nil {
+		logrus.WithError(err).Warnf("Could not publish the %s event", typ)
+	}
+}
+
+// buildData scores the results of the runners and completes them
+// with the metadata of the repository.
+func (c *Consumer) buildData(ctx context.Context, repository string, m *task.Manager) (exago.Data, error) {
+	results, err := m.Results()
+	if err != nil {
+		return exago.Data{}, err
+	}
+	data := score.Data(results, m.Errors)
+
+	// The metadata is only displayed, the results are still worth saving without it
+	if data.Metadata, err = c.metadata(ctx, repository); err != nil {
+		logrus.WithError(err).Warnf("Could not load the metadata of %s", repository)
+		data.Errors[metadataName] = err.Error()
+	}
+	return data, fitResults(repository, &data)
+}
+
+// fitResults drops the coverage annotations of the files if the results would
+// not fit in a document, the coverage of the packages is kept. An error is
+// returned if they are still too large.
+func fitResults(repository string, data *exago.Data) error {
+	size := resultSize(data)
+	if size <= maxResultSize {
+		return nil
+	}
+	logrus.WithField("size", size).Warnf("The results of %s are too large, the coverage annotations are dropped", repository)
+	packages := data.Results.Coverage.Data.Packages
+	for i := range packages {
+		packages[i].Files = nil
+	}
+
+	if size = resultSize(data); size > maxResultSize {
+		return errors.Errorf("The results are too large to be stored (%d bytes)", size)
+	}
+	return nil
+}
+
+// resultSize estimates the size of the stored results by their JSON encoding.
+func resultSize(data *exago.Data) int {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// metadata loads the description, avatar and popularity of the repository from GitHub.
+func (c *Consumer) metadata(ctx context.Context, repository string) (exago.Metadata, error) {
+	var meta exago.Metadata
+	owner, name, ok := github.ParseRepository(repository)
+	if !ok {
+		return meta, fmt.Errorf("%s is not a GitHub repository", repository)
+	}
+
+	repo, err := c.host.Get(ctx, owner, name)
+	if err != nil {
+		return meta, err
+	}
+	meta.Image, _ = repo["avatar_url"].(string)
+	meta.Description, _ = repo["description"].(string)
+	meta.Stars, _ = repo["stargazers"].(int)
+	meta.LastPush, _ = repo["last_push"].(time.Time)
+	return meta, nil
+}
+
+// loadJob returns the job queued by the API for the request of the event.
+// A new one replaces the job of another request, e.g. if the event was
+// published by another service, so that a cancelled request is not mistaken
+// for this one.
+func (c *Consumer) loadJob(ctx context.Context, ev eventpub.RepositoryAddedEvent) *database.Job {
+	job, err := c.db.GetJob(ctx, ev.Repository, ev.Branch, ev.GoVersion)
+	if err != nil || job.RequestID != ev.RequestID {
+		if err != nil && err != database.ErrNotFound {
+			logrus.WithError(err).Error("Could not load job")
+		}
+		job = database.NewJob(ev.Repository, ev.Branch, ev.GoVersion)
+		job.RequestID = ev.RequestID
+	}
+	return job
+}
+
+// saveJobState moves the job to the given state, failing to save
+// is logged but does not interrupt the analysis. The job cancelled
+// through the API meanwhile is left as is.
+func (c *Consumer) saveJobState(ctx context.Context, job *database.Job, state database.JobState, errs map[string]string) {
+	if current, err := c.db.GetJob(ctx, job.Repository, job.Branch, job.GoVersion); err == nil && cancelled(current, job.RequestID) {
+		return
+	}
+	job.SetState(state, errs)
+	if err := c.db.SaveJob(ctx, job); err != nil {
+		logrus.WithError(err).WithField("state", state).Errorf("Could not save job %s", job.ID())
+	}
+}
+
+// publishProgress forwards the progress of the runners to the API,
+// which streams it to the clients following the analysis.
+func (c *Consumer) publishProgress(ev eventpub.RepositoryAddedEvent) task.ProgressFunc {
+	return func(p task.Progress) {
+		pev := &eventpub.RunnerProgressEvent{
+			Branch:     ev.Branch,
+			Repository: ev.Repository,
+			GoVersion:  ev.GoVersion,
+			Runner:     p.Runner,
+			Label:      p.Label,
+			Status:     eventpub.ProgressStarted,
+		}
+		if p.Finished {
+			pev.Status = eventpub.ProgressFinished
+			pev.ExecutionTime = p.ExecutionTime
+		}
+		if p.Err != nil {
+			pev.Error = p.Err.Error()
+		}
+		if err := c.evp.RunnerProgress(pev); err != nil {
+			logrus.WithError(err).Warn("Could not publish the runner progress")
+		}
+	}
+}
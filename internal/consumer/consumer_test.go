@@ -0,0 +1,274 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/internal/github"
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+)
+
+// headCommit is the head of every branch of github.com/foo/bar.
+const headCommit = "0123456789abcdef0123456789abcdef01234567"
+
+// fakeHost knows a single repository, github.com/foo/bar.
+type fakeHost struct{}
+
+func (fakeHost) GetFileContent(ctx context.Context, owner, repository, path, ref string) (string, error) {
+	return "", github.ErrNotFound
+}
+
+func (fakeHost) Get(ctx context.Context, owner, repository string) (map[string]interface{}, error) {
+	if owner+"/"+repository != "foo/bar" {
+		return nil, github.ErrNotFound
+	}
+	return map[string]interface{}{
+		"html_url":    "https://github.com/foo/bar",
+		"avatar_url":  "https://avatars.githubusercontent.com/u/1",
+		"description": "Bar does foo",
+		"languages":   map[string]int{"Go": 100},
+		"stargazers":  42,
+		"last_push":   time.Now(),
+	}, nil
+}
+
+func (fakeHost) HasBranch(ctx context.Context, owner, repository, branch string) (bool, error) {
+	return true, nil
+}
+
+func (fakeHost) BranchHead(ctx context.Context, owner, repository, branch string) (string, error) {
+	if owner+"/"+repository != "foo/bar" {
+		return "", github.ErrNotFound
+	}
+	return headCommit, nil
+}
+
+// fakePublisher records the lifecycle events by type.
+type fakePublisher struct {
+	events map[string][]interface{}
+}
+
+func (p *fakePublisher) record(typ string, event interface{}) error {
+	if p.events == nil {
+		p.events = make(map[string][]interface{})
+	}
+	p.events[typ] = append(p.events[typ], event)
+	return nil
+}
+
+func (p *fakePublisher) RunnerProgress(event *eventpub.RunnerProgressEvent) error {
+	return nil
+}
+
+func (p *fakePublisher) RepositoryProcessed(event *eventpub.RepositoryProcessedEvent) error {
+	return p.record(eventpub.TypeRepositoryProcessed, event)
+}
+
+func (p *fakePublisher) RepositoryFailed(event *eventpub.RepositoryFailedEvent) error {
+	return p.record(eventpub.TypeRepositoryFailed, event)
+}
+
+func (p *fakePublisher) ScoreChanged(event *eventpub.ScoreChangedEvent) error {
+	return p.record(eventpub.TypeScoreChanged, event)
+}
+
+func (p *fakePublisher) DeadLetter(event *eventpub.DeadLetterEvent) error {
+	return p.record(eventpub.TypeDeadLetter, event)
+}
+
+type stubRunner struct {
+	task.Runner
+	err error
+}
+
+func (r *stubRunner) Execute(ctx context.Context) error {
+	return r.err
+}
+
+func TestBuildData(t *testing.T) {
+	c, _ := New(memory.New(), &fakePublisher{}, fakeHost{}, "1.13")
+
+	var tests = []struct {
+		repository string
+		errors     map[string]string
+		stars      int
+	}{
+		{"github.com/foo/bar", map[string]string{}, 42},
+		{"github.com/foo/unknown", map[string]string{metadataName: github.ErrNotFound.Error()}, 0},
+	}
+
+	for _, tt := range tests {
+		m := task.NewManager(tt.repository)
+		m.Runners = map[string]task.Runnable{
+			"thirdparties": &stubRunner{Runner: task.Runner{Label: "Go List", Mgr: m, Data: []string{"github.com/pkg/errors"}}},
+		}
+		m.Analyze(context.Background())
+
+		data, err := c.buildData(context.Background(), tt.repository, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Results.ThirdParties.Data) != 1 {
+			t.Errorf("%s: the results should be kept", tt.repository)
+		}
+		if data.Score.Rank == "" || len(data.Score.Details) == 0 {
+			t.Errorf("%s: the results should be scored, got %#v", tt.repository, data.Score)
+		}
+		if data.Metadata.Stars != tt.stars {
+			t.Errorf("%s: got %d stars, expected %d", tt.repository, data.Metadata.Stars, tt.stars)
+		}
+		if len(data.Errors) != len(tt.errors) || data.Errors[metadataName] != tt.errors[metadataName] {
+			t.Errorf("%s: got errors %v, expected %v", tt.repository, data.Errors, tt.errors)
+		}
+	}
+}
+
+func TestFitResults(t *testing.T) {
+	var data exago.Data
+	data.Results.Coverage.Data.Packages = []exago.CoveragePackage{{Name: "bar", Coverage: 50}}
+	if err := fitResults("github.com/foo/bar", &data); err != nil {
+		t.Fatal(err)
+	}
+
+	// The annotations of a large repository exceed the size of a document
+	var blocks []exago.CoverageBlock
+	for n := 1; n < 100000; n += 2 {
+		blocks = append(blocks, exago.CoverageBlock{Start: n, End: n, Covered: true})
+	}
+	data.Results.Coverage.Data.Packages[0].Files = []exago.CoverageFile{{Path: "bar.go", Blocks: blocks}}
+	if err := fitResults("github.com/foo/bar", &data); err != nil {
+		t.Fatal(err)
+	}
+	if pkg := data.Results.Coverage.Data.Packages[0]; pkg.Files != nil || pkg.Coverage != 50 {
+		t.Errorf("Got %d files and %.f%% coverage, only the annotations should be dropped", len(pkg.Files), pkg.Coverage)
+	}
+
+	data.Results.Coverage.RawOutput = strings.Repeat("ok", maxResultSize)
+	if err := fitResults("github.com/foo/bar", &data); err == nil {
+		t.Error("The results too large to be stored should fail")
+	}
+}
+
+func TestComplete(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+
+	var tests = []struct {
+		previousRank string
+		err          error
+		expected     map[string]int
+		state        database.JobState
+	}{
+		// First result, nothing to compare the rank with
+		{"", nil, map[string]int{eventpub.TypeRepositoryProcessed: 1}, database.JobScored},
+		{"E-", nil, map[string]int{eventpub.TypeRepositoryProcessed: 1}, database.JobScored},
+		{"A", nil, map[string]int{eventpub.TypeRepositoryProcessed: 1, eventpub.TypeScoreChanged: 1}, database.JobScored},
+		{"A", errors.New("exit status 1"), map[string]int{eventpub.TypeRepositoryFailed: 1, eventpub.TypeScoreChanged: 1}, database.JobFailed},
+	}
+
+	for i, tt := range tests {
+		db := memory.New()
+		if tt.previousRank != "" {
+			p := &database.Project{Repository: ev.Repository, Branch: ev.Branch, GoVersion: ev.GoVersion, ProcessedAt: time.Now()}
+			p.Data.Score.Value, p.Data.Score.Rank = 50.4, tt.previousRank
+			db.SaveProject(context.Background(), p)
+		}
+		evp := &fakePublisher{}
+		c, _ := New(db, evp, fakeHost{}, "1.13")
+
+		m := task.NewManager(ev.Repository)
+		m.Runners = map[string]task.Runnable{
+			"thirdparties": &stubRunner{Runner: task.Runner{Label: "Go List", Mgr: m, Data: []string{"github.com/pkg/errors"}}, err: tt.err},
+		}
+		m.Analyze(context.Background())
+
+		job := database.NewJob(ev.Repository, ev.Branch, ev.GoVersion)
+		c.complete(context.Background(), job, ev, m, time.Now(), true)
+
+		if job.State != tt.state {
+			t.Errorf("#%d: got state %s, expected %s", i, job.State, tt.state)
+		}
+		if len(evp.events) != len(tt.expected) {
+			t.Errorf("#%d: got events %v, expected %v", i, evp.events, tt.expected)
+		}
+		for typ, n := range tt.expected {
+			if len(evp.events[typ]) != n {
+				t.Errorf("#%d: got %d %s events, expected %d", i, len(evp.events[typ]), typ, n)
+			}
+		}
+
+		for _, e := range evp.events[eventpub.TypeScoreChanged] {
+			if changed := e.(*eventpub.ScoreChangedEvent); changed.PreviousRank != tt.previousRank || changed.Rank == tt.previousRank {
+				t.Errorf("#%d: wrong score change %#v", i, changed)
+			}
+		}
+		for _, e := range evp.events[eventpub.TypeRepositoryFailed] {
+			if failed := e.(*eventpub.RepositoryFailedEvent); failed.Errors["thirdparties"] != "exit status 1" {
+				t.Errorf("#%d: the runner errors should be published, got %v", i, failed.Errors)
+			}
+		}
+	}
+}
+
+// fakeSubscriber delivers its messages once, recording those nacked.
+type fakeSubscriber struct {
+	messages []PubSubMessage
+	nacked   []string
+	done     chan struct{}
+}
+
+func (s *fakeSubscriber) ReceiveRepositoryEvents(ctx context.Context, fn func(*eventpub.Message) error) error {
+	defer close(s.done)
+	for _, rec := range s.messages {
+		msg := &eventpub.Message{ID: rec.Message.ID, Attributes: rec.Message.Attributes, Data: rec.Message.Data}
+		if err := fn(msg); err != nil {
+			s.nacked = append(s.nacked, msg.ID)
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (s *fakeSubscriber) ReceiveRunnerProgress(ctx context.Context, fn func(*eventpub.RunnerProgressEvent)) error {
+	return nil
+}
+
+func TestRunAcknowledgement(t *testing.T) {
+	c, _ := New(memory.New(), &fakePublisher{}, fakeHost{}, "1.13")
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Hour})
+	c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+		switch ev.Branch {
+		case "failed":
+			return newAnalysisError(map[string]string{"test": "exit status 1"})
+		case "interrupted":
+			// Waits for the retry until the analyses in progress are interrupted
+			return newAnalysisError(map[string]string{"download": "dial tcp: i/o timeout"})
+		}
+		return nil
+	}
+
+	sub := &fakeSubscriber{done: make(chan struct{})}
+	for i, branch := range []string{"master", "failed", "interrupted"} {
+		ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: branch, GoVersion: "1.13"}
+		sub.messages = append(sub.messages, repositoryMessage(strconv.Itoa(i), ev))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := c.Run(ctx, sub, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	<-sub.done
+
+	// The successes and the permanent failures are acknowledged
+	if len(sub.nacked) != 1 || sub.nacked[0] != "2" {
+		t.Errorf("Got %v nacked, expected the interrupted message", sub.nacked)
+	}
+}
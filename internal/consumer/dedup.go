@@ -0,0 +1,191 @@
+package consumer
+
+import (
+	"context"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/internal/github"
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultClaimTTL is how long the messages handled are remembered unless
+// the consumer is given another duration, it should outlast the redeliveries.
+const DefaultClaimTTL = time.Hour
+
+// DefaultClaimLease is how long the claims of a message in progress last unless
+// the consumer is given another duration. They are renewed until the message
+// is handled, those of a consumer that crashed are lifted once the lease expires.
+const DefaultClaimLease = 2 * time.Minute
+
+// messageKey identifies the claim of a Pub/Sub message.
+func messageKey(id string) string {
+	return "message:" + id
+}
+
+// analysisKey identifies the claim of the analysis of a commit.
+func analysisKey(ev eventpub.RepositoryAddedEvent, commit string) string {
+	return "analysis:" + database.ProjectID(ev.Repository, ev.Branch, ev.GoVersion) + "@" + commit
+}
+
+// processOnce makes sure that the message and the commit it points to are
+// analyzed once, Pub/Sub delivering the messages at least once and the API
+// possibly queueing the same commit twice. The claims are leased before
+// running, the duplicates are acknowledged without being analyzed.
+// Once handled, the message stays claimed for the claim TTL.
+func (c *Consumer) processOnce(ctx context.Context, r PubSubMessage, ev eventpub.RepositoryAddedEvent) error {
+	if r.Message.ID == "" {
+		return c.processClaimed(ctx, r, ev, nil)
+	}
+
+	key := messageKey(r.Message.ID)
+	claimed, err := c.db.Claim(ctx, key, c.claimLease)
+	if err != nil {
+		return transientError("claim", errors.Wrap(err, "Could not claim the message"))
+	}
+	if !claimed {
+		logrus.WithFields(logrus.Fields{"id": r.Message.ID, "repository": ev.Repository}).Info("Duplicate delivery, skipped")
+		return nil
+	}
+
+	err = c.processClaimed(ctx, r, ev, []string{key})
+	if err != nil && isTransient(err) {
+		// The message is nacked, its redelivery is worth processing
+		// e.g. if the consumer was shut down
+		c.release(key)
+	} else {
+		c.renew(key, c.claimTTL)
+	}
+	return err
+}
+
+// processClaimed claims the commit the message points to, then processes the
+// message unless the commit is already being analyzed or analyzed. The claims
+// are renewed until it returns, the one of the commit is then released.
+func (c *Consumer) processClaimed(ctx context.Context, r PubSubMessage, ev eventpub.RepositoryAddedEvent, keys []string) error {
+	log := logrus.WithFields(logrus.Fields{"id": r.Message.ID, "repository": ev.Repository, "branch": ev.Branch})
+
+	commit := c.resolveCommit(ctx, ev)
+	if commit != "" {
+		ev.Commit = commit
+		key := analysisKey(ev, commit)
+		claimed, err := c.db.Claim(ctx, key, c.claimLease)
+		if err != nil {
+			return transientError("claim", errors.Wrap(err, "Could not claim the analysis"))
+		}
+		if !claimed {
+			log.WithField("commit", commit).Info("The commit is already being analyzed, skipped")
+			return nil
+		}
+		// The stored results tell whether the commit was analyzed already
+		defer c.release(key)
+		keys = append(keys, key)
+	}
+
+	stop := c.renewClaims(keys)
+	defer stop()
+
+	if commit != "" && c.analyzed(ctx, ev, commit) {
+		log.WithField("commit", commit).Info("The commit is already analyzed, skipped")
+		return nil
+	}
+	return c.processWithRetry(ctx, r, ev)
+}
+
+// renewClaims extends the leases of the claims periodically, until the function
+// returned is called. The renewal in progress is over once it returns.
+func (c *Consumer) renewClaims(keys []string) func() {
+	interval := c.claimLease / 3
+	if len(keys) == 0 || interval <= 0 {
+		return func() {}
+	}
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			for _, key := range keys {
+				c.renew(key, c.claimLease)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// resolveCommit returns the commit to analyze, the head of the branch unless
+// the event points to a commit. It is empty if the head could not be resolved,
+// only the message is deduplicated then.
+func (c *Consumer) resolveCommit(ctx context.Context, ev eventpub.RepositoryAddedEvent) string {
+	if ev.Commit != "" {
+		return ev.Commit
+	}
+	owner, name, ok := github.ParseRepository(ev.Repository)
+	if !ok {
+		return ""
+	}
+	commit, err := c.host.BranchHead(ctx, owner, name, ev.Branch)
+	if err != nil {
+		logrus.WithError(err).Warnf("Could not resolve the head of %s@%s", ev.Repository, ev.Branch)
+		return ""
+	}
+	return commit
+}
+
+// analyzed tells whether the latest results are the complete ones of the commit,
+// the job queued again by the API is then restored to point to them. The partial
+// results, saved although some runners failed (e.g. the tests timed out), are
+// worth analyzing again.
+func (c *Consumer) analyzed(ctx context.Context, ev eventpub.RepositoryAddedEvent, commit string) bool {
+	p, err := c.db.GetProject(ctx, ev.Repository, ev.Branch, ev.GoVersion)
+	if err != nil {
+		if err != database.ErrNotFound {
+			logrus.WithError(err).Warnf("Could not load the latest result of %s", ev.Repository)
+		}
+		return false
+	}
+	if p.Commit != commit || partial(p.Data) {
+		return false
+	}
+
+	if job := c.loadJob(ctx, ev); job.Pending() {
+		c.saveJobState(ctx, job, database.JobScored, nil)
+	}
+	return true
+}
+
+// partial tells whether some runners failed, the metadata is not a runner.
+func partial(data exago.Data) bool {
+	for name := range data.Errors {
+		if name != metadataName {
+			return true
+		}
+	}
+	return false
+}
+
+// renew sets the claim to expire after ttl, even if the analysis was interrupted.
+func (c *Consumer) renew(key string, ttl time.Duration) {
+	if err := c.db.Renew(context.Background(), key, ttl); err != nil {
+		logrus.WithError(err).Warnf("Could not renew the claim %s", key)
+	}
+}
+
+// release removes the claim, even if the analysis was interrupted.
+func (c *Consumer) release(key string) {
+	if err := c.db.Release(context.Background(), key); err != nil {
+		logrus.WithError(err).Warnf("Could not release the claim %s", key)
+	}
+}
@@ -0,0 +1,181 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/jgautheron/exago/internal/eventpub"
+)
+
+func repositoryMessage(id string, ev eventpub.RepositoryAddedEvent) PubSubMessage {
+	var rec PubSubMessage
+	rec.Message.ID = id
+	rec.Message.Attributes = map[string]string{"type": eventpub.TypeRepositoryAdded}
+	rec.Message.Data, _ = json.Marshal(ev)
+	return rec
+}
+
+func TestProcessRecordDuplicates(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	transient := newAnalysisError(map[string]string{"download": "dial tcp: i/o timeout"})
+
+	var tests = []struct {
+		desc     string
+		ids      []string
+		outcome  error
+		expected int
+	}{
+		{"Redelivered message", []string{"1", "1"}, nil, 1},
+		{"Same commit queued twice", []string{"1", "2"}, nil, 1},
+		{"Redelivered after an interruption", []string{"1", "1"}, transient, 2},
+		{"Unknown message IDs", []string{"", ""}, transient, 2},
+	}
+
+	for _, tt := range tests {
+		c, _ := New(memory.New(), &fakePublisher{}, fakeHost{}, "1.13")
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Hour})
+
+		var analyses int
+		c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+			analyses++
+			if tt.outcome == nil {
+				c.db.SaveProject(ctx, &database.Project{Repository: ev.Repository, Branch: ev.Branch, GoVersion: ev.GoVersion, Commit: headCommit})
+			}
+			return tt.outcome
+		}
+
+		// The transient failures are not retried but interrupted, as on shutdown
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		for _, id := range tt.ids {
+			c.ProcessRecord(ctx, repositoryMessage(id, ev))
+		}
+		if analyses != tt.expected {
+			t.Errorf("%s: got %d analyses, expected %d", tt.desc, analyses, tt.expected)
+		}
+	}
+}
+
+func TestProcessRecordConcurrentDuplicate(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	c, _ := New(memory.New(), &fakePublisher{}, fakeHost{}, "1.13")
+
+	var analyses int
+	c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+		analyses++
+		if ev.Commit != headCommit {
+			t.Errorf("Got commit %q, the head of the branch should be analyzed", ev.Commit)
+		}
+		// The same commit is queued again while it is analyzed
+		if err := c.ProcessRecord(ctx, repositoryMessage("2", ev)); err != nil {
+			t.Errorf("The duplicate should be acknowledged, got %v", err)
+		}
+		return nil
+	}
+
+	if err := c.ProcessRecord(context.Background(), repositoryMessage("1", ev)); err != nil {
+		t.Fatal(err)
+	}
+	if analyses != 1 {
+		t.Errorf("Got %d analyses, the duplicate should be skipped", analyses)
+	}
+	if claimed, _ := c.db.Claim(context.Background(), analysisKey(ev, headCommit), DefaultClaimTTL); !claimed {
+		t.Error("The claim of the commit should be released once analyzed")
+	}
+}
+
+func TestProcessRecordAnalyzedCommit(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	db := memory.New()
+	db.SaveProject(context.Background(), &database.Project{Repository: ev.Repository, Branch: ev.Branch, GoVersion: ev.GoVersion, Commit: headCommit})
+	// Queued again by the API
+	db.SaveJob(context.Background(), database.NewJob(ev.Repository, ev.Branch, ev.GoVersion))
+
+	c, _ := New(db, &fakePublisher{}, fakeHost{}, "1.13")
+	c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+		t.Error("The commit is already analyzed")
+		return nil
+	}
+	if err := c.ProcessRecord(context.Background(), repositoryMessage("1", ev)); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := db.GetJob(context.Background(), ev.Repository, ev.Branch, ev.GoVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != database.JobScored {
+		t.Errorf("Got job state %s, it should point to the stored results", job.State)
+	}
+}
+
+func TestProcessRecordPartialResults(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+
+	var tests = []struct {
+		errors   map[string]string
+		expected int
+	}{
+		{nil, 0},
+		{map[string]string{metadataName: "GET https://api.github.com/repos/foo/bar: 502 Bad Gateway"}, 0},
+		{map[string]string{"test": "context deadline exceeded"}, 1},
+	}
+
+	for _, tt := range tests {
+		db := memory.New()
+		p := &database.Project{Repository: ev.Repository, Branch: ev.Branch, GoVersion: ev.GoVersion, Commit: headCommit}
+		p.Data.Errors = tt.errors
+		db.SaveProject(context.Background(), p)
+
+		c, _ := New(db, &fakePublisher{}, fakeHost{}, "1.13")
+		var analyses int
+		c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+			analyses++
+			return nil
+		}
+		if err := c.ProcessRecord(context.Background(), repositoryMessage("1", ev)); err != nil {
+			t.Fatal(err)
+		}
+		if analyses != tt.expected {
+			t.Errorf("%v: got %d analyses, expected %d", tt.errors, analyses, tt.expected)
+		}
+	}
+}
+
+func TestProcessRecordLease(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	lease := 30 * time.Millisecond
+	db := memory.New()
+	c, _ := New(db, &fakePublisher{}, fakeHost{}, "1.13")
+	c.SetClaimLease(lease)
+
+	var analyses int
+	c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+		analyses++
+		// The claims outlast the lease while the message is processed
+		time.Sleep(3 * lease)
+		if claimed, _ := db.Claim(ctx, analysisKey(ev, headCommit), time.Hour); claimed {
+			t.Error("The claim of the commit should be renewed while it is analyzed")
+		}
+		return nil
+	}
+
+	// The claim left by a consumer that crashed is lifted once its lease expires
+	db.Claim(context.Background(), messageKey("1"), lease)
+	time.Sleep(2 * lease)
+	if err := c.ProcessRecord(context.Background(), repositoryMessage("1", ev)); err != nil {
+		t.Fatal(err)
+	}
+	if analyses != 1 {
+		t.Errorf("Got %d analyses, the message should be redelivered past the lease", analyses)
+	}
+
+	time.Sleep(2 * lease)
+	if claimed, _ := db.Claim(context.Background(), messageKey("1"), time.Hour); claimed {
+		t.Error("The message should stay claimed for the claim TTL once handled")
+	}
+}
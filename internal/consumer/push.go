@@ -0,0 +1,139 @@
+package consumer
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// PushOptions configures the endpoint receiving the Pub/Sub push deliveries.
+type PushOptions struct {
+	// Token is expected in the token query parameter of the
+	// push endpoint URL, the endpoint is open if empty
+	Token string
+	// MaxConcurrent is the number of analyses run at once,
+	// the deliveries beyond are nacked to be redelivered later
+	MaxConcurrent int
+	// AckDeadline is the ack deadline of the push subscription,
+	// which the analysis timeout must not reach
+	AckDeadline time.Duration
+}
+
+// ErrAckDeadline is returned by ServePush if an analysis may outlast the ack
+// deadline, Pub/Sub would then redeliver the message while it is analyzed.
+var ErrAckDeadline = errors.New("The analysis timeout must be set and lower than the ack deadline of the push subscription")
+
+// ServePush receives the repositories to analyze from a Pub/Sub push
+// subscription on addr, until the context is cancelled. The analyses in
+// progress are then given shutdownTimeout to complete, past which they are
+// interrupted and their messages nacked.
+func (c *Consumer) ServePush(ctx context.Context, addr string, opts PushOptions, shutdownTimeout time.Duration) error {
+	if c.timeouts.Analysis <= 0 || c.timeouts.Analysis >= opts.AckDeadline {
+		return ErrAckDeadline
+	}
+
+	// The analyses in progress keep their own context, they must not be
+	// interrupted as soon as the shutdown starts
+	work, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: c.pushRoutes(work, opts),
+	}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return errors.Wrap(err, "Stopped receiving push deliveries")
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// The messages are nacked, they will be redelivered
+		logrus.Warn("The analyses in progress did not complete in time")
+		stopWork()
+		return nil
+	}
+	logrus.Info("The analyses in progress completed")
+	return nil
+}
+
+// pushRoutes registers the push endpoint, the analyses run with the work context.
+func (c *Consumer) pushRoutes(work context.Context, opts PushOptions) http.Handler {
+	if opts.MaxConcurrent < 1 {
+		opts.MaxConcurrent = 1
+	}
+	h := &pushHandler{
+		c:     c,
+		work:  work,
+		token: opts.Token,
+		slots: make(chan struct{}, opts.MaxConcurrent),
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.Heartbeat("/ping"))
+	r.Use(middleware.Recoverer)
+	r.Post("/", h.ServeHTTP)
+	return r
+}
+
+// pushHandler dispatches the push deliveries to ProcessRecord. Pub/Sub
+// acknowledges the message on a 2xx status code and redelivers it otherwise.
+type pushHandler struct {
+	c     *Consumer
+	work  context.Context
+	token string
+	// slots holds a value for each analysis in progress
+	slots chan struct{}
+}
+
+func (h *pushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(h.token)) != 1 {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var rec PubSubMessage
+	if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+		logrus.WithError(err).Error("Cannot unmarshal the push delivery")
+		http.Error(w, "Invalid Pub/Sub message", http.StatusBadRequest)
+		return
+	}
+	rec.push = true
+
+	select {
+	case h.slots <- struct{}{}:
+		defer func() { <-h.slots }()
+	default:
+		// Pub/Sub slows down the push deliveries as they get nacked
+		http.Error(w, "Too many analyses in progress", http.StatusTooManyRequests)
+		return
+	}
+
+	err := h.c.ProcessRecord(h.work, rec)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case isTransient(err):
+		logrus.WithError(err).WithField("id", rec.Message.ID).Warn("Could not process the message, it will be redelivered")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		// Permanent failures are saved along with the results,
+		// or dead-lettered, there is no point in redelivering them
+		logrus.WithError(err).WithField("id", rec.Message.ID).Error("Could not process the message")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
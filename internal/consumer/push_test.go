@@ -0,0 +1,128 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+)
+
+func pushRequest(t *testing.T, target string, rec PubSubMessage) *http.Request {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+}
+
+func TestPushHandler(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	transient := newAnalysisError(map[string]string{"download": "dial tcp: i/o timeout"})
+	permanent := newAnalysisError(map[string]string{"download": "unrecognized import path"})
+
+	var tests = []struct {
+		desc   string
+		target string
+		// envelope replaces the push delivery of the event if set
+		envelope []byte
+		data     []byte
+		attempt  int
+		outcome  error
+		expected int
+		// deadLetter is set if the message is given up on
+		deadLetter bool
+	}{
+		{"Analyzed", "/?token=secret", nil, nil, 0, nil, http.StatusNoContent, false},
+		{"Permanent failure", "/?token=secret", nil, nil, 0, permanent, http.StatusNoContent, false},
+		// The push deliveries are retried by Pub/Sub rather than waiting for the backoff
+		{"Transient failure", "/?token=secret", nil, nil, 0, transient, http.StatusServiceUnavailable, false},
+		{"Transient failure of the last attempt", "/?token=secret", nil, nil, 2, transient, http.StatusNoContent, true},
+		{"Malformed payload", "/?token=secret", nil, []byte("{"), 0, nil, http.StatusNoContent, true},
+		{"Malformed envelope", "/?token=secret", []byte("{"), nil, 0, nil, http.StatusBadRequest, false},
+		{"Invalid token", "/?token=guess", nil, nil, 0, nil, http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		evp := &fakePublisher{}
+		c, _ := New(memory.New(), evp, fakeHost{}, "1.13")
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Hour})
+		var lastAttempt bool
+		c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, last bool) error {
+			lastAttempt = last
+			return tt.outcome
+		}
+		ctx := context.Background()
+
+		rec := repositoryMessage("1", ev)
+		rec.DeliveryAttempt = tt.attempt
+		if tt.data != nil {
+			rec.Message.Data = tt.data
+		}
+		req := pushRequest(t, tt.target, rec)
+		if tt.envelope != nil {
+			req = httptest.NewRequest(http.MethodPost, tt.target, bytes.NewReader(tt.envelope))
+		}
+
+		w := httptest.NewRecorder()
+		c.pushRoutes(ctx, PushOptions{Token: "secret", MaxConcurrent: 1}).ServeHTTP(w, req)
+		if w.Code != tt.expected {
+			t.Errorf("%s: got status %d, expected %d", tt.desc, w.Code, tt.expected)
+		}
+		if letters := evp.events[eventpub.TypeDeadLetter]; (len(letters) == 1) != tt.deadLetter {
+			t.Errorf("%s: got %d dead letters", tt.desc, len(letters))
+		}
+		if tt.attempt == 2 && !lastAttempt {
+			t.Errorf("%s: the delivery attempt should be the last one", tt.desc)
+		}
+	}
+}
+
+func TestServePushAckDeadline(t *testing.T) {
+	c, _ := New(memory.New(), &fakePublisher{}, fakeHost{}, "1.13")
+	for _, analysis := range []time.Duration{0, 20 * time.Minute} {
+		c.SetTimeouts(task.Timeouts{Analysis: analysis})
+		err := c.ServePush(context.Background(), "127.0.0.1:0", PushOptions{AckDeadline: 10 * time.Minute}, time.Second)
+		if err != ErrAckDeadline {
+			t.Errorf("Analysis timeout %s: got %v, expected ErrAckDeadline", analysis, err)
+		}
+	}
+}
+
+func TestPushHandlerConcurrency(t *testing.T) {
+	ev := eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"}
+	c, _ := New(memory.New(), &fakePublisher{}, fakeHost{}, "1.13")
+
+	started, release := make(chan struct{}), make(chan struct{})
+	c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+		close(started)
+		<-release
+		return nil
+	}
+	h := c.pushRoutes(context.Background(), PushOptions{MaxConcurrent: 1})
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, pushRequest(t, "/", repositoryMessage("1", ev)))
+		done <- w.Code
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, pushRequest(t, "/", repositoryMessage("2", ev)))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Got status %d, the delivery beyond the limit should be nacked", w.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusNoContent {
+		t.Errorf("Got status %d, the first delivery should be acknowledged", code)
+	}
+}
@@ -0,0 +1,225 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// attributeMaxAttempts is the message attribute overriding the attempts of the retry policy.
+const attributeMaxAttempts = "maxAttempts"
+
+// transientSources are the errors looked at for transient failures: those
+// of the download, of the metadata and of the database. The output of the
+// other runners, e.g. a test logging "connection refused", is the outcome
+// of the analysis and is never retried.
+var transientSources = map[string]bool{
+	"download":   true,
+	metadataName: true,
+	"database":   true,
+}
+
+// transientPatterns are the (lowercased) error messages of the failures
+// that may not happen again, such as network errors while downloading
+// the dependencies or the GitHub rate limit.
+var transientPatterns = []string{
+	"i/o timeout",
+	"connection reset",
+	"connection refused",
+	"tls handshake timeout",
+	"temporary failure in name resolution",
+	"unexpected eof",
+	"early eof",
+	"the remote end hung up unexpectedly",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"429 too many requests",
+	"rate limit",
+}
+
+// RetryPolicy defines how often an analysis failing transiently is retried.
+type RetryPolicy struct {
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled at each attempt up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy is used unless the consumer is given another policy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     30 * time.Second,
+	MaxBackoff:  10 * time.Minute,
+}
+
+// Attempts returns the maximum number of attempts, the maxAttempts
+// message attribute taking precedence over the policy.
+func (p RetryPolicy) Attempts(attributes map[string]string) int {
+	n := p.MaxAttempts
+	if v, err := strconv.Atoi(attributes[attributeMaxAttempts]); err == nil {
+		n = v
+	}
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Delay returns the backoff before the given retry, starting at 1.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// AnalysisError holds the errors of a failed analysis, keyed by runner name.
+// Transient errors are worth retrying, the others would fail again the same way,
+// e.g. a repository that cannot be fetched or does not compile.
+type AnalysisError struct {
+	Errors    map[string]string
+	Transient bool
+}
+
+// newAnalysisError classifies the errors, the analysis is worth retrying
+// if any of the transientSources failed transiently.
+func newAnalysisError(errs map[string]string) *AnalysisError {
+	e := &AnalysisError{Errors: errs}
+	for name, msg := range errs {
+		if transientSources[name] && isTransientMessage(msg) {
+			e.Transient = true
+		}
+	}
+	return e
+}
+
+// transientError marks an error that does not come from the analysis
+// itself, such as an unreachable database, as worth retrying.
+func transientError(name string, err error) *AnalysisError {
+	return &AnalysisError{Errors: map[string]string{name: err.Error()}, Transient: true}
+}
+
+func (e *AnalysisError) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %s", name, e.Errors[name])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Temporary tells whether the analysis may succeed if retried.
+func (e *AnalysisError) Temporary() bool {
+	return e.Transient
+}
+
+func isTransientMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, p := range transientPatterns {
+		if strings.Contains(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransient tells whether the error is worth retrying.
+func isTransient(err error) bool {
+	t, ok := errors.Cause(err).(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
+
+// processWithRetry runs the analysis until it succeeds, fails permanently or
+// runs out of attempts, waiting longer between each. The messages given up on
+// are sent to the dead-letter topic and not redelivered, no error is returned.
+// The transient error of an analysis interrupted by the shutdown is returned,
+// the subscriber nacks the message so that it is redelivered.
+func (c *Consumer) processWithRetry(ctx context.Context, r PubSubMessage, ev eventpub.RepositoryAddedEvent) error {
+	maxAttempts := c.retry.Attempts(r.Message.Attributes)
+	if r.push {
+		return c.processDelivery(ctx, r, ev, maxAttempts)
+	}
+	for attempt := 1; ; attempt++ {
+		err := c.handle(ctx, ev, attempt == maxAttempts)
+		if err == nil || !isTransient(err) {
+			// Permanent failures are the outcome of the analysis, saved along with the results
+			return err
+		}
+		if attempt == maxAttempts {
+			if ctx.Err() != nil {
+				// Interrupted by the shutdown rather than given up on, it is redelivered
+				return err
+			}
+			c.deadLetter(r, attempt, err)
+			return nil
+		}
+
+		delay := c.retry.Delay(attempt)
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"id":      r.Message.ID,
+			"attempt": attempt,
+		}).Warnf("Transient failure, retrying in %s", delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// processDelivery runs a single attempt of the analysis of a push delivery,
+// the push request would outlast the ack deadline while waiting to retry.
+// Pub/Sub redelivers the message with its own backoff once the transient
+// error is returned. The attempt is the delivery attempt reported by Pub/Sub,
+// without which the message is redelivered until it expires.
+func (c *Consumer) processDelivery(ctx context.Context, r PubSubMessage, ev eventpub.RepositoryAddedEvent, maxAttempts int) error {
+	attempt := r.DeliveryAttempt
+	if attempt < 1 {
+		attempt = 1
+	}
+	err := c.handle(ctx, ev, attempt >= maxAttempts)
+	if err == nil || !isTransient(err) || attempt < maxAttempts || ctx.Err() != nil {
+		return err
+	}
+	c.deadLetter(r, attempt, err)
+	return nil
+}
+
+// deadLetter publishes the message along with the full context of the failure.
+func (c *Consumer) deadLetter(r PubSubMessage, attempts int, err error) {
+	ev := &eventpub.DeadLetterEvent{
+		MessageID:  r.Message.ID,
+		Attributes: r.Message.Attributes,
+		Data:       r.Message.Data,
+		Attempts:   attempts,
+		Transient:  isTransient(err),
+		Error:      err.Error(),
+		FailedAt:   time.Now(),
+	}
+	if ae, ok := errors.Cause(err).(*AnalysisError); ok {
+		ev.Errors = ae.Errors
+	}
+	if err := c.evp.DeadLetter(ev); err != nil {
+		logrus.WithError(err).WithField("id", r.Message.ID).Error("Could not publish the message to the dead-letter topic")
+	}
+}
@@ -0,0 +1,177 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/pkg/errors"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	var attempts = []struct {
+		attributes map[string]string
+		expected   int
+	}{
+		{nil, 3},
+		{map[string]string{attributeMaxAttempts: "5"}, 5},
+		{map[string]string{attributeMaxAttempts: "0"}, 1},
+		{map[string]string{attributeMaxAttempts: "many"}, 3},
+	}
+	for _, tt := range attempts {
+		if got := p.Attempts(tt.attributes); got != tt.expected {
+			t.Errorf("%v: got %d attempts, expected %d", tt.attributes, got, tt.expected)
+		}
+	}
+
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := p.Delay(retry + 1); got != expected {
+			t.Errorf("Retry #%d: got %s, expected %s", retry+1, got, expected)
+		}
+	}
+}
+
+func TestAnalysisError(t *testing.T) {
+	var tests = []struct {
+		errors    map[string]string
+		transient bool
+	}{
+		{map[string]string{"download": "fatal: unable to access 'https://github.com/foo/bar/': Could not resolve host: Temporary failure in name resolution"}, true},
+		{map[string]string{"download": "go: github.com/pkg/errors@v0.9.1: Get https://proxy.golang.org/github.com/pkg/errors/@v/v0.9.1.zip: dial tcp: i/o timeout"}, true},
+		{map[string]string{"test": "FAIL", "download": "error: RPC failed; curl 56 GnuTLS recv error (-9): A TLS packet with unexpected length was received.\nfatal: early EOF"}, true},
+		{map[string]string{"metadata": "GET https://api.github.com/repos/foo/bar: 403 API rate limit exceeded"}, true},
+		{map[string]string{"download": "package github.com/foo/bar: unrecognized import path \"github.com/foo/bar\""}, false},
+		{map[string]string{"test": "./bar.go:3:2: undefined: baz"}, false},
+		{map[string]string{"test": "--- FAIL: TestDial (0.00s)\n    dial_test.go:12: dial tcp 127.0.0.1:80: connect: connection refused"}, false},
+		{map[string]string{"lint": "unexpected EOF", "coverage": "rate limit"}, false},
+	}
+
+	for _, tt := range tests {
+		err := newAnalysisError(tt.errors)
+		if err.Transient != tt.transient || isTransient(errors.Wrap(err, "analysis")) != tt.transient {
+			t.Errorf("%v: got transient %v, expected %v", tt.errors, err.Transient, tt.transient)
+		}
+	}
+
+	if err := newAnalysisError(map[string]string{"test": "FAIL", "lint": "timeout"}); err.Error() != "lint: timeout; test: FAIL" {
+		t.Errorf("Wrong message %s", err)
+	}
+}
+
+func TestProcessWithRetry(t *testing.T) {
+	transient := newAnalysisError(map[string]string{"download": "dial tcp: i/o timeout"})
+	permanent := newAnalysisError(map[string]string{"download": "unrecognized import path"})
+
+	var tests = []struct {
+		desc        string
+		attributes  map[string]string
+		outcomes    []error
+		attempts    int
+		lastAttempt bool
+		deadLetter  bool
+		interrupted bool
+	}{
+		{"Success", nil, []error{nil}, 1, false, false, false},
+		{"Permanent failure", nil, []error{permanent}, 1, false, false, false},
+		{"Recovered", nil, []error{transient, nil}, 2, false, false, false},
+		{"Out of attempts", nil, []error{transient, transient, transient}, 3, true, true, false},
+		{"Attempts from the attributes", map[string]string{attributeMaxAttempts: "1"}, []error{transient}, 1, true, true, false},
+		{"Interrupted by the shutdown", map[string]string{attributeMaxAttempts: "1"}, []error{transient}, 1, true, false, true},
+	}
+
+	for _, tt := range tests {
+		evp := &fakePublisher{}
+		c, _ := New(memory.New(), evp, fakeHost{}, "1.13")
+		c.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+
+		var attempts int
+		var lastAttempt bool
+		c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, last bool) error {
+			err := tt.outcomes[attempts]
+			attempts++
+			lastAttempt = last
+			return err
+		}
+
+		data, _ := json.Marshal(eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"})
+		var rec PubSubMessage
+		rec.Message.ID = "42"
+		rec.Message.Attributes = map[string]string{"type": eventpub.TypeRepositoryAdded}
+		for k, v := range tt.attributes {
+			rec.Message.Attributes[k] = v
+		}
+		rec.Message.Data = data
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.interrupted {
+			cancel()
+		}
+		c.ProcessRecord(ctx, rec)
+		cancel()
+
+		if attempts != tt.attempts || lastAttempt != tt.lastAttempt {
+			t.Errorf("%s: got %d attempts (last %v), expected %d (last %v)", tt.desc, attempts, lastAttempt, tt.attempts, tt.lastAttempt)
+		}
+		letters := evp.events[eventpub.TypeDeadLetter]
+		if (len(letters) == 1) != tt.deadLetter {
+			t.Errorf("%s: got %d dead letters", tt.desc, len(letters))
+			continue
+		}
+		if tt.deadLetter {
+			letter := letters[0].(*eventpub.DeadLetterEvent)
+			if letter.MessageID != "42" || string(letter.Data) != string(data) || letter.Attempts != tt.attempts ||
+				!letter.Transient || letter.Errors["download"] == "" {
+				t.Errorf("%s: wrong dead letter %#v", tt.desc, letter)
+			}
+		}
+	}
+}
+
+func TestProcessRecordMalformed(t *testing.T) {
+	evp := &fakePublisher{}
+	c, _ := New(memory.New(), evp, fakeHost{}, "1.13")
+
+	var rec PubSubMessage
+	rec.Message.Attributes = map[string]string{"type": eventpub.TypeRepositoryAdded}
+	rec.Message.Data = []byte("{")
+	if err := c.ProcessRecord(context.Background(), rec); err == nil {
+		t.Error("A malformed message should fail")
+	}
+	if letters := evp.events[eventpub.TypeDeadLetter]; len(letters) != 1 || letters[0].(*eventpub.DeadLetterEvent).Transient {
+		t.Errorf("A malformed message should be dead-lettered as permanent, got %v", letters)
+	}
+}
+
+func TestTransientFailureRedelivered(t *testing.T) {
+	queue := eventpub.NewLocal()
+	c, _ := New(memory.New(), &fakePublisher{}, fakeHost{}, "1.13")
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, Backoff: time.Hour})
+
+	redelivered, stop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stop()
+	var analyses int32
+	c.handle = func(ctx context.Context, ev eventpub.RepositoryAddedEvent, lastAttempt bool) error {
+		if atomic.AddInt32(&analyses, 1) == 1 {
+			return newAnalysisError(map[string]string{"download": "dial tcp: i/o timeout"})
+		}
+		stop()
+		return nil
+	}
+	queue.RepositoryAdded(&eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13"})
+
+	// The consumer is shut down while it waits to retry
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	c.Run(ctx, queue, 10*time.Millisecond)
+
+	// The message is nacked and redelivered to the next consumer
+	c.Run(redelivered, queue, time.Second)
+	if n := atomic.LoadInt32(&analyses); n != 2 {
+		t.Errorf("Got %d analyses, the interrupted message should be redelivered", n)
+	}
+}
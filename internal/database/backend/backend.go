@@ -0,0 +1,33 @@
+// Package backend opens the database selected in the configuration.
+package backend
+
+import (
+	"context"
+
+	"github.com/jgautheron/exago/internal/config"
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/firestore"
+	"github.com/jgautheron/exago/internal/database/leveldb"
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/pkg/errors"
+)
+
+const (
+	Firestore = "firestore"
+	LevelDB   = "leveldb"
+	Memory    = "memory"
+)
+
+// NewFromConfig opens the configured database, the Google Cloud
+// configuration is only used by the firestore backend.
+func NewFromConfig(ctx context.Context, dbCfg *config.DatabaseConfig, gcCfg *config.GoogleCloudConfig) (database.ResultStore, error) {
+	switch dbCfg.DatabaseBackend {
+	case Firestore:
+		return firestore.NewFromConfig(ctx, gcCfg)
+	case LevelDB:
+		return leveldb.Open(dbCfg.DatabasePath)
+	case Memory:
+		return memory.New(), nil
+	}
+	return nil, errors.Errorf("Unknown database backend %s", dbCfg.DatabaseBackend)
+}
@@ -0,0 +1,263 @@
+// Package database defines the storage model shared by the API and the consumer.
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	exago "github.com/jgautheron/exago/pkg"
+)
+
+const (
+	// DefaultListLimit is the page size used when none is requested.
+	DefaultListLimit = 20
+	// MaxListLimit is the largest page size a listing may request.
+	MaxListLimit = 100
+)
+
+var (
+	ErrNotFound      = errors.New("Not found")
+	ErrInvalidCursor = errors.New("Invalid cursor")
+)
+
+// ListOrder defines how projects are sorted in a listing.
+type ListOrder int
+
+const (
+	// OrderRecent sorts by analysis date, most recent first.
+	OrderRecent ListOrder = iota
+	// OrderTop sorts by score, best first.
+	OrderTop
+	// OrderPopular sorts by GitHub stars, most starred first.
+	OrderPopular
+)
+
+// ProjectStore persists and queries analysis results.
+type ProjectStore interface {
+	SaveProject(ctx context.Context, p *Project) error
+	GetProject(ctx context.Context, repository, branch, goVersion string) (*Project, error)
+	ListProjects(ctx context.Context, opts ListOptions) (*ProjectList, error)
+}
+
+// JobStore persists the state of the analyses.
+type JobStore interface {
+	SaveJob(ctx context.Context, j *Job) error
+	GetJob(ctx context.Context, repository, branch, goVersion string) (*Job, error)
+}
+
+// HistoryStore queries every result saved for a project, SaveProject
+// keeps the previous results in the history instead of dropping them.
+// A result of the same commit as the latest entry replaces it.
+type HistoryStore interface {
+	// ListHistory returns the results of the project, the most recent first
+	ListHistory(ctx context.Context, repository, branch, goVersion string, limit int) ([]*Project, error)
+}
+
+// ClaimStore records which consumer handles a message or an analysis,
+// so that the duplicate deliveries are not processed twice.
+type ClaimStore interface {
+	// Claim records the key unless it is already claimed, false is returned
+	// in that case. The claim expires after ttl, should it never be released.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Renew sets the claim to expire after ttl from now, whether it exists or not,
+	// to extend the claim held by the caller
+	Renew(ctx context.Context, key string, ttl time.Duration) error
+	// Release removes the claim, releasing a missing claim is not an error
+	Release(ctx context.Context, key string) error
+}
+
+// ResultStore is the storage backend shared by the API and the consumer.
+type ResultStore interface {
+	ProjectStore
+	JobStore
+	HistoryStore
+	ClaimStore
+
+	// Ping makes sure that the backend can be queried
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// JobState is the stage reached by an analysis.
+type JobState string
+
+const (
+	JobQueued      JobState = "queued"
+	JobDownloading JobState = "downloading"
+	JobRunning     JobState = "running"
+	JobScored      JobState = "scored"
+	JobFailed      JobState = "failed"
+	// JobCancelled is set through the API, the consumer stops the analysis
+	JobCancelled JobState = "cancelled"
+)
+
+// Job tracks the analysis of a repository, only the latest one is kept.
+type Job struct {
+	Repository string `json:"repository"`
+	Branch     string `json:"branch"`
+	GoVersion  string `json:"goVersion"`
+	// RequestID identifies the analysis request tracked, carried by its event.
+	// The cancellation only applies to that request.
+	RequestID string   `json:"requestId,omitempty"`
+	State     JobState `json:"state"`
+	// Errors holds the error of each failed runner
+	Errors    map[string]string `json:"errors,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+// NewJob creates a queued job for a new analysis request.
+func NewJob(repository, branch, goVersion string) *Job {
+	now := time.Now()
+	return &Job{
+		Repository: repository,
+		Branch:     branch,
+		GoVersion:  goVersion,
+		RequestID:  newRequestID(),
+		State:      JobQueued,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+}
+
+// ID returns the unique identifier of the job.
+func (j Job) ID() string {
+	return ProjectID(j.Repository, j.Branch, j.GoVersion)
+}
+
+// newRequestID returns a random identifier for an analysis request.
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// The time is unique enough for the requests of a single job
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// SetState moves the job to the given state, errors are kept for failed jobs
+// and for the jobs queued again after a transient failure.
+func (j *Job) SetState(state JobState, errs map[string]string) {
+	j.State = state
+	j.Errors = nil
+	if state == JobFailed || state == JobQueued {
+		j.Errors = errs
+	}
+	j.UpdatedAt = time.Now()
+}
+
+// Pending reports whether the analysis is still in progress.
+func (j Job) Pending() bool {
+	switch j.State {
+	case JobQueued, JobDownloading, JobRunning:
+		return true
+	}
+	return false
+}
+
+// Project is the stored outcome of a repository analysis.
+type Project struct {
+	Repository string `json:"repository"`
+	Branch     string `json:"branch"`
+	GoVersion  string `json:"goVersion"`
+	// Commit is the SHA of the commit analyzed, empty if unknown
+	Commit string `json:"commit,omitempty"`
+	// CommitDate is the date the commit was committed, nil if unknown
+	CommitDate  *time.Time `json:"commitDate,omitempty"`
+	ProcessedAt time.Time  `json:"processedAt"`
+	Data        exago.Data `json:"data"`
+}
+
+// ID returns the unique identifier of the project.
+func (p Project) ID() string {
+	return ProjectID(p.Repository, p.Branch, p.GoVersion)
+}
+
+// OptionalDate returns nil for the zero time, so that the unknown dates are omitted.
+func OptionalDate(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ProjectID builds the identifier of a repository/branch/Go version triplet.
+// Slashes are escaped so that the identifier can be used as a document name.
+func ProjectID(repository, branch, goVersion string) string {
+	return url.PathEscape(strings.Join([]string{repository, branch, goVersion}, "@"))
+}
+
+// ListOptions narrows down and paginates a listing.
+type ListOptions struct {
+	Order ListOrder
+	// Repository and Branch keep only the results of the given project
+	Repository string
+	Branch     string
+	// Rank keeps only the projects with the exact given rank (e.g. A+)
+	Rank string
+	// GoVersion keeps only the projects analyzed with the given Go version
+	GoVersion string
+	// Cursor is the opaque position returned by the previous page
+	Cursor string
+	Limit  int
+}
+
+// PageSize returns the sanitized page size.
+func (o ListOptions) PageSize() int {
+	return PageSize(o.Limit)
+}
+
+// PageSize keeps the requested limit within bounds, DefaultListLimit being used if unset.
+func PageSize(limit int) int {
+	switch {
+	case limit <= 0:
+		return DefaultListLimit
+	case limit > MaxListLimit:
+		return MaxListLimit
+	}
+	return limit
+}
+
+// Matches tells whether the project satisfies the listing filters.
+func (o ListOptions) Matches(p *Project) bool {
+	if o.Repository != "" && p.Repository != o.Repository {
+		return false
+	}
+	if o.Branch != "" && p.Branch != o.Branch {
+		return false
+	}
+	if o.Rank != "" && p.Data.Score.Rank != o.Rank {
+		return false
+	}
+	if o.GoVersion != "" && p.GoVersion != o.GoVersion {
+		return false
+	}
+	return true
+}
+
+// ProjectList is a page of projects.
+type ProjectList struct {
+	Projects []*Project `json:"projects"`
+	// Cursor points to the next page, empty on the last one
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// EncodeCursor turns the identifier of the last project of a page into an opaque cursor.
+func EncodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// DecodeCursor returns the project identifier held by the cursor.
+func DecodeCursor(cursor string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(id) == 0 {
+		return "", ErrInvalidCursor
+	}
+	return string(id), nil
+}
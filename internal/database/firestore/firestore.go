@@ -2,16 +2,66 @@ package firestore
 
 import (
 	"context"
+	"net/url"
+	"strconv"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/jgautheron/exago/internal/config"
+	"github.com/jgautheron/exago/internal/database"
+	exago "github.com/jgautheron/exago/pkg"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+const (
+	projectsCollection = "projects"
+	jobsCollection     = "jobs"
+	claimsCollection   = "claims"
+	// historyCollection is nested in each project document
+	historyCollection = "history"
+)
+
+var _ database.ResultStore = (*Firestore)(nil)
+
 type Firestore struct {
 	client *firestore.Client
 }
 
+// job is the document stored for the latest analysis job of a project.
+type job struct {
+	Repository string            `firestore:"repository"`
+	Branch     string            `firestore:"branch"`
+	GoVersion  string            `firestore:"goVersion"`
+	RequestID  string            `firestore:"requestId"`
+	State      string            `firestore:"state"`
+	Errors     map[string]string `firestore:"errors"`
+	CreatedAt  time.Time         `firestore:"createdAt"`
+	UpdatedAt  time.Time         `firestore:"updatedAt"`
+}
+
+// claim is the document stored for each claim, a TTL policy
+// on expiresAt can purge the expired ones.
+type claim struct {
+	ExpiresAt time.Time `firestore:"expiresAt"`
+}
+
+// project is the document stored for each analysis result, the rank,
+// score and stars are duplicated at the top level so they can be indexed.
+type project struct {
+	Repository  string     `firestore:"repository"`
+	Branch      string     `firestore:"branch"`
+	GoVersion   string     `firestore:"goVersion"`
+	Commit      string     `firestore:"commit"`
+	CommitDate  *time.Time `firestore:"commitDate"`
+	ProcessedAt time.Time  `firestore:"processedAt"`
+	Rank        string     `firestore:"rank"`
+	Score       float64    `firestore:"score"`
+	Stars       int        `firestore:"stars"`
+	Data        exago.Data `firestore:"data"`
+}
+
 func NewFromConfig(ctx context.Context, gcCfg *config.GoogleCloudConfig) (*Firestore, error) {
 	client, err := firestore.NewClient(ctx, gcCfg.GoogleProjectID)
 	if err != nil {
@@ -19,3 +69,279 @@ func NewFromConfig(ctx context.Context, gcCfg *config.GoogleCloudConfig) (*Fires
 	}
 	return &Firestore{client}, nil
 }
+
+// SaveProject stores the project as the latest result and adds it to the history,
+// where it replaces the latest entry if it has the same commit.
+func (f *Firestore) SaveProject(ctx context.Context, p *database.Project) error {
+	doc := project{
+		Repository:  p.Repository,
+		Branch:      p.Branch,
+		GoVersion:   p.GoVersion,
+		Commit:      p.Commit,
+		CommitDate:  p.CommitDate,
+		ProcessedAt: p.ProcessedAt,
+		Rank:        p.Data.Score.Rank,
+		Score:       p.Data.Score.Value,
+		Stars:       p.Data.Metadata.Stars,
+		Data:        p.Data,
+	}
+	ref := f.projects().Doc(p.ID())
+	history := ref.Collection(historyCollection)
+	entry := history.Doc(strconv.FormatInt(p.ProcessedAt.UnixNano(), 10))
+	err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snaps, err := tx.Documents(history.OrderBy("processedAt", firestore.Desc).Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(snaps) > 0 && p.Commit != "" && snaps[0].Ref.ID != entry.ID {
+			var latest project
+			if err := snaps[0].DataTo(&latest); err != nil {
+				return err
+			}
+			if latest.Commit == p.Commit {
+				if err := tx.Delete(snaps[0].Ref); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Set(ref, doc); err != nil {
+			return err
+		}
+		return tx.Set(entry, doc)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Could not save project %s", p.ID())
+	}
+	return nil
+}
+
+// ListHistory returns the results of the project, the most recent first.
+func (f *Firestore) ListHistory(ctx context.Context, repository, branch, goVersion string, limit int) ([]*database.Project, error) {
+	id := database.ProjectID(repository, branch, goVersion)
+	snaps, err := f.projects().Doc(id).Collection(historyCollection).
+		OrderBy("processedAt", firestore.Desc).
+		Limit(database.PageSize(limit)).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not list the history of %s", id)
+	}
+
+	history := []*database.Project{}
+	for _, snap := range snaps {
+		p, err := toProject(snap)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, p)
+	}
+	return history, nil
+}
+
+// GetProject loads a project, database.ErrNotFound is returned if it was never saved.
+func (f *Firestore) GetProject(ctx context.Context, repository, branch, goVersion string) (*database.Project, error) {
+	id := database.ProjectID(repository, branch, goVersion)
+	snap, err := f.projects().Doc(id).Get(ctx)
+	if snap != nil && !snap.Exists() {
+		return nil, database.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not load project %s", id)
+	}
+	return toProject(snap)
+}
+
+// ListProjects runs the listing query. Each combination of filter and order
+// requires a composite index on the projects collection.
+func (f *Firestore) ListProjects(ctx context.Context, opts database.ListOptions) (*database.ProjectList, error) {
+	field := "processedAt"
+	switch opts.Order {
+	case database.OrderTop:
+		field = "score"
+	case database.OrderPopular:
+		field = "stars"
+	}
+
+	q := f.projects().OrderBy(field, firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc)
+	if opts.Repository != "" {
+		q = q.Where("repository", "==", opts.Repository)
+	}
+	if opts.Branch != "" {
+		q = q.Where("branch", "==", opts.Branch)
+	}
+	if opts.Rank != "" {
+		q = q.Where("rank", "==", opts.Rank)
+	}
+	if opts.GoVersion != "" {
+		q = q.Where("goVersion", "==", opts.GoVersion)
+	}
+
+	if opts.Cursor != "" {
+		id, err := database.DecodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		last, err := f.projects().Doc(id).Get(ctx)
+		if last != nil && !last.Exists() {
+			return nil, database.ErrInvalidCursor
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not load cursor document")
+		}
+		q = q.StartAfter(last)
+	}
+
+	// Fetch one more document to know if there is a next page
+	limit := opts.PageSize()
+	snaps, err := q.Limit(limit + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not list projects")
+	}
+
+	list := &database.ProjectList{Projects: []*database.Project{}}
+	if len(snaps) > limit {
+		snaps = snaps[:limit]
+		list.Cursor = database.EncodeCursor(snaps[limit-1].Ref.ID)
+	}
+	for _, snap := range snaps {
+		p, err := toProject(snap)
+		if err != nil {
+			return nil, err
+		}
+		list.Projects = append(list.Projects, p)
+	}
+
+	return list, nil
+}
+
+// SaveJob stores the job, replacing the previous one.
+func (f *Firestore) SaveJob(ctx context.Context, j *database.Job) error {
+	doc := job{
+		Repository: j.Repository,
+		Branch:     j.Branch,
+		GoVersion:  j.GoVersion,
+		RequestID:  j.RequestID,
+		State:      string(j.State),
+		Errors:     j.Errors,
+		CreatedAt:  j.CreatedAt,
+		UpdatedAt:  j.UpdatedAt,
+	}
+	if _, err := f.jobs().Doc(j.ID()).Set(ctx, doc); err != nil {
+		return errors.Wrapf(err, "Could not save job %s", j.ID())
+	}
+	return nil
+}
+
+// GetJob loads the latest job, database.ErrNotFound is returned if there is none.
+func (f *Firestore) GetJob(ctx context.Context, repository, branch, goVersion string) (*database.Job, error) {
+	id := database.ProjectID(repository, branch, goVersion)
+	snap, err := f.jobs().Doc(id).Get(ctx)
+	if snap != nil && !snap.Exists() {
+		return nil, database.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not load job %s", id)
+	}
+
+	var doc job
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, errors.Wrapf(err, "Could not decode job %s", id)
+	}
+	return &database.Job{
+		Repository: doc.Repository,
+		Branch:     doc.Branch,
+		GoVersion:  doc.GoVersion,
+		RequestID:  doc.RequestID,
+		State:      database.JobState(doc.State),
+		Errors:     doc.Errors,
+		CreatedAt:  doc.CreatedAt,
+		UpdatedAt:  doc.UpdatedAt,
+	}, nil
+}
+
+// Claim records the key unless it is claimed and not expired yet,
+// in a transaction so that concurrent consumers cannot both claim it.
+func (f *Firestore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ref := f.claims().Doc(url.PathEscape(key))
+	claimed := false
+	err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+		now := time.Now()
+		// A missing claim is a NotFound error, the other errors must not be taken for one
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil && snap.Exists() {
+			var doc claim
+			if err := snap.DataTo(&doc); err != nil {
+				return err
+			}
+			if now.Before(doc.ExpiresAt) {
+				return nil
+			}
+		}
+		claimed = true
+		return tx.Set(ref, claim{ExpiresAt: now.Add(ttl)})
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "Could not claim %s", key)
+	}
+	return claimed, nil
+}
+
+// Renew sets the claim to expire after ttl.
+func (f *Firestore) Renew(ctx context.Context, key string, ttl time.Duration) error {
+	if _, err := f.claims().Doc(url.PathEscape(key)).Set(ctx, claim{ExpiresAt: time.Now().Add(ttl)}); err != nil {
+		return errors.Wrapf(err, "Could not renew claim %s", key)
+	}
+	return nil
+}
+
+// Release removes the claim.
+func (f *Firestore) Release(ctx context.Context, key string) error {
+	if _, err := f.claims().Doc(url.PathEscape(key)).Delete(ctx); err != nil {
+		return errors.Wrapf(err, "Could not release claim %s", key)
+	}
+	return nil
+}
+
+// Ping makes sure that the database can be queried.
+func (f *Firestore) Ping(ctx context.Context) error {
+	if _, err := f.jobs().Limit(1).Documents(ctx).GetAll(); err != nil {
+		return errors.Wrap(err, "Could not query firestore")
+	}
+	return nil
+}
+
+// Close releases the firestore client.
+func (f *Firestore) Close() error {
+	return f.client.Close()
+}
+
+func (f *Firestore) jobs() *firestore.CollectionRef {
+	return f.client.Collection(jobsCollection)
+}
+
+func (f *Firestore) claims() *firestore.CollectionRef {
+	return f.client.Collection(claimsCollection)
+}
+
+func (f *Firestore) projects() *firestore.CollectionRef {
+	return f.client.Collection(projectsCollection)
+}
+
+func toProject(snap *firestore.DocumentSnapshot) (*database.Project, error) {
+	var doc project
+	if err := snap.DataTo(&doc); err != nil {
+		return nil, errors.Wrapf(err, "Could not decode project %s", snap.Ref.ID)
+	}
+	return &database.Project{
+		Repository:  doc.Repository,
+		Branch:      doc.Branch,
+		GoVersion:   doc.GoVersion,
+		Commit:      doc.Commit,
+		CommitDate:  doc.CommitDate,
+		ProcessedAt: doc.ProcessedAt,
+		Data:        doc.Data,
+	}, nil
+}
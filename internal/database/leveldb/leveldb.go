@@ -0,0 +1,243 @@
+// Package leveldb is an embedded on-disk database, for self-hosted
+// instances that don't run on Google Cloud.
+package leveldb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Key prefixes, identifiers are path escaped so they never contain a slash.
+const (
+	projectPrefix = "project/"
+	historyPrefix = "history/"
+	jobPrefix     = "job/"
+	claimPrefix   = "claim/"
+)
+
+var _ database.ResultStore = (*LevelDB)(nil)
+
+type LevelDB struct {
+	db *leveldb.DB
+	// claimMu makes the claims atomic, leveldb has no transactions
+	// across reads and writes
+	claimMu sync.Mutex
+	// historyMu makes the replacement of the latest history entry atomic
+	historyMu sync.Mutex
+}
+
+// claim is the value stored for each claim.
+type claim struct {
+	Expiry time.Time `json:"expiry"`
+}
+
+// Open opens the database stored in the given directory, it is created if missing.
+func Open(path string) (*LevelDB, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not open database %s", path)
+	}
+	return &LevelDB{db: db}, nil
+}
+
+// SaveProject stores the project as the latest result and adds it to the history,
+// where it replaces the latest entry if it has the same commit.
+func (l *LevelDB) SaveProject(ctx context.Context, p *database.Project) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrapf(err, "Could not encode project %s", p.ID())
+	}
+
+	l.historyMu.Lock()
+	defer l.historyMu.Unlock()
+
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(projectPrefix+p.ID()), b)
+	if key, latest, err := l.latestHistory(p.ID()); err != nil {
+		return err
+	} else if latest != nil && latest.Commit != "" && latest.Commit == p.Commit {
+		batch.Delete(key)
+	}
+	batch.Put(historyKey(p.ID(), p.ProcessedAt.UnixNano()), b)
+	if err := l.db.Write(batch, nil); err != nil {
+		return errors.Wrapf(err, "Could not save project %s", p.ID())
+	}
+	return nil
+}
+
+// GetProject loads a project, database.ErrNotFound is returned if it was never saved.
+func (l *LevelDB) GetProject(ctx context.Context, repository, branch, goVersion string) (*database.Project, error) {
+	id := database.ProjectID(repository, branch, goVersion)
+	var p database.Project
+	if err := l.get(projectPrefix+id, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListProjects loads every project to sort and paginate them,
+// which is fine for the size of a self-hosted instance.
+func (l *LevelDB) ListProjects(ctx context.Context, opts database.ListOptions) (*database.ProjectList, error) {
+	var projects []*database.Project
+	it := l.db.NewIterator(util.BytesPrefix([]byte(projectPrefix)), nil)
+	defer it.Release()
+	for it.Next() {
+		var p database.Project
+		if err := json.Unmarshal(it.Value(), &p); err != nil {
+			return nil, errors.Wrapf(err, "Could not decode %s", it.Key())
+		}
+		projects = append(projects, &p)
+	}
+	if err := it.Error(); err != nil {
+		return nil, errors.Wrap(err, "Could not list projects")
+	}
+
+	return database.Paginate(projects, opts)
+}
+
+// latestHistory returns the key and the content of the latest history entry
+// of the project, nil if it has none.
+func (l *LevelDB) latestHistory(id string) ([]byte, *database.Project, error) {
+	it := l.db.NewIterator(util.BytesPrefix([]byte(historyPrefix+id+"/")), nil)
+	defer it.Release()
+	if !it.Last() {
+		return nil, nil, errors.Wrapf(it.Error(), "Could not load the history of %s", id)
+	}
+
+	var p database.Project
+	if err := json.Unmarshal(it.Value(), &p); err != nil {
+		return nil, nil, errors.Wrapf(err, "Could not decode %s", it.Key())
+	}
+	// The iterator reuses the buffer of the key
+	return append([]byte(nil), it.Key()...), &p, nil
+}
+
+// ListHistory returns the results of the project, the most recent first.
+func (l *LevelDB) ListHistory(ctx context.Context, repository, branch, goVersion string, limit int) ([]*database.Project, error) {
+	id := database.ProjectID(repository, branch, goVersion)
+	it := l.db.NewIterator(util.BytesPrefix([]byte(historyPrefix+id+"/")), nil)
+	defer it.Release()
+
+	history := []*database.Project{}
+	limit = database.PageSize(limit)
+	for ok := it.Last(); ok && len(history) < limit; ok = it.Prev() {
+		var p database.Project
+		if err := json.Unmarshal(it.Value(), &p); err != nil {
+			return nil, errors.Wrapf(err, "Could not decode %s", it.Key())
+		}
+		history = append(history, &p)
+	}
+	if err := it.Error(); err != nil {
+		return nil, errors.Wrapf(err, "Could not list the history of %s", id)
+	}
+	return history, nil
+}
+
+// SaveJob stores the job, replacing the previous one.
+func (l *LevelDB) SaveJob(ctx context.Context, j *database.Job) error {
+	b, err := json.Marshal(j)
+	if err != nil {
+		return errors.Wrapf(err, "Could not encode job %s", j.ID())
+	}
+	if err := l.db.Put([]byte(jobPrefix+j.ID()), b, nil); err != nil {
+		return errors.Wrapf(err, "Could not save job %s", j.ID())
+	}
+	return nil
+}
+
+// GetJob loads the latest job, database.ErrNotFound is returned if there is none.
+func (l *LevelDB) GetJob(ctx context.Context, repository, branch, goVersion string) (*database.Job, error) {
+	var j database.Job
+	if err := l.get(jobPrefix+database.ProjectID(repository, branch, goVersion), &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Claim records the key unless it is claimed and not expired yet. The database
+// is opened by a single process, a mutex is enough to make it atomic.
+func (l *LevelDB) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	l.claimMu.Lock()
+	defer l.claimMu.Unlock()
+
+	now := time.Now()
+	var c claim
+	switch err := l.get(claimPrefix+key, &c); {
+	case err == nil && now.Before(c.Expiry):
+		return false, nil
+	case err != nil && err != database.ErrNotFound:
+		return false, err
+	}
+
+	if err := l.putClaim(key, now.Add(ttl)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Renew sets the claim to expire after ttl.
+func (l *LevelDB) Renew(ctx context.Context, key string, ttl time.Duration) error {
+	l.claimMu.Lock()
+	defer l.claimMu.Unlock()
+	return l.putClaim(key, time.Now().Add(ttl))
+}
+
+func (l *LevelDB) putClaim(key string, expiry time.Time) error {
+	b, err := json.Marshal(claim{Expiry: expiry})
+	if err != nil {
+		return errors.Wrapf(err, "Could not encode claim %s", key)
+	}
+	if err := l.db.Put([]byte(claimPrefix+key), b, nil); err != nil {
+		return errors.Wrapf(err, "Could not save claim %s", key)
+	}
+	return nil
+}
+
+// Release removes the claim.
+func (l *LevelDB) Release(ctx context.Context, key string) error {
+	if err := l.db.Delete([]byte(claimPrefix+key), nil); err != nil {
+		return errors.Wrapf(err, "Could not release claim %s", key)
+	}
+	return nil
+}
+
+// Ping makes sure that the database is still open.
+func (l *LevelDB) Ping(ctx context.Context) error {
+	if _, err := l.db.GetProperty("leveldb.num-files-at-level0"); err != nil {
+		return errors.Wrap(err, "Could not query leveldb")
+	}
+	return nil
+}
+
+// Close flushes and closes the database.
+func (l *LevelDB) Close() error {
+	return l.db.Close()
+}
+
+// get decodes the value of the key into v.
+func (l *LevelDB) get(key string, v interface{}) error {
+	b, err := l.db.Get([]byte(key), nil)
+	switch {
+	case err == leveldb.ErrNotFound:
+		return database.ErrNotFound
+	case err != nil:
+		return errors.Wrapf(err, "Could not load %s", key)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrapf(err, "Could not decode %s", key)
+	}
+	return nil
+}
+
+// historyKey sorts the history entries by date, the timestamp being zero padded.
+func historyKey(id string, processedAt int64) []byte {
+	return []byte(fmt.Sprintf("%s%s/%020d", historyPrefix, id, processedAt))
+}
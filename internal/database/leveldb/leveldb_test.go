@@ -0,0 +1,177 @@
+package leveldb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/leveldb"
+)
+
+func TestProjects(t *testing.T) {
+	db, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	now := time.Now()
+	for _, p := range []struct {
+		repository string
+		score      float64
+		age        time.Duration
+	}{
+		{"a", 60, 3 * time.Hour},
+		{"b", 90, 2 * time.Hour},
+		{"a", 80, time.Hour},
+	} {
+		pr := &database.Project{Repository: p.repository, Branch: "master", GoVersion: "1.13", ProcessedAt: now.Add(-p.age)}
+		pr.Data.Score.Value = p.score
+		if err := db.SaveProject(context.Background(), pr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := db.GetProject(context.Background(), "a", "master", "1.13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Data.Score.Value != 80 {
+		t.Errorf("Got score %.0f, expected the latest one", p.Data.Score.Value)
+	}
+	if _, err := db.GetProject(context.Background(), "a", "develop", "1.13"); err != database.ErrNotFound {
+		t.Error("Unknown projects should not be found")
+	}
+
+	list, err := db.ListProjects(context.Background(), database.ListOptions{Order: database.OrderTop, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Projects) != 1 || list.Projects[0].Repository != "b" || list.Cursor == "" {
+		t.Errorf("Wrong first page %#v", list)
+	}
+
+	history, err := db.ListHistory(context.Background(), "a", "master", "1.13", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Data.Score.Value != 80 || history[1].Data.Score.Value != 60 {
+		t.Errorf("Wrong history %v", history)
+	}
+}
+
+func TestHistorySameCommit(t *testing.T) {
+	db, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	now := time.Now()
+	for i, c := range []struct {
+		commit string
+		score  float64
+	}{
+		{"a1b2c3d", 60}, {"e4f5a6b", 70}, {"e4f5a6b", 80},
+	} {
+		p := &database.Project{Repository: "a", Branch: "master", GoVersion: "1.13", Commit: c.commit, ProcessedAt: now.Add(time.Duration(i) * time.Hour)}
+		p.Data.Score.Value = c.score
+		if err := db.SaveProject(context.Background(), p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	history, err := db.ListHistory(context.Background(), "a", "master", "1.13", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].Data.Score.Value != 80 || history[1].Data.Score.Value != 60 {
+		t.Errorf("The latest entry should be replaced, got %v", history)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	db, cleanup := openTestDatabase(t)
+	defer cleanup()
+
+	job := database.NewJob("a", "master", "1.13")
+	job.SetState(database.JobFailed, map[string]string{"test": "exit status 2"})
+	if err := db.SaveJob(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetJob(context.Background(), "a", "master", "1.13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != database.JobFailed || got.Errors["test"] != "exit status 2" {
+		t.Errorf("Wrong job %#v", got)
+	}
+	if _, err := db.GetJob(context.Background(), "b", "master", "1.13"); err != database.ErrNotFound {
+		t.Error("Unknown jobs should not be found")
+	}
+}
+
+func TestClaim(t *testing.T) {
+	db, cleanup := openTestDatabase(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	var tests = []struct {
+		desc     string
+		key      string
+		ttl      time.Duration
+		release  bool
+		expected bool
+	}{
+		{"First claim", "message:1", time.Hour, false, true},
+		{"Already claimed", "message:1", time.Hour, false, false},
+		{"Other key", "message:2", -time.Second, false, true},
+		{"Expired", "message:2", time.Hour, false, true},
+		{"Released", "message:1", time.Hour, true, true},
+	}
+
+	for _, tt := range tests {
+		if tt.release {
+			if err := db.Release(ctx, tt.key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		claimed, err := db.Claim(ctx, tt.key, tt.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.desc, claimed, tt.expected)
+		}
+	}
+	if err := db.Release(ctx, "message:unknown"); err != nil {
+		t.Errorf("Releasing a missing claim should not fail, got %v", err)
+	}
+
+	// The renewed claims last ttl from now
+	if err := db.Renew(ctx, "message:3", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := db.Claim(ctx, "message:3", time.Hour); claimed {
+		t.Error("A renewed claim should not be claimed again")
+	}
+	if err := db.Renew(ctx, "message:3", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := db.Claim(ctx, "message:3", time.Hour); !claimed {
+		t.Error("A claim renewed in the past should be expired")
+	}
+}
+
+func openTestDatabase(t *testing.T) (*leveldb.LevelDB, func()) {
+	dir, err := ioutil.TempDir("", "exago-leveldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := leveldb.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,66 @@
+package database
+
+import "sort"
+
+// Paginate filters, sorts and paginates the projects the way the Firestore
+// queries do, for the backends that cannot query their projects.
+func Paginate(projects []*Project, opts ListOptions) (*ProjectList, error) {
+	var matches []*Project
+	for _, p := range projects {
+		if opts.Matches(p) {
+			matches = append(matches, p)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return less(opts.Order, matches[j], matches[i])
+	})
+
+	// Start right after the project the cursor points to
+	if opts.Cursor != "" {
+		id, err := DecodeCursor(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start := -1
+		for i, p := range matches {
+			if p.ID() == id {
+				start = i + 1
+				break
+			}
+		}
+		if start == -1 {
+			return nil, ErrInvalidCursor
+		}
+		matches = matches[start:]
+	}
+
+	list := &ProjectList{Projects: []*Project{}}
+	limit := opts.PageSize()
+	if len(matches) > limit {
+		matches = matches[:limit]
+		list.Cursor = EncodeCursor(matches[limit-1].ID())
+	}
+	list.Projects = append(list.Projects, matches...)
+
+	return list, nil
+}
+
+// less reports whether a sorts before b in ascending order,
+// the identifier breaks ties the same way the document name does in Firestore.
+func less(order ListOrder, a, b *Project) bool {
+	switch order {
+	case OrderTop:
+		if a.Data.Score.Value != b.Data.Score.Value {
+			return a.Data.Score.Value < b.Data.Score.Value
+		}
+	case OrderPopular:
+		if a.Data.Metadata.Stars != b.Data.Metadata.Stars {
+			return a.Data.Metadata.Stars < b.Data.Metadata.Stars
+		}
+	default:
+		if !a.ProcessedAt.Equal(b.ProcessedAt) {
+			return a.ProcessedAt.Before(b.ProcessedAt)
+		}
+	}
+	return a.ID() < b.ID()
+}
@@ -0,0 +1,162 @@
+// Package memory is an in-memory stand-in for the persistent databases,
+// meant for tests and local runs.
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+)
+
+var _ database.ResultStore = (*Memory)(nil)
+
+type Memory struct {
+	mu       sync.RWMutex
+	projects map[string]*database.Project
+	history  map[string][]*database.Project
+	jobs     map[string]*database.Job
+	// claims holds the expiry of each claim
+	claims map[string]time.Time
+}
+
+// New creates an empty in-memory database.
+func New() *Memory {
+	return &Memory{
+		projects: make(map[string]*database.Project),
+		history:  make(map[string][]*database.Project),
+		jobs:     make(map[string]*database.Job),
+		claims:   make(map[string]time.Time),
+	}
+}
+
+// SaveProject stores a copy of the project as the latest result and in the history,
+// where it replaces the latest entry if it has the same commit.
+func (m *Memory) SaveProject(ctx context.Context, p *database.Project) error {
+	cp := *p
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.projects[p.ID()] = &cp
+	history := m.history[p.ID()]
+	if n := len(history); n > 0 && sameCommit(history[n-1], p) {
+		history = history[:n-1]
+	}
+	m.history[p.ID()] = append(history, &cp)
+	return nil
+}
+
+// sameCommit tells whether both results are of the same known commit.
+func sameCommit(a, b *database.Project) bool {
+	return a.Commit != "" && a.Commit == b.Commit
+}
+
+// GetProject loads a project, database.ErrNotFound is returned if it was never saved.
+func (m *Memory) GetProject(ctx context.Context, repository, branch, goVersion string) (*database.Project, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.projects[database.ProjectID(repository, branch, goVersion)]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	cp := *p
+	return &cp, nil
+}
+
+// ListProjects mimics the ordering and pagination of the persistent databases.
+func (m *Memory) ListProjects(ctx context.Context, opts database.ListOptions) (*database.ProjectList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	projects := make([]*database.Project, 0, len(m.projects))
+	for _, p := range m.projects {
+		projects = append(projects, p)
+	}
+	list, err := database.Paginate(projects, opts)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range list.Projects {
+		cp := *p
+		list.Projects[i] = &cp
+	}
+	return list, nil
+}
+
+// ListHistory returns copies of the results of the project, the most recent first.
+func (m *Memory) ListHistory(ctx context.Context, repository, branch, goVersion string, limit int) ([]*database.Project, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	history := m.history[database.ProjectID(repository, branch, goVersion)]
+	out := []*database.Project{}
+	for i := len(history) - 1; i >= 0 && len(out) < database.PageSize(limit); i-- {
+		cp := *history[i]
+		out = append(out, &cp)
+	}
+	return out, nil
+}
+
+// SaveJob stores a copy of the job, replacing the previous one.
+func (m *Memory) SaveJob(ctx context.Context, j *database.Job) error {
+	cp := *j
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.ID()] = &cp
+	return nil
+}
+
+// GetJob loads the latest job, database.ErrNotFound is returned if there is none.
+func (m *Memory) GetJob(ctx context.Context, repository, branch, goVersion string) (*database.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	j, ok := m.jobs[database.ProjectID(repository, branch, goVersion)]
+	if !ok {
+		return nil, database.ErrNotFound
+	}
+	cp := *j
+	return &cp, nil
+}
+
+// Claim records the key unless it is claimed and not expired yet.
+func (m *Memory) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if expiry, ok := m.claims[key]; ok && now.Before(expiry) {
+		return false, nil
+	}
+	m.claims[key] = now.Add(ttl)
+	return true, nil
+}
+
+// Renew sets the claim to expire after ttl.
+func (m *Memory) Renew(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims[key] = time.Now().Add(ttl)
+	return nil
+}
+
+// Release removes the claim.
+func (m *Memory) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claims, key)
+	return nil
+}
+
+// Ping always succeeds, the database is always available.
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing, the data is simply dropped with the database.
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,244 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/memory"
+)
+
+func TestListProjects(t *testing.T) {
+	db := getStubDatabase()
+
+	var tests = []struct {
+		opts     database.ListOptions
+		expected []string
+		desc     string
+	}{
+		{database.ListOptions{Order: database.OrderRecent}, []string{"c", "b", "a", "d"}, "Most recently processed first"},
+		{database.ListOptions{Order: database.OrderTop}, []string{"a", "d", "c", "b"}, "Best score first"},
+		{database.ListOptions{Order: database.OrderPopular}, []string{"b", "a", "d", "c"}, "Most starred first"},
+		{database.ListOptions{Order: database.OrderTop, Rank: "B"}, []string{"d", "c"}, "Filtered by rank"},
+		{database.ListOptions{Order: database.OrderTop, GoVersion: "1.12"}, []string{"b"}, "Filtered by Go version"},
+		{database.ListOptions{Order: database.OrderTop, Limit: 2}, []string{"a", "d"}, "First page only"},
+	}
+
+	for _, tt := range tests {
+		list, err := db.ListProjects(context.Background(), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := repositories(list); !equal(got, tt.expected) {
+			t.Errorf("%s: got %v, expected %v", tt.desc, got, tt.expected)
+		}
+	}
+}
+
+func TestListProjectsPagination(t *testing.T) {
+	db := getStubDatabase()
+	opts := database.ListOptions{Order: database.OrderPopular, Limit: 3}
+
+	first, err := db.ListProjects(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Cursor == "" {
+		t.Fatal("The first page should point to the next one")
+	}
+
+	opts.Cursor = first.Cursor
+	second, err := db.ListProjects(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := repositories(second); !equal(got, []string{"c"}) {
+		t.Errorf("Wrong second page %v", got)
+	}
+	if second.Cursor != "" {
+		t.Error("The last page should not have a cursor")
+	}
+
+	opts.Cursor = "!"
+	if _, err := db.ListProjects(context.Background(), opts); err != database.ErrInvalidCursor {
+		t.Error("A malformed cursor should be rejected")
+	}
+}
+
+func TestGetProject(t *testing.T) {
+	db := getStubDatabase()
+
+	p, err := db.GetProject(context.Background(), "a", "master", "1.13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Data.Score.Rank != "A" {
+		t.Error("Wrong project loaded")
+	}
+
+	if _, err := db.GetProject(context.Background(), "a", "develop", "1.13"); err != database.ErrNotFound {
+		t.Error("Unknown projects should not be found")
+	}
+}
+
+func getStubDatabase() *memory.Memory {
+	now := time.Now()
+	db := memory.New()
+	for _, p := range []struct {
+		repository string
+		goVersion  string
+		score      float64
+		rank       string
+		stars      int
+		age        time.Duration
+	}{
+		{"a", "1.13", 95, "A", 500, 2 * time.Hour},
+		{"b", "1.12", 40, "F-", 900, time.Hour},
+		{"c", "1.13", 85, "B", 10, 0},
+		{"d", "1.13", 85, "B", 100, 3 * time.Hour},
+	} {
+		pr := &database.Project{
+			Repository:  p.repository,
+			Branch:      "master",
+			GoVersion:   p.goVersion,
+			ProcessedAt: now.Add(-p.age),
+		}
+		pr.Data.Score.Value = p.score
+		pr.Data.Score.Rank = p.rank
+		pr.Data.Metadata.Stars = p.stars
+		db.SaveProject(context.Background(), pr)
+	}
+	return db
+}
+
+func repositories(list *database.ProjectList) (out []string) {
+	for _, p := range list.Projects {
+		out = append(out, p.Repository)
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListHistory(t *testing.T) {
+	db := memory.New()
+	now := time.Now()
+	for i, rank := range []string{"C", "B", "A"} {
+		p := &database.Project{Repository: "a", Branch: "master", GoVersion: "1.13", ProcessedAt: now.Add(time.Duration(i) * time.Hour)}
+		p.Data.Score.Rank = rank
+		db.SaveProject(context.Background(), p)
+	}
+
+	var tests = []struct {
+		limit    int
+		expected []string
+	}{
+		{0, []string{"A", "B", "C"}},
+		{2, []string{"A", "B"}},
+	}
+
+	for _, tt := range tests {
+		history, err := db.ListHistory(context.Background(), "a", "master", "1.13", tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range history {
+			got = append(got, p.Data.Score.Rank)
+		}
+		if !equal(got, tt.expected) {
+			t.Errorf("Limit %d: got %v, expected %v", tt.limit, got, tt.expected)
+		}
+	}
+
+	if p, _ := db.GetProject(context.Background(), "a", "master", "1.13"); p.Data.Score.Rank != "A" {
+		t.Error("The latest result should be the last saved")
+	}
+}
+
+func TestListHistorySameCommit(t *testing.T) {
+	db := memory.New()
+	now := time.Now()
+	for i, c := range []struct{ commit, rank string }{
+		{"a1b2c3d", "C"}, {"e4f5a6b", "B"}, {"e4f5a6b", "A"}, {"", "B"}, {"", "A"},
+	} {
+		p := &database.Project{Repository: "a", Branch: "master", GoVersion: "1.13", Commit: c.commit, ProcessedAt: now.Add(time.Duration(i) * time.Hour)}
+		p.Data.Score.Rank = c.rank
+		db.SaveProject(context.Background(), p)
+	}
+
+	history, err := db.ListHistory(context.Background(), "a", "master", "1.13", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range history {
+		got = append(got, p.Commit+":"+p.Data.Score.Rank)
+	}
+	// The unknown commits are never taken for the same one
+	if expected := []string{":A", ":B", "e4f5a6b:A", "a1b2c3d:C"}; !equal(got, expected) {
+		t.Errorf("Got %v, expected %v", got, expected)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	db := memory.New()
+	ctx := context.Background()
+
+	var tests = []struct {
+		desc     string
+		key      string
+		ttl      time.Duration
+		release  bool
+		expected bool
+	}{
+		{"First claim", "message:1", time.Hour, false, true},
+		{"Already claimed", "message:1", time.Hour, false, false},
+		{"Other key", "message:2", -time.Second, false, true},
+		{"Expired", "message:2", time.Hour, false, true},
+		{"Released", "message:1", time.Hour, true, true},
+	}
+
+	for _, tt := range tests {
+		if tt.release {
+			if err := db.Release(ctx, tt.key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		claimed, err := db.Claim(ctx, tt.key, tt.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed != tt.expected {
+			t.Errorf("%s: got %v, expected %v", tt.desc, claimed, tt.expected)
+		}
+	}
+	if err := db.Release(ctx, "message:unknown"); err != nil {
+		t.Errorf("Releasing a missing claim should not fail, got %v", err)
+	}
+
+	// The renewed claims last ttl from now
+	if err := db.Renew(ctx, "message:3", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := db.Claim(ctx, "message:3", time.Hour); claimed {
+		t.Error("A renewed claim should not be claimed again")
+	}
+	if err := db.Renew(ctx, "message:3", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := db.Claim(ctx, "message:3", time.Hour); !claimed {
+		t.Error("A claim renewed in the past should be expired")
+	}
+}
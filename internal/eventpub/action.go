@@ -3,3 +3,23 @@ package eventpub
 func (evp *EventPub) RepositoryAdded(event *RepositoryAddedEvent) error {
 	return evp.sendEvent(TypeRepositoryAdded, event, evp.config.GooglePubSubTopicRepository)
 }
+
+func (evp *EventPub) RunnerProgress(event *RunnerProgressEvent) error {
+	return evp.sendEvent(TypeRunnerProgress, event, evp.config.GooglePubSubTopicProgress)
+}
+
+func (evp *EventPub) RepositoryProcessed(event *RepositoryProcessedEvent) error {
+	return evp.sendEvent(TypeRepositoryProcessed, event, evp.config.GooglePubSubTopicLifecycle)
+}
+
+func (evp *EventPub) RepositoryFailed(event *RepositoryFailedEvent) error {
+	return evp.sendEvent(TypeRepositoryFailed, event, evp.config.GooglePubSubTopicLifecycle)
+}
+
+func (evp *EventPub) ScoreChanged(event *ScoreChangedEvent) error {
+	return evp.sendEvent(TypeScoreChanged, event, evp.config.GooglePubSubTopicLifecycle)
+}
+
+func (evp *EventPub) DeadLetter(event *DeadLetterEvent) error {
+	return evp.sendEvent(TypeDeadLetter, event, evp.config.GooglePubSubTopicDeadLetter)
+}
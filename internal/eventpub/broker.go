@@ -0,0 +1,69 @@
+package eventpub
+
+import (
+	"strings"
+	"sync"
+)
+
+// progressBuffer is the number of events kept for a slow listener,
+// beyond which new events are dropped
+const progressBuffer = 32
+
+// Broker fans out the progress events to the listeners of each project.
+// Publishing to it directly makes it the in-process stand-in of the progress topic.
+type Broker struct {
+	mu        sync.Mutex
+	listeners map[string]map[chan *RunnerProgressEvent]struct{}
+}
+
+// NewBroker creates a broker without listeners.
+func NewBroker() *Broker {
+	return &Broker{
+		listeners: make(map[string]map[chan *RunnerProgressEvent]struct{}),
+	}
+}
+
+// RunnerProgress sends the event to the listeners of the project, without blocking.
+func (b *Broker) RunnerProgress(event *RunnerProgressEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.listeners[progressKey(event.Repository, event.Branch, event.GoVersion)] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe listens to the progress events of a project,
+// the returned function must be called to stop listening.
+func (b *Broker) Subscribe(repository, branch, goVersion string) (<-chan *RunnerProgressEvent, func()) {
+	key := progressKey(repository, branch, goVersion)
+	ch := make(chan *RunnerProgressEvent, progressBuffer)
+
+	b.mu.Lock()
+	if _, ok := b.listeners[key]; !ok {
+		b.listeners[key] = make(map[chan *RunnerProgressEvent]struct{})
+	}
+	b.listeners[key][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.listeners[key], ch)
+			if len(b.listeners[key]) == 0 {
+				delete(b.listeners, key)
+			}
+			close(ch)
+		})
+	}
+}
+
+func progressKey(repository, branch, goVersion string) string {
+	return strings.Join([]string{repository, branch, goVersion}, "@")
+}
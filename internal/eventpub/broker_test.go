@@ -0,0 +1,42 @@
+package eventpub_test
+
+import (
+	"testing"
+
+	"github.com/jgautheron/exago/internal/eventpub"
+)
+
+func TestBroker(t *testing.T) {
+	b := eventpub.NewBroker()
+	foo, stopFoo := b.Subscribe("github.com/foo/foo", "master", "1.13")
+	bar, stopBar := b.Subscribe("github.com/foo/bar", "master", "1.13")
+	defer stopBar()
+
+	b.RunnerProgress(&eventpub.RunnerProgressEvent{Repository: "github.com/foo/foo", Branch: "master", GoVersion: "1.13", Label: "Go Test"})
+	b.RunnerProgress(&eventpub.RunnerProgressEvent{Repository: "github.com/foo/foo", Branch: "master", GoVersion: "1.12", Label: "Go Lint"})
+
+	select {
+	case ev := <-foo:
+		if ev.Label != "Go Test" {
+			t.Errorf("Wrong event %#v", ev)
+		}
+	default:
+		t.Error("The listener should have received the event")
+	}
+
+	select {
+	case ev := <-foo:
+		t.Errorf("Events of other Go versions should not be received, got %#v", ev)
+	case ev := <-bar:
+		t.Errorf("Events of other projects should not be received, got %#v", ev)
+	default:
+	}
+
+	stopFoo()
+	stopFoo()
+	if _, open := <-foo; open {
+		t.Error("The channel should be closed once unsubscribed")
+	}
+	// Publishing without listeners must not block
+	b.RunnerProgress(&eventpub.RunnerProgressEvent{Repository: "github.com/foo/foo", Branch: "master", GoVersion: "1.13"})
+}
@@ -1,11 +1,87 @@
 package eventpub
 
+import "time"
+
+const (
+	TypeRepositoryAdded     = "REPOSITORY_ADDED"
+	TypeRunnerProgress      = "RUNNER_PROGRESS"
+	TypeRepositoryProcessed = "REPOSITORY_PROCESSED"
+	TypeRepositoryFailed    = "REPOSITORY_FAILED"
+	TypeScoreChanged        = "SCORE_CHANGED"
+	TypeDeadLetter          = "DEAD_LETTER"
+)
+
 const (
-	TypeRepositoryAdded = "REPOSITORY_ADDED"
+	ProgressStarted  = "started"
+	ProgressFinished = "finished"
 )
 
 type RepositoryAddedEvent struct {
-	Branch     string `json:"branch"`     // master
-	Repository string `json:"repository"` // full path, github.com/foo/bar
-	GoVersion  string `json:"goVersion"`  // 1.13.6
+	Branch     string `json:"branch"`           // master
+	Repository string `json:"repository"`       // full path, github.com/foo/bar
+	GoVersion  string `json:"goVersion"`        // 1.13.6
+	Commit     string `json:"commit,omitempty"` // head of the branch when requested by a webhook
+	// RequestID is the request ID of the job queued by the API, the message ID
+	// stands for it when the event was published by another service
+	RequestID string `json:"requestId,omitempty"`
+}
+
+type RunnerProgressEvent struct {
+	Branch        string        `json:"branch"`
+	Repository    string        `json:"repository"`
+	GoVersion     string        `json:"goVersion"`
+	Runner        string        `json:"runner"`                  // lint
+	Label         string        `json:"label"`                   // Go Lint (golangci-lint)
+	Status        string        `json:"status"`                  // started, finished
+	ExecutionTime time.Duration `json:"executionTime,omitempty"` // set once finished
+	Error         string        `json:"error,omitempty"`
+}
+
+// RepositoryProcessedEvent is sent once every runner of an analysis succeeded.
+type RepositoryProcessedEvent struct {
+	Branch     string        `json:"branch"`
+	Repository string        `json:"repository"`
+	GoVersion  string        `json:"goVersion"`
+	Commit     string        `json:"commit,omitempty"`
+	Score      float64       `json:"score"`
+	Rank       string        `json:"rank"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// RepositoryFailedEvent is sent when the analysis could not complete,
+// or when some runners failed. Errors are keyed by runner name.
+type RepositoryFailedEvent struct {
+	Branch     string            `json:"branch"`
+	Repository string            `json:"repository"`
+	GoVersion  string            `json:"goVersion"`
+	Commit     string            `json:"commit,omitempty"`
+	Errors     map[string]string `json:"errors"`
+	Duration   time.Duration     `json:"duration"`
+}
+
+// ScoreChangedEvent is sent when the rank of a project differs
+// from the one of its previous result.
+type ScoreChangedEvent struct {
+	Branch        string  `json:"branch"`
+	Repository    string  `json:"repository"`
+	GoVersion     string  `json:"goVersion"`
+	Commit        string  `json:"commit,omitempty"`
+	PreviousScore float64 `json:"previousScore"`
+	PreviousRank  string  `json:"previousRank"`
+	Score         float64 `json:"score"`
+	Rank          string  `json:"rank"`
+}
+
+// DeadLetterEvent holds a message the consumer gave up on, along with the
+// errors of the last attempt. The original attributes and payload are kept
+// so that the message can be published again once the cause is fixed.
+type DeadLetterEvent struct {
+	MessageID  string            `json:"messageId"`
+	Attributes map[string]string `json:"attributes"`
+	Data       []byte            `json:"data"`
+	Attempts   int               `json:"attempts"`
+	Transient  bool              `json:"transient"`
+	Error      string            `json:"error"`
+	Errors     map[string]string `json:"errors,omitempty"` // by runner name
+	FailedAt   time.Time         `json:"failedAt"`
 }
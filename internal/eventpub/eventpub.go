@@ -22,6 +22,23 @@ func NewFromConfig(ctx context.Context, gcCfg *config.GoogleCloudConfig) (*Event
 	return &EventPub{client, gcCfg}, nil
 }
 
+// Ping makes sure that the repository topic exists and can be reached.
+func (evp *EventPub) Ping(ctx context.Context) error {
+	ok, err := evp.client.Topic(evp.config.GooglePubSubTopicRepository).Exists(ctx)
+	if err != nil {
+		return errors.Wrap(err, "Could not reach pubsub")
+	}
+	if !ok {
+		return errors.Errorf("The topic %s does not exist", evp.config.GooglePubSubTopicRepository)
+	}
+	return nil
+}
+
+// Close releases the pubsub client, the pending messages are published first.
+func (evp *EventPub) Close() error {
+	return evp.client.Close()
+}
+
 func (evp *EventPub) sendEvent(typ string, event interface{}, topic string) error {
 	payload, err := json.Marshal(event)
 	if err != nil {
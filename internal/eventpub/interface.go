@@ -1,5 +1,50 @@
 package eventpub
 
-var _ EventPublisher = (*EventPub)(nil)
+import "context"
 
-type EventPublisher interface{}
+var (
+	_ PubSub            = (*EventPub)(nil)
+	_ PubSub            = (*Local)(nil)
+	_ ProgressPublisher = (*Broker)(nil)
+)
+
+// EventPublisher sends the events of the analyses lifecycle.
+type EventPublisher interface {
+	RepositoryAdded(event *RepositoryAddedEvent) error
+	ProgressPublisher
+	LifecyclePublisher
+	DeadLetterPublisher
+}
+
+// ProgressPublisher sends the progress of the analyses.
+type ProgressPublisher interface {
+	RunnerProgress(event *RunnerProgressEvent) error
+}
+
+// LifecyclePublisher sends the outcome of the analyses,
+// meant for the services reacting to them (notifications, dashboards).
+type LifecyclePublisher interface {
+	RepositoryProcessed(event *RepositoryProcessedEvent) error
+	RepositoryFailed(event *RepositoryFailedEvent) error
+	ScoreChanged(event *ScoreChangedEvent) error
+}
+
+// DeadLetterPublisher sets aside the messages that could not be processed.
+type DeadLetterPublisher interface {
+	DeadLetter(event *DeadLetterEvent) error
+}
+
+// EventSubscriber receives the events, until the context is cancelled.
+// The repository events for which fn returns an error are redelivered.
+type EventSubscriber interface {
+	ReceiveRepositoryEvents(ctx context.Context, fn func(*Message) error) error
+	ReceiveRunnerProgress(ctx context.Context, fn func(*RunnerProgressEvent)) error
+}
+
+// PubSub is a message queue connecting the API and the consumers.
+type PubSub interface {
+	EventPublisher
+	EventSubscriber
+	Ping(ctx context.Context) error
+	Close() error
+}
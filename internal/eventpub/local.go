@@ -0,0 +1,213 @@
+package eventpub
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// localQueueSize is the number of repositories waiting to be analyzed
+// beyond which the new ones are rejected
+const localQueueSize = 1000
+
+// localRedeliveryDelay is how long a message nacked waits before it is
+// queued again, so that a failing dependency is not retried in a loop
+const localRedeliveryDelay = time.Second
+
+var (
+	ErrQueueFull = errors.New("The queue is full")
+	ErrClosed    = errors.New("The queue is closed")
+)
+
+// Local is an in-process stand-in for Google Pub/Sub, so that the API and
+// the consumer can run in a single binary. Each repository event is received
+// by a single receiver, the progress events by every receiver.
+type Local struct {
+	repositories chan *Message
+	// The message IDs are prefixed with the creation time of the queue,
+	// so that they are not reused once the process restarted
+	idPrefix string
+	ids      uint64
+
+	mu       sync.Mutex
+	progress map[chan *RunnerProgressEvent]struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewLocal creates an empty in-process queue.
+func NewLocal() *Local {
+	return &Local{
+		repositories: make(chan *Message, localQueueSize),
+		idPrefix:     strconv.FormatInt(time.Now().UnixNano(), 36) + "-",
+		progress:     make(map[chan *RunnerProgressEvent]struct{}),
+		closed:       make(chan struct{}),
+	}
+}
+
+// RepositoryAdded queues the repository, ErrQueueFull is returned
+// rather than blocking the caller if too many are waiting.
+func (l *Local) RepositoryAdded(event *RepositoryAddedEvent) error {
+	msg, err := l.message(TypeRepositoryAdded, event)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-l.closed:
+		return ErrClosed
+	default:
+	}
+	select {
+	case l.repositories <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// RunnerProgress sends the event to the progress receivers, without blocking.
+// Progress is only relevant live, a slow receiver misses the events.
+func (l *Local) RunnerProgress(event *RunnerProgressEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ch := range l.progress {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+// RepositoryProcessed is only logged, no other service listens in-process.
+func (l *Local) RepositoryProcessed(event *RepositoryProcessedEvent) error {
+	return l.logLifecycle(TypeRepositoryProcessed, event)
+}
+
+// RepositoryFailed is only logged, no other service listens in-process.
+func (l *Local) RepositoryFailed(event *RepositoryFailedEvent) error {
+	return l.logLifecycle(TypeRepositoryFailed, event)
+}
+
+// ScoreChanged is only logged, no other service listens in-process.
+func (l *Local) ScoreChanged(event *ScoreChangedEvent) error {
+	return l.logLifecycle(TypeScoreChanged, event)
+}
+
+// DeadLetter logs the message given up on, the in-process queue
+// has no dead-letter topic to replay it from.
+func (l *Local) DeadLetter(event *DeadLetterEvent) error {
+	logrus.WithFields(logrus.Fields{
+		"id":       event.MessageID,
+		"attempts": event.Attempts,
+		"errors":   event.Errors,
+	}).Errorf("Gave up on message: %s", event.Error)
+	return nil
+}
+
+func (l *Local) logLifecycle(typ string, event interface{}) error {
+	msg, err := l.message(typ, event)
+	if err != nil {
+		return err
+	}
+	logrus.WithField("type", typ).Debugf("Lifecycle event %s", msg.Data)
+	return nil
+}
+
+// ReceiveRepositoryEvents passes the queued repositories to fn, one at a time,
+// until the context is cancelled or the queue closed. The messages for which
+// fn returns an error are queued again after localRedeliveryDelay.
+func (l *Local) ReceiveRepositoryEvents(ctx context.Context, fn func(*Message) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-l.closed:
+			return nil
+		case msg := <-l.repositories:
+			if err := fn(msg); err != nil {
+				time.AfterFunc(localRedeliveryDelay, func() { l.redeliver(msg) })
+			}
+		}
+	}
+}
+
+// redeliver queues the message again, it is dropped if the queue is closed or full.
+func (l *Local) redeliver(msg *Message) {
+	select {
+	case <-l.closed:
+		return
+	default:
+	}
+	select {
+	case l.repositories <- msg:
+	default:
+		logrus.WithField("id", msg.ID).Error("Could not redeliver the message, the queue is full")
+	}
+}
+
+// ReceiveRunnerProgress passes the progress events to fn,
+// until the context is cancelled or the queue closed.
+func (l *Local) ReceiveRunnerProgress(ctx context.Context, fn func(*RunnerProgressEvent)) error {
+	ch := make(chan *RunnerProgressEvent, progressBuffer)
+	l.mu.Lock()
+	l.progress[ch] = struct{}{}
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.progress, ch)
+		l.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-l.closed:
+			return nil
+		case ev := <-ch:
+			fn(ev)
+		}
+	}
+}
+
+// Ping fails once the queue is closed.
+func (l *Local) Ping(ctx context.Context) error {
+	select {
+	case <-l.closed:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Close stops the receivers, the repositories still queued are dropped.
+func (l *Local) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// message encodes the event the same way it is sent to Google Pub/Sub.
+func (l *Local) message(typ string, event interface{}) (*Message, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not compose the payload of the `%s` event for queue", typ)
+	}
+	return &Message{
+		ID:         l.idPrefix + strconv.FormatUint(atomic.AddUint64(&l.ids, 1), 10),
+		Attributes: map[string]string{"type": typ},
+		Data:       payload,
+	}, nil
+}
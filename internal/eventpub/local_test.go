@@ -0,0 +1,94 @@
+package eventpub_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/eventpub"
+)
+
+func TestLocalRepositoryEvents(t *testing.T) {
+	l := eventpub.NewLocal()
+	defer l.Close()
+
+	for _, branch := range []string{"master", "develop"} {
+		if err := l.RepositoryAdded(&eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: branch, GoVersion: "1.13"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var received []*eventpub.Message
+	err := l.ReceiveRepositoryEvents(ctx, func(msg *eventpub.Message) error {
+		received = append(received, msg)
+		if len(received) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 2 || received[0].ID == received[1].ID {
+		t.Fatalf("Wrong messages %#v", received)
+	}
+	for i, branch := range []string{"master", "develop"} {
+		var ev eventpub.RepositoryAddedEvent
+		if err := json.Unmarshal(received[i].Data, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if received[i].Attributes["type"] != eventpub.TypeRepositoryAdded || ev.Branch != branch {
+			t.Errorf("Wrong message #%d %#v", i, received[i])
+		}
+	}
+}
+
+func TestLocalRunnerProgress(t *testing.T) {
+	l := eventpub.NewLocal()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := []chan *eventpub.RunnerProgressEvent{
+		make(chan *eventpub.RunnerProgressEvent, 100),
+		make(chan *eventpub.RunnerProgressEvent, 100),
+	}
+	done := make(chan struct{})
+	for _, ch := range received {
+		go func(ch chan *eventpub.RunnerProgressEvent) {
+			l.ReceiveRunnerProgress(ctx, func(ev *eventpub.RunnerProgressEvent) {
+				ch <- ev
+			})
+			done <- struct{}{}
+		}(ch)
+	}
+
+	// Wait for both receivers to be registered, the events sent before are not kept
+	deadline := time.After(time.Second)
+	for len(received[0]) == 0 || len(received[1]) == 0 {
+		l.RunnerProgress(&eventpub.RunnerProgressEvent{Label: "Go Test"})
+		select {
+		case <-deadline:
+			t.Fatal("Every receiver should get the progress events")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	l.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("The receivers should stop once the queue is closed")
+		}
+	}
+
+	if err := l.RepositoryAdded(&eventpub.RepositoryAddedEvent{}); err != eventpub.ErrClosed {
+		t.Errorf("Got %v, publishing to a closed queue should fail", err)
+	}
+	if err := l.Ping(context.Background()); err != eventpub.ErrClosed {
+		t.Errorf("Got %v, a closed queue should not be ready", err)
+	}
+}
@@ -0,0 +1,108 @@
+package eventpub
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// progressSubscriptionPrefix is followed by the host name in the default
+	// name of the progress subscription of an instance
+	progressSubscriptionPrefix = "progress-api-"
+	// progressSubscriptionExpiration deletes the subscriptions of the instances gone,
+	// a day being the shortest expiration allowed
+	progressSubscriptionExpiration = 24 * time.Hour
+)
+
+// ReceiveRunnerProgress pulls the progress events published by the consumers
+// and passes them to fn, until the context is cancelled.
+func (evp *EventPub) ReceiveRunnerProgress(ctx context.Context, fn func(*RunnerProgressEvent)) error {
+	sub, err := evp.progressSubscription(ctx)
+	if err != nil {
+		return err
+	}
+	err = sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		// Progress is only relevant live, there is no point in redelivering it
+		msg.Ack()
+		if msg.Attributes["type"] != TypeRunnerProgress {
+			return
+		}
+
+		var ev RunnerProgressEvent
+		if err := json.Unmarshal(msg.Data, &ev); err != nil {
+			logrus.WithError(err).Error("Cannot unmarshal JSON payload")
+			return
+		}
+		fn(&ev)
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not receive progress events")
+	}
+	return nil
+}
+
+// progressSubscription returns the subscription of the instance to the progress events,
+// the one configured or else one named after the host, which is created if missing.
+func (evp *EventPub) progressSubscription(ctx context.Context) (*pubsub.Subscription, error) {
+	if name := evp.config.GooglePubSubSubscriptionProgress; name != "" {
+		return evp.client.Subscription(name), nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not name the progress subscription")
+	}
+	name := progressSubscriptionPrefix + hostname
+	sub := evp.client.Subscription(name)
+	ok, err := sub.Exists(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not load the progress subscription %s", name)
+	}
+	if ok {
+		return sub, nil
+	}
+
+	sub, err = evp.client.CreateSubscription(ctx, name, pubsub.SubscriptionConfig{
+		Topic: evp.client.Topic(evp.config.GooglePubSubTopicProgress),
+		// Progress is only relevant live, the shortest retention is enough
+		RetentionDuration: 10 * time.Minute,
+		ExpirationPolicy:  progressSubscriptionExpiration,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not create the progress subscription %s", name)
+	}
+	logrus.WithField("subscription", name).Info("Created the progress subscription")
+	return sub, nil
+}
+
+// Message is a message pulled from a subscription.
+type Message struct {
+	ID         string
+	Attributes map[string]string
+	Data       []byte
+}
+
+// ReceiveRepositoryEvents pulls the repositories to analyze and passes them to fn,
+// the message is acknowledged once fn returns, or nacked to be redelivered if
+// fn returns an error. When the context is cancelled, no more messages are
+// pulled and the call returns once every fn returned.
+func (evp *EventPub) ReceiveRepositoryEvents(ctx context.Context, fn func(*Message) error) error {
+	sub := evp.client.Subscription(evp.config.GooglePubSubSubscriptionRepository)
+	err := sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		if err := fn(&Message{ID: msg.ID, Attributes: msg.Attributes, Data: msg.Data}); err != nil {
+			msg.Nack()
+			return
+		}
+		msg.Ack()
+	})
+	if err != nil {
+		return errors.Wrap(err, "Could not receive repository events")
+	}
+	return nil
+}
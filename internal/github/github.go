@@ -3,7 +3,10 @@ package github
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"math/rand"
+	"net/http"
+	"strings"
 	"time"
 
 	gh "github.com/google/go-github/github"
@@ -12,6 +15,8 @@ import (
 	"golang.org/x/oauth2"
 )
 
+var ErrNotFound = errors.New("Not found")
+
 type GitHub struct {
 	accessTokenList  []string
 	accessTokenIndex int
@@ -58,12 +63,21 @@ func (g GitHub) DisplayRateLimit(ctx context.Context) {
 	}
 }
 
-// GetFileContent loads the file content of the given repository/filename.
-func (g GitHub) GetFileContent(ctx context.Context, owner, repository, path string) (string, error) {
-	file, _, _, err := g.repositories().GetContents(ctx, owner, repository, path, nil)
+// GetFileContent loads the file content of the given repository/filename,
+// at the given branch, tag or commit (default branch if empty).
+func (g GitHub) GetFileContent(ctx context.Context, owner, repository, path, ref string) (string, error) {
+	opts := &gh.RepositoryContentGetOptions{Ref: ref}
+	file, _, resp, err := g.repositories().GetContents(ctx, owner, repository, path, opts)
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return "", ErrNotFound
+		}
 		return "", err
 	}
+	// The path is a directory
+	if file == nil {
+		return "", ErrNotFound
+	}
 	out := *file.Content
 
 	g.DisplayRateLimit(ctx)
@@ -79,9 +93,13 @@ func (g GitHub) GetFileContent(ctx context.Context, owner, repository, path stri
 }
 
 // Get builds a trimmed down map of the few things we need to know about a repository.
+// ErrNotFound is returned if the repository does not exist or is private.
 func (g GitHub) Get(ctx context.Context, owner, repository string) (map[string]interface{}, error) {
-	repo, _, err := g.repositories().Get(ctx, owner, repository)
+	repo, resp, err := g.repositories().Get(ctx, owner, repository)
 	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -109,6 +127,41 @@ func (g GitHub) Get(ctx context.Context, owner, repository string) (map[string]i
 	return mp, nil
 }
 
+// HasBranch checks that the branch exists in the given repository.
+func (g GitHub) HasBranch(ctx context.Context, owner, repository, branch string) (bool, error) {
+	_, resp, err := g.repositories().GetBranch(ctx, owner, repository, branch)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// BranchHead returns the SHA of the last commit of the branch,
+// ErrNotFound is returned if the branch does not exist.
+func (g GitHub) BranchHead(ctx context.Context, owner, repository, branch string) (string, error) {
+	b, resp, err := g.repositories().GetBranch(ctx, owner, repository, branch)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return b.GetCommit().GetSHA(), nil
+}
+
+// ParseRepository extracts the owner and name of a github.com/owner/name path,
+// the host being case insensitive.
+func ParseRepository(repository string) (owner, name string, ok bool) {
+	sp := strings.Split(strings.TrimSuffix(repository, ".git"), "/")
+	if len(sp) != 3 || !strings.EqualFold(sp[0], "github.com") || sp[1] == "" || sp[2] == "" {
+		return "", "", false
+	}
+	return sp[1], sp[2], true
+}
+
 // repositories is a short-hand for the GitHub repos API.
 // https://developer.github.com/v3/repos/
 func (g GitHub) repositories() *gh.RepositoriesService {
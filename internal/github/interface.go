@@ -0,0 +1,13 @@
+package github
+
+import "context"
+
+var _ RepositoryHost = (*GitHub)(nil)
+
+// RepositoryHost is the API of the service hosting the repositories.
+type RepositoryHost interface {
+	GetFileContent(ctx context.Context, owner, repository, path, ref string) (string, error)
+	Get(ctx context.Context, owner, repository string) (map[string]interface{}, error)
+	HasBranch(ctx context.Context, owner, repository, branch string) (bool, error)
+	BranchHead(ctx context.Context, owner, repository, branch string) (string, error)
+}
@@ -0,0 +1,97 @@
+// Package ratelimit limits the number of calls made by a client over a time window.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+type counter struct {
+	calls int
+	reset time.Time
+}
+
+// Limiter allows a fixed number of calls per key within each window.
+type Limiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New creates a limiter allowing limit calls per window,
+// a limit lower or equal to zero disables the limiter.
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:    limit,
+		window:   window,
+		counters: make(map[string]*counter),
+		now:      time.Now,
+	}
+}
+
+// Limit returns the number of calls allowed per window.
+func (l *Limiter) Limit() int {
+	return l.limit
+}
+
+// Allow consumes a call for the given key. It returns whether the call is allowed,
+// the number of calls left and the time at which the window resets.
+func (l *Limiter) Allow(key string) (ok bool, remaining int, reset time.Time) {
+	now := l.now()
+	if l.limit <= 0 {
+		return true, 0, now
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	c, found := l.counters[key]
+	if !found || !now.Before(c.reset) {
+		c = &counter{reset: now.Add(l.window)}
+		l.counters[key] = c
+	}
+	if c.calls >= l.limit {
+		return false, 0, c.reset
+	}
+	c.calls++
+	return true, l.limit - c.calls, c.reset
+}
+
+// Refund gives back a call allowed for the given key, unless its window
+// was reset meanwhile. It returns the number of calls left.
+func (l *Limiter) Refund(key string) int {
+	if l.limit <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, found := l.counters[key]
+	if !found || !l.now().Before(c.reset) {
+		return l.limit
+	}
+	if c.calls > 0 {
+		c.calls--
+	}
+	return l.limit - c.calls
+}
+
+// sweep drops the expired counters, at most once per window.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	for key, c := range l.counters {
+		if !now.Before(c.reset) {
+			delete(l.counters, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Now()
+	l := New(2, time.Hour)
+	l.now = func() time.Time { return now }
+
+	var tests = []struct {
+		key       string
+		elapsed   time.Duration
+		ok        bool
+		remaining int
+	}{
+		{"foo", 0, true, 1},
+		{"foo", time.Minute, true, 0},
+		{"foo", 2 * time.Minute, false, 0},
+		{"bar", 2 * time.Minute, true, 1},
+		{"foo", time.Hour, true, 1},
+	}
+
+	for i, tt := range tests {
+		l.now = func() time.Time { return now.Add(tt.elapsed) }
+		ok, remaining, _ := l.Allow(tt.key)
+		if ok != tt.ok || remaining != tt.remaining {
+			t.Errorf("Call #%d: got (%t, %d), expected (%t, %d)", i, ok, remaining, tt.ok, tt.remaining)
+		}
+	}
+}
+
+func TestRefund(t *testing.T) {
+	l := New(1, time.Hour)
+	l.Allow("foo")
+	if remaining := l.Refund("foo"); remaining != 1 {
+		t.Errorf("Got %d calls left once refunded, expected 1", remaining)
+	}
+	if ok, _, _ := l.Allow("foo"); !ok {
+		t.Error("The call refunded should be allowed again")
+	}
+	if remaining := l.Refund("bar"); remaining != 1 {
+		t.Errorf("Got %d calls left for an unknown key, expected 1", remaining)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	l := New(0, time.Hour)
+	for i := 0; i < 100; i++ {
+		if ok, _, _ := l.Allow("foo"); !ok {
+			t.Fatal("A disabled limiter should allow every call")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jgautheron/exago/internal/badge"
+	"github.com/jgautheron/exago/internal/database"
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/jgautheron/exago/pkg/analysis/score"
+	"github.com/sirupsen/logrus"
+)
+
+// badgeFormatShields selects the shields.io endpoint JSON instead of the SVG badge
+const badgeFormatShields = "shields"
+
+// badgeKind describes what a badge type displays.
+type badgeKind struct {
+	title string
+	value func(d exago.Data) string
+}
+
+var badgeKinds = map[string]badgeKind{
+	"rank": {"", func(d exago.Data) string {
+		if d.Score.Rank == "" {
+			return score.Rank(d.Score.Value)
+		}
+		return d.Score.Rank
+	}},
+	"cov": {"coverage", func(d exago.Data) string {
+		return fmt.Sprintf("%.2f%%", d.Results.GetMeanCodeCov())
+	}},
+	"duration": {"tests duration", func(d exago.Data) string {
+		return fmt.Sprintf("%.2fs", d.Results.GetMeanTestDuration())
+	}},
+	"tests": {"tests", func(d exago.Data) string {
+		return fmt.Sprintf("%d", d.Results.CodeStats.Data["test"])
+	}},
+	"thirdparties": {"3rd parties", func(d exago.Data) string {
+		return fmt.Sprintf("%d", len(d.Results.ThirdParties.Data))
+	}},
+	"loc": {"LOC", func(d exago.Data) string {
+		return fmt.Sprintf("%d", d.Results.CodeStats.Data["loc"])
+	}},
+}
+
+// getBadgeKind returns the badge kind for the given type, falling back on the rank.
+func getBadgeKind(tp string) badgeKind {
+	if k, ok := badgeKinds[tp]; ok {
+		return k
+	}
+	return badgeKinds["rank"]
+}
+
+// badgeHandler renders the badge of the latest analysis of a repository,
+// either as SVG or as shields.io endpoint JSON (format=shields).
+// A grey "unknown" badge is sent if the repository was never analyzed,
+// so that READMEs never show a broken image.
+func (s Server) badgeHandler(w http.ResponseWriter, r *http.Request) {
+	repository := chi.URLParam(r, "*")
+	kind := getBadgeKind(chi.URLParam(r, "type"))
+
+	q := r.URL.Query()
+	style := badge.ParseStyle(q.Get("style"))
+	branch := q.Get("branch")
+	if branch == "" {
+		branch = defaultBranch
+	}
+
+	shields := q.Get("format") == badgeFormatShields
+
+	p, err := s.findProject(r.Context(), repository, branch, q.Get("goVersion"))
+	if err != nil {
+		if err != database.ErrNotFound {
+			logrus.WithError(err).Errorf("Could not load project %s", repository)
+		}
+		if shields {
+			render.JSON(w, r, badge.NewErrorEndpoint(kind.title))
+			return
+		}
+		badge.WriteError(w, kind.title, style)
+		return
+	}
+
+	if shields {
+		render.JSON(w, r, badge.NewEndpoint(kind.title, kind.value(p.Data), p.Data.Score.Value))
+		return
+	}
+	badge.Write(w, kind.title, kind.value(p.Data), p.Data.Score.Value, style)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/jgautheron/exago/internal/database"
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/sirupsen/logrus"
+)
+
+// commitPattern matches full or abbreviated commit hashes.
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// snapshot identifies one of the compared analyses.
+type snapshot struct {
+	Branch      string     `json:"branch"`
+	GoVersion   string     `json:"goVersion"`
+	Commit      string     `json:"commit,omitempty"`
+	CommitDate  *time.Time `json:"commitDate,omitempty"`
+	ProcessedAt time.Time  `json:"processedAt"`
+}
+
+type compareResponse struct {
+	Repository string           `json:"repository"`
+	Base       snapshot         `json:"base"`
+	Head       snapshot         `json:"head"`
+	Diff       exago.Comparison `json:"diff"`
+}
+
+// compareHandler diffs two analyses of a repository, typically a feature
+// branch against master. The base and head are given as a branch name,
+// optionally followed by @ and a commit to pick a past analysis of the branch.
+func (s Server) compareHandler(w http.ResponseWriter, r *http.Request) {
+	repository := chi.URLParam(r, "*")
+	q := r.URL.Query()
+	goVersion := q.Get("goVersion")
+
+	baseRef := q.Get("base")
+	if baseRef == "" {
+		baseRef = defaultBranch
+	}
+	refs := []struct{ field, ref string }{{"base", baseRef}, {"head", q.Get("head")}}
+
+	var projects []*database.Project
+	for _, rf := range refs {
+		branch, commit, ok := parseRef(rf.ref)
+		if !ok {
+			writeError(w, r, errValidation(rf.field, ErrInvalidRef))
+			return
+		}
+		p, err := s.findSnapshot(r.Context(), repository, branch, commit, goVersion)
+		switch {
+		case err == database.ErrNotFound:
+			writeError(w, r, newErrResponse(http.StatusNotFound, codeNotFound, ErrProjectNotFound, map[string]string{"field": rf.field}))
+			return
+		case err != nil:
+			logrus.WithError(err).Errorf("Could not load project %s", repository)
+			writeError(w, r, errInternal())
+			return
+		}
+		projects = append(projects, p)
+	}
+
+	base, head := projects[0], projects[1]
+	render.JSON(w, r, compareResponse{
+		Repository: base.Repository,
+		Base:       snapshot{base.Branch, base.GoVersion, base.Commit, base.CommitDate, base.ProcessedAt},
+		Head:       snapshot{head.Branch, head.GoVersion, head.Commit, head.CommitDate, head.ProcessedAt},
+		Diff:       exago.Compare(base.Data, head.Data),
+	})
+}
+
+// parseRef splits a branch@commit reference, the commit being optional.
+func parseRef(ref string) (branch, commit string, ok bool) {
+	branch = ref
+	if i := strings.LastIndex(ref, "@"); i != -1 {
+		branch, commit = ref[:i], ref[i+1:]
+		if !commitPattern.MatchString(commit) {
+			return "", "", false
+		}
+	}
+	return branch, commit, branch != ""
+}
+
+// findSnapshot loads the latest result of the branch or, given a commit,
+// the most recent analysis of that commit in the history of the branch.
+func (s Server) findSnapshot(ctx context.Context, repository, branch, commit, goVersion string) (*database.Project, error) {
+	p, err := s.findProject(ctx, repository, branch, goVersion)
+	if err != nil || commit == "" || strings.HasPrefix(p.Commit, commit) {
+		return p, err
+	}
+
+	history, err := s.db.ListHistory(ctx, repository, branch, p.GoVersion, database.MaxListLimit)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range history {
+		if strings.HasPrefix(h.Commit, commit) {
+			return h, nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
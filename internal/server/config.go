@@ -9,6 +9,12 @@ var Config Cfg
 type Cfg struct {
 	config.LogConfig
 	config.HTTPConfig
+	config.ShutdownConfig
+	config.DatabaseConfig
+	config.GitHubConfig
+	config.UploadConfig
+	config.ModulesConfig
+	config.TimeoutConfig
 	config.GoogleCloudConfig
 }
 
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// Error codes sent to the clients, see the ErrorResponse schema of the OpenAPI spec.
+const (
+	codeValidationFailed   = "validation_failed"
+	codeInvalidRepository  = "invalid_repository"
+	codeRepositoryNotFound = "repository_not_found"
+	codeBranchNotFound     = "branch_not_found"
+	codeInvalidSignature   = "invalid_signature"
+	codeAPIKeyRequired     = "api_key_required"
+	codeNotFound           = "not_found"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeAlreadyPending     = "already_pending"
+	codeNotPending         = "not_pending"
+	codeRateLimited        = "rate_limited"
+	codeHostUnavailable    = "host_unavailable"
+	codeQueueUnavailable   = "queue_unavailable"
+	codeNotReady           = "not_ready"
+	codeUploadsBusy        = "uploads_busy"
+	codeAnalysisFailed     = "analysis_failed"
+	codeInternal           = "internal_error"
+)
+
+// APIError describes what went wrong, Details holds the context of the error
+// such as the invalid parameters.
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// ErrResponse is the envelope of every error sent by the API.
+type ErrResponse struct {
+	Status int      `json:"-"`
+	Error  APIError `json:"error"`
+}
+
+// Render sets the status code of the response.
+func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	render.Status(r, e.Status)
+	return nil
+}
+
+// newErrResponse builds the error envelope, details may be nil.
+func newErrResponse(status int, code string, err error, details map[string]string) *ErrResponse {
+	return &ErrResponse{
+		Status: status,
+		Error: APIError{
+			Code:    code,
+			Message: err.Error(),
+			Details: details,
+		},
+	}
+}
+
+// writeError sends the error envelope.
+func writeError(w http.ResponseWriter, r *http.Request, e *ErrResponse) {
+	render.Render(w, r, e)
+}
+
+func errValidation(field string, err error) *ErrResponse {
+	return newErrResponse(http.StatusBadRequest, codeValidationFailed, err, map[string]string{"field": field})
+}
+
+func errNotFound(err error) *ErrResponse {
+	return newErrResponse(http.StatusNotFound, codeNotFound, err, nil)
+}
+
+// errInternal hides the cause of the error, which is logged instead.
+func errInternal() *ErrResponse {
+	return newErrResponse(http.StatusInternalServerError, codeInternal, ErrInternal, nil)
+}
+
+// notFound is used for the routes that don't exist.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, errNotFound(ErrRouteNotFound))
+}
+
+// methodNotAllowed is used for the routes that exist with other methods.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, newErrResponse(http.StatusMethodNotAllowed, codeMethodNotAllowed, ErrMethodNotAllowed, nil))
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jgautheron/exago/internal/database/memory"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	db := memory.New()
+	s := &Server{db: db}
+
+	var tests = []struct {
+		method     string
+		url        string
+		statusCode int
+		code       string
+		field      string
+	}{
+		{"GET", "/projects/top?limit=500", http.StatusBadRequest, codeValidationFailed, "limit"},
+		{"GET", "/projects/top?rank=Z", http.StatusBadRequest, codeValidationFailed, "rank"},
+		{"GET", "/projects/top?goVersion=latest", http.StatusBadRequest, codeValidationFailed, "goVersion"},
+		{"GET", "/projects/top?cursor=foo", http.StatusBadRequest, codeValidationFailed, "cursor"},
+		{"GET", "/project/1.13/master/status/github.com/foo/bar", http.StatusNotFound, codeNotFound, ""},
+		{"GET", "/file/github.com/foo/bar/baz.go", http.StatusNotFound, codeNotFound, ""},
+		{"GET", "/unknown", http.StatusNotFound, codeNotFound, ""},
+		{"POST", "/projects/top", http.StatusMethodNotAllowed, codeMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest(tt.method, tt.url, nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s %s: got status %d, expected %d", tt.method, tt.url, w.Code, tt.statusCode)
+			continue
+		}
+
+		var res ErrResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s %s: %v", tt.method, tt.url, err)
+		}
+		if res.Error.Code != tt.code || res.Error.Message == "" || res.Error.Details["field"] != tt.field {
+			t.Errorf("%s %s: wrong error %#v", tt.method, tt.url, res.Error)
+		}
+	}
+}
+
+func TestOpenAPISpec(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&Server{}).routes().ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	var spec struct {
+		Paths      map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas struct {
+				ErrorResponse struct {
+					Properties struct {
+						Error struct {
+							Properties struct {
+								Code struct {
+									Enum []string `json:"enum"`
+								} `json:"code"`
+							} `json:"properties"`
+						} `json:"error"`
+					} `json:"properties"`
+				} `json:"ErrorResponse"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Paths) == 0 {
+		t.Error("The spec should document the endpoints")
+	}
+
+	documented := make(map[string]bool)
+	for _, code := range spec.Components.Schemas.ErrorResponse.Properties.Error.Properties.Code.Enum {
+		documented[code] = true
+	}
+	for _, code := range []string{
+		codeValidationFailed, codeInvalidRepository, codeRepositoryNotFound, codeBranchNotFound,
+		codeInvalidSignature, codeAPIKeyRequired, codeNotFound, codeMethodNotAllowed, codeAlreadyPending, codeNotPending, codeRateLimited,
+		codeHostUnavailable, codeQueueUnavailable, codeNotReady, codeUploadsBusy, codeAnalysisFailed,
+		codeInternal,
+	} {
+		if !documented[code] {
+			t.Errorf("The error code %s is not documented", code)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// eventsKeepAlive is the interval at which a comment is sent
+	// to prevent proxies from closing idle streams
+	eventsKeepAlive = 15 * time.Second
+	// eventsJobPoll is the interval at which the job is checked,
+	// the stream ends once the analysis is over
+	eventsJobPoll = 5 * time.Second
+)
+
+// progressEvents streams the progress of the analysis of a project
+// as Server-Sent Events, one event each time a runner starts or finishes.
+// Once the analysis is over, the job is sent in a final state event
+// and the stream ends.
+func (s Server) progressEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, errInternal())
+		return
+	}
+
+	repository, branch, goVersion := projectPath(r), chi.URLParam(r, "branch"), chi.URLParam(r, "goVersion")
+	events, stop := s.progress.Subscribe(repository, branch, goVersion)
+	defer stop()
+	over := func() bool {
+		return s.writeFinalState(r.Context(), w, repository, branch, goVersion)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	// The analysis may be over before the client subscribed
+	done := over()
+	flusher.Flush()
+	if done {
+		return
+	}
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+	poll := time.NewTicker(eventsJobPoll)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-poll.C:
+			done = over()
+		case ev := <-events:
+			b, err := json.Marshal(ev)
+			if err != nil {
+				logrus.WithError(err).Error("Could not marshal the progress event")
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Status, b)
+			done = ev.Status == eventpub.ProgressFinished && over()
+		}
+		flusher.Flush()
+		if done {
+			return
+		}
+	}
+}
+
+// writeFinalState sends the job in a state event if the analysis is over,
+// which is reported. The unknown jobs may be queued later, they are not over.
+func (s Server) writeFinalState(ctx context.Context, w io.Writer, repository, branch, goVersion string) bool {
+	job, err := s.db.GetJob(ctx, repository, branch, goVersion)
+	switch {
+	case err == database.ErrNotFound:
+		return false
+	case err != nil:
+		logrus.WithError(err).Warnf("Could not load job of %s", repository)
+		return false
+	case job.Pending():
+		return false
+	}
+
+	b, err := json.Marshal(job)
+	if err != nil {
+		logrus.WithError(err).Error("Could not marshal the job")
+		return false
+	}
+	fmt.Fprintf(w, "event: state\ndata: %s\n\n", b)
+	return true
+}
@@ -1,105 +1,339 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	"github.com/jgautheron/exago/internal/database"
 	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/internal/github"
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/jgautheron/exago/pkg/analysis/score"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-func (s Server) testHandler(w http.ResponseWriter, r *http.Request) {
-	render.Status(r, http.StatusOK)
+const defaultBranch = "master"
+
+// projectItem is the summary of a project displayed in listings.
+type projectItem struct {
+	Repository  string    `json:"repository"`
+	Branch      string    `json:"branch"`
+	GoVersion   string    `json:"goVersion"`
+	Rank        string    `json:"rank"`
+	Score       float64   `json:"score"`
+	Stars       int       `json:"stars"`
+	Description string    `json:"description"`
+	Image       string    `json:"image"`
+	ProcessedAt time.Time `json:"processedAt"`
+}
+
+type projectListResponse struct {
+	Projects []projectItem `json:"projects"`
+	Cursor   string        `json:"cursor,omitempty"`
+}
+
+// historyItem is the score and main KPIs of a past analysis.
+type historyItem struct {
+	Commit       string     `json:"commit,omitempty"`
+	CommitDate   *time.Time `json:"commitDate,omitempty"`
+	ProcessedAt  time.Time  `json:"processedAt"`
+	Score        float64    `json:"score"`
+	Rank         string     `json:"rank"`
+	Coverage     float64    `json:"coverage"`
+	LintMessages int        `json:"lintMessages"`
+	ThirdParties int        `json:"thirdParties"`
+	LOC          int        `json:"loc"`
+}
+
+type historyResponse struct {
+	History []historyItem `json:"history"`
+}
+
+// projectPath returns the repository found in the wildcard of the project routes.
+func projectPath(r *http.Request) string {
+	return strings.Trim(chi.URLParam(r, "*"), "/")
 }
 
 func (s Server) processRepository(w http.ResponseWriter, r *http.Request) {
-	branch := chi.URLParam(r, "branch")
-	repository := chi.URLParam(r, "*")
-	goVersion := chi.URLParam(r, "goVersion")
+	job, err := s.enqueue(r.Context(), &eventpub.RepositoryAddedEvent{
+		Branch:     chi.URLParam(r, "branch"),
+		Repository: requestedRepository(r),
+		GoVersion:  chi.URLParam(r, "goVersion"),
+	})
+	switch {
+	case err == ErrQueueUnavailable:
+		writeError(w, r, newErrResponse(http.StatusServiceUnavailable, codeQueueUnavailable, ErrQueueUnavailable, nil))
+		return
+	case err != nil:
+		logrus.WithError(err).Error("Could not enqueue repo")
+		writeError(w, r, errInternal())
+		return
+	}
+
+	// The job holds the canonical repository path to follow the analysis with
+	render.JSON(w, r, job)
+}
 
-	// Simple input validation
-	rg := regexp.MustCompile(`^([a-z0-9\.\-_/]+)$`)
-	if !rg.MatchString(branch) || !rg.MatchString(repository) {
-		w.WriteHeader(http.StatusBadRequest)
+// enqueue saves the queued job then publishes the analysis request, the job
+// is saved first so that the consumer never finds it missing. The event carries
+// the request ID of the job, which its cancellation applies to. ErrQueueUnavailable
+// is returned, and the job marked as failed, if the request could not be published.
+func (s Server) enqueue(ctx context.Context, ev *eventpub.RepositoryAddedEvent) (*database.Job, error) {
+	job := database.NewJob(ev.Repository, ev.Branch, ev.GoVersion)
+	ev.RequestID = job.RequestID
+	if err := s.db.SaveJob(ctx, job); err != nil {
+		return nil, errors.Wrapf(err, "Could not save job %s", job.ID())
+	}
+
+	if err := s.evp.RepositoryAdded(ev); err != nil {
+		logrus.WithError(err).Errorf("Could not enqueue repo: %#v", ev)
+		job.SetState(database.JobFailed, map[string]string{"queue": err.Error()})
+		if err := s.db.SaveJob(ctx, job); err != nil {
+			logrus.WithError(err).Errorf("Could not save job %s", job.ID())
+		}
+		return job, ErrQueueUnavailable
+	}
+	return job, nil
+}
+
+// jobStatus returns the state of the latest analysis of the project.
+func (s Server) jobStatus(w http.ResponseWriter, r *http.Request) {
+	repository := projectPath(r)
+	job, err := s.db.GetJob(r.Context(), repository, chi.URLParam(r, "branch"), chi.URLParam(r, "goVersion"))
+	switch {
+	case err == database.ErrNotFound:
+		writeError(w, r, errNotFound(ErrJobNotFound))
+		return
+	case err != nil:
+		logrus.WithError(err).Errorf("Could not load job of %s", repository)
+		writeError(w, r, errInternal())
 		return
 	}
-	if match, _ := regexp.MatchString(`^([0-9\.]+)$`, goVersion); !match {
-		w.WriteHeader(http.StatusBadRequest)
+
+	render.JSON(w, r, job)
+}
+
+// cancelJob cancels the queued or running analysis of the project, the
+// consumer skips it or kills its commands once it notices. Only the request
+// the job tracks is cancelled, the later ones are analyzed.
+func (s Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	repository := projectPath(r)
+
+	job, err := s.db.GetJob(r.Context(), repository, chi.URLParam(r, "branch"), chi.URLParam(r, "goVersion"))
+	switch {
+	case err == database.ErrNotFound:
+		writeError(w, r, errNotFound(ErrJobNotFound))
+		return
+	case err != nil:
+		logrus.WithError(err).Errorf("Could not load job of %s", repository)
+		writeError(w, r, errInternal())
+		return
+	case !job.Pending():
+		writeError(w, r, newErrResponse(http.StatusConflict, codeNotPending, ErrNotPending, map[string]string{
+			"state": string(job.State),
+		}))
 		return
 	}
 
-	ev := eventpub.RepositoryAddedEvent{
-		Branch:     branch,
-		Repository: repository,
-		GoVersion:  goVersion,
+	job.SetState(database.JobCancelled, nil)
+	if err := s.db.SaveJob(r.Context(), job); err != nil {
+		logrus.WithError(err).Errorf("Could not cancel job %s", job.ID())
+		writeError(w, r, errInternal())
+		return
+	}
+	logrus.WithField("repository", repository).Info("Analysis cancelled")
+	render.JSON(w, r, job)
+}
+
+// projectHistory returns the score and main KPIs of each analysis
+// of the project, the most recent first.
+func (s Server) projectHistory(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(r.URL.Query().Get("limit"))
+	if !ok {
+		writeError(w, r, errValidation("limit", ErrInvalidLimit))
+		return
 	}
-	if err := s.evp.RepositoryAdded(&ev); err != nil {
-		logrus.Errorf("Could not enqueue repo: %#v", ev)
-		w.WriteHeader(http.StatusInternalServerError)
+
+	repository := projectPath(r)
+	history, err := s.db.ListHistory(r.Context(), repository, chi.URLParam(r, "branch"), chi.URLParam(r, "goVersion"), limit)
+	switch {
+	case err != nil:
+		logrus.WithError(err).Errorf("Could not load the history of %s", repository)
+		writeError(w, r, errInternal())
+		return
+	case len(history) == 0:
+		writeError(w, r, errNotFound(ErrProjectNotFound))
 		return
 	}
 
-	render.Status(r, http.StatusOK)
+	res := historyResponse{History: []historyItem{}}
+	for _, p := range history {
+		rank := p.Data.Score.Rank
+		if rank == "" {
+			rank = score.Rank(p.Data.Score.Value)
+		}
+		res.History = append(res.History, historyItem{
+			Commit:       p.Commit,
+			CommitDate:   p.CommitDate,
+			ProcessedAt:  p.ProcessedAt,
+			Score:        p.Data.Score.Value,
+			Rank:         rank,
+			Coverage:     p.Data.Results.GetMeanCodeCov(),
+			LintMessages: p.Data.Results.CountLintMessages(),
+			ThirdParties: len(p.Data.Results.ThirdParties.Data),
+			LOC:          p.Data.Results.CodeStats.Data["loc"],
+		})
+	}
+	render.JSON(w, r, res)
+}
+
+// parseLimit validates the page size requested, zero is returned if none was.
+func parseLimit(value string) (int, bool) {
+	if value == "" {
+		return 0, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > database.MaxListLimit {
+		return 0, false
+	}
+	return limit, true
+}
+
+// findProject loads the result of a repository. Without Go version,
+// the most recent analysis of the branch is returned, whatever the version.
+func (s Server) findProject(ctx context.Context, repository, branch, goVersion string) (*database.Project, error) {
+	if goVersion != "" {
+		return s.db.GetProject(ctx, repository, branch, goVersion)
+	}
+
+	list, err := s.db.ListProjects(ctx, database.ListOptions{
+		Order:      database.OrderRecent,
+		Repository: repository,
+		Branch:     branch,
+		Limit:      1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Projects) == 0 {
+		return nil, database.ErrNotFound
+	}
+	return list.Projects[0], nil
+}
+
+// listProjects returns a handler listing the projects in the given order.
+// The results can be filtered by rank and Go version, and paginated
+// by passing the cursor of the previous page.
+func (s Server) listProjects(order database.ListOrder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		opts := database.ListOptions{
+			Order:     order,
+			Rank:      q.Get("rank"),
+			GoVersion: q.Get("goVersion"),
+			Cursor:    q.Get("cursor"),
+		}
+
+		limit, ok := parseLimit(q.Get("limit"))
+		if !ok {
+			writeError(w, r, errValidation("limit", ErrInvalidLimit))
+			return
+		}
+		opts.Limit = limit
+		if match, _ := regexp.MatchString(`^([A-F][+-]?)?$`, opts.Rank); !match {
+			writeError(w, r, errValidation("rank", ErrInvalidRank))
+			return
+		}
+		if opts.GoVersion != "" && !goVersionPattern.MatchString(opts.GoVersion) {
+			writeError(w, r, errValidation("goVersion", ErrInvalidGoVersion))
+			return
+		}
+
+		list, err := s.db.ListProjects(r.Context(), opts)
+		switch {
+		case err == database.ErrInvalidCursor:
+			writeError(w, r, errValidation("cursor", err))
+			return
+		case err != nil:
+			logrus.WithError(err).Error("Could not list projects")
+			writeError(w, r, errInternal())
+			return
+		}
+
+		res := projectListResponse{Projects: []projectItem{}, Cursor: list.Cursor}
+		for _, p := range list.Projects {
+			res.Projects = append(res.Projects, projectItem{
+				Repository:  p.Repository,
+				Branch:      p.Branch,
+				GoVersion:   p.GoVersion,
+				Rank:        p.Data.Score.Rank,
+				Score:       p.Data.Score.Value,
+				Stars:       p.Data.Metadata.Stars,
+				Description: p.Data.Metadata.Description,
+				Image:       p.Data.Metadata.Image,
+				ProcessedAt: p.ProcessedAt,
+			})
+		}
+		render.JSON(w, r, res)
+	}
 }
 
-//func (s *Server) badgeHandler(w http.ResponseWriter, r *http.Request) {
-//	ps := context.Get(r, "params").(httprouter.Params)
-//	lgr := context.Get(r, "lgr").(*log.Entry)
-//
-//	repo := context.Get(r, "repository").(string)
-//	branch := ""
-//
-//	if !s.config.RepositoryLoader.IsCached(repo, branch) {
-//		badge.WriteError(w, "")
-//		return
-//	}
-//
-//	rp, err := s.config.RepositoryLoader.Load(repo, branch)
-//	if err != nil {
-//		lgr.Error(err)
-//		badge.WriteError(w, "")
-//		return
-//	}
-//
-//	var title string
-//	var value string
-//	var score = rp.GetScore().Value
-//
-//	pr := rp.GetProjectRunner()
-//	th := pr.Thirdparties.Data
-//	cs := pr.CodeStats.Data
-//
-//	switch tp := ps.ByName("type"); tp {
-//	case "cov":
-//		title = "coverage"
-//		value = fmt.Sprintf("%.2f%%", pr.GetMeanCodeCov())
-//	case "duration":
-//		title = "tests duration"
-//		value = fmt.Sprintf("%.2fs", pr.GetMeanTestDuration())
-//	case "tests":
-//		title = "tests"
-//		value = fmt.Sprintf("%d", cs["test"])
-//	case "thirdparties":
-//		title = "3rd parties"
-//		value = fmt.Sprintf("%d", len(th))
-//	case "loc":
-//		title = "LOC"
-//		value = fmt.Sprintf("%d", cs["loc"])
-//	case "rank":
-//		fallthrough
-//	default:
-//		value = rp.GetRank()
-//	}
-//
-//	badge.Write(w, title, value, score)
-//}
-//
-//func (s *Server) fileHandler(w http.ResponseWriter, r *http.Request) {
-//	owner := context.Get(r, "owner").(string)
-//	project := context.Get(r, "project").(string)
-//	path := context.Get(r, "path").(string)
-//	content, err := s.config.RepositoryHost.GetFileContent(owner, project, path)
-//	send(w, r, content, err)
-//}
+// fileHandler returns the content of a file from the latest analysis of the
+// repository, annotated with the linter messages and the coverage of each line.
+// The path is made of the repository followed by the file path,
+// e.g. github.com/foo/bar/baz/qux.go.
+func (s Server) fileHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(chi.URLParam(r, "*"), "/"), "/")
+	if len(parts) < 4 {
+		writeError(w, r, errValidation("path", ErrFilePath))
+		return
+	}
+	repository, owner, name := strings.Join(parts[:3], "/"), parts[1], parts[2]
+	path := strings.Join(parts[3:], "/")
+
+	q := r.URL.Query()
+	branch := q.Get("branch")
+	if branch == "" {
+		branch = defaultBranch
+	}
+
+	p, err := s.findProject(r.Context(), repository, branch, q.Get("goVersion"))
+	switch {
+	case err == database.ErrNotFound:
+		writeError(w, r, errNotFound(ErrProjectNotFound))
+		return
+	case err != nil:
+		logrus.WithError(err).Errorf("Could not load project %s", repository)
+		writeError(w, r, errInternal())
+		return
+	}
+
+	// The branch may have moved since, the annotations match the commit analyzed
+	ref := p.Commit
+	if ref == "" {
+		ref = branch
+	}
+	content, err := s.host.GetFileContent(r.Context(), owner, name, path, ref)
+	switch {
+	case err == github.ErrNotFound:
+		writeError(w, r, errNotFound(ErrFileNotFound))
+		return
+	case err != nil:
+		logrus.WithError(err).Errorf("Could not load file %s of %s", path, repository)
+		writeError(w, r, newErrResponse(http.StatusBadGateway, codeHostUnavailable, ErrHostUnavailable, nil))
+		return
+	}
+
+	render.JSON(w, r, exago.AnnotatedFile{
+		Path:    path,
+		Content: content,
+		Lines:   p.Data.Results.AnnotateFile(path),
+	})
+}
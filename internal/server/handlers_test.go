@@ -0,0 +1,580 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/badge"
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/internal/github"
+	exago "github.com/jgautheron/exago/pkg"
+)
+
+// fakeHost serves files from memory, keyed by owner/repository/path@ref,
+// repositories keyed by owner/repository and branches keyed by owner/repository@branch.
+type fakeHost struct {
+	files    map[string]string
+	repos    map[string]map[string]interface{}
+	branches map[string]bool
+}
+
+func (h fakeHost) GetFileContent(ctx context.Context, owner, repository, path, ref string) (string, error) {
+	content, ok := h.files[owner+"/"+repository+"/"+path+"@"+ref]
+	if !ok {
+		return "", github.ErrNotFound
+	}
+	return content, nil
+}
+
+func (h fakeHost) Get(ctx context.Context, owner, repository string) (map[string]interface{}, error) {
+	data, ok := h.repos[owner+"/"+repository]
+	if !ok {
+		return nil, github.ErrNotFound
+	}
+	return data, nil
+}
+
+func (h fakeHost) HasBranch(ctx context.Context, owner, repository, branch string) (bool, error) {
+	return h.branches[owner+"/"+repository+"@"+branch], nil
+}
+
+func (h fakeHost) BranchHead(ctx context.Context, owner, repository, branch string) (string, error) {
+	if !h.branches[owner+"/"+repository+"@"+branch] {
+		return "", github.ErrNotFound
+	}
+	return "0123456789abcdef0123456789abcdef01234567", nil
+}
+
+func TestListProjects(t *testing.T) {
+	db := memory.New()
+	for i, repo := range []string{"github.com/foo/bar", "github.com/foo/baz", "github.com/foo/qux"} {
+		p := &database.Project{
+			Repository:  repo,
+			Branch:      "master",
+			GoVersion:   "1.13",
+			ProcessedAt: time.Now(),
+		}
+		p.Data.Score.Value = float64(90 - i*10)
+		p.Data.Score.Rank = "A"
+		db.SaveProject(context.Background(), p)
+	}
+	s := &Server{db: db}
+
+	var tests = []struct {
+		url        string
+		statusCode int
+		count      int
+	}{
+		{"/projects/top", http.StatusOK, 3},
+		{"/projects/top?limit=2", http.StatusOK, 2},
+		{"/projects/recent?rank=B", http.StatusOK, 0},
+		{"/projects/popular?goVersion=1.13", http.StatusOK, 3},
+		{"/projects/top?limit=0", http.StatusBadRequest, 0},
+		{"/projects/top?rank=Z", http.StatusBadRequest, 0},
+		{"/projects/top?cursor=foo", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.url, w.Code, tt.statusCode)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var res projectListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Projects) != tt.count {
+			t.Errorf("%s: got %d projects, expected %d", tt.url, len(res.Projects), tt.count)
+		}
+	}
+}
+
+func TestBadgeHandler(t *testing.T) {
+	db := memory.New()
+	p := &database.Project{
+		Repository:  "github.com/foo/bar",
+		Branch:      "master",
+		GoVersion:   "1.13",
+		ProcessedAt: time.Now(),
+	}
+	p.Data.Score.Value = 92
+	p.Data.Score.Rank = "A"
+	p.Data.Results.Coverage.Data.Coverage = 81.5
+	p.Data.Results.CodeStats.Data = map[string]int{"loc": 1200, "test": 42}
+	db.SaveProject(context.Background(), p)
+	s := &Server{db: db}
+
+	var tests = []struct {
+		url      string
+		contains []string
+	}{
+		{"/badge/rank/github.com/foo/bar", []string{">exago<", ">A<", "#4c1"}},
+		{"/badge/cov/github.com/foo/bar?goVersion=1.13", []string{">coverage<", ">81.50%<"}},
+		{"/badge/tests/github.com/foo/bar?style=plastic", []string{">42<", `height="18"`}},
+		{"/badge/loc/github.com/foo/bar?branch=master", []string{">LOC<", ">1200<"}},
+		{"/badge/loc/github.com/foo/bar?branch=develop", []string{">LOC<", ">unknown<"}},
+		{"/badge/rank/github.com/foo/unknown", []string{">unknown<"}},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", tt.url, w.Code)
+			continue
+		}
+		for _, c := range tt.contains {
+			if !strings.Contains(w.Body.String(), c) {
+				t.Errorf("%s: the badge should contain %s", tt.url, c)
+			}
+		}
+	}
+}
+
+func TestShieldsBadgeHandler(t *testing.T) {
+	db := memory.New()
+	p := &database.Project{
+		Repository:  "github.com/foo/bar",
+		Branch:      "master",
+		GoVersion:   "1.13",
+		ProcessedAt: time.Now(),
+	}
+	p.Data.Score.Value = 73
+	p.Data.Results.ThirdParties.Data = []string{"github.com/pkg/errors"}
+	db.SaveProject(context.Background(), p)
+	s := &Server{db: db}
+
+	var tests = []struct {
+		url      string
+		expected badge.Endpoint
+	}{
+		{"/badge/rank/github.com/foo/bar?format=shields", badge.Endpoint{SchemaVersion: 1, Label: "exago", Message: "C", Color: "yellowgreen"}},
+		{"/badge/thirdparties/github.com/foo/bar?format=shields", badge.Endpoint{SchemaVersion: 1, Label: "3rd parties", Message: "1", Color: "yellowgreen"}},
+		{"/badge/cov/github.com/foo/unknown?format=shields", badge.Endpoint{SchemaVersion: 1, Label: "coverage", Message: "unknown", Color: "lightgrey", IsError: true}},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+
+		var res badge.Endpoint
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res != tt.expected {
+			t.Errorf("%s: got %#v, expected %#v", tt.url, res, tt.expected)
+		}
+	}
+}
+
+func TestFileHandler(t *testing.T) {
+	db := memory.New()
+	p := &database.Project{
+		Repository:  "github.com/foo/bar",
+		Branch:      "master",
+		GoVersion:   "1.13",
+		ProcessedAt: time.Now(),
+	}
+	p.Data.Results.Linters.Data = exago.LinterResults{
+		"baz/qux.go": {{Linter: "golint", Messages: []exago.LinterMessage{{Row: 2, Message: "exported func"}}}},
+	}
+	db.SaveProject(context.Background(), p)
+	// The file is fetched at the commit analyzed rather than at the branch head
+	analyzed := *p
+	analyzed.Branch, analyzed.Commit = "develop", "a1b2c3d"
+	db.SaveProject(context.Background(), &analyzed)
+	s := &Server{db: db, host: fakeHost{files: map[string]string{
+		"foo/bar/baz/qux.go@master":  "package baz\nfunc Qux() {}\n",
+		"foo/bar/baz/qux.go@a1b2c3d": "package baz\nfunc Qux() {}\n",
+		"foo/bar/baz/qux.go@develop": "package qux\n",
+	}}}
+
+	var tests = []struct {
+		url        string
+		statusCode int
+	}{
+		{"/file/github.com/foo/bar/baz/qux.go", http.StatusOK},
+		{"/file/github.com/foo/bar/baz/qux.go?branch=develop", http.StatusOK},
+		{"/file/github.com/foo/bar/baz/missing.go", http.StatusNotFound},
+		{"/file/github.com/foo/unknown/baz/qux.go", http.StatusNotFound},
+		{"/file/github.com/foo/bar", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.url, w.Code, tt.statusCode)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var res exago.AnnotatedFile
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Path != "baz/qux.go" || !strings.HasPrefix(res.Content, "package baz") {
+			t.Errorf("Wrong file %#v", res)
+		}
+		if len(res.Lines) != 1 || res.Lines[0].Line != 2 || res.Lines[0].Messages[0].Linter != "golint" {
+			t.Errorf("Wrong annotations %#v", res.Lines)
+		}
+	}
+}
+
+func TestJobStatus(t *testing.T) {
+	db := memory.New()
+	// The repositories named after an action are not mistaken for it
+	for _, repository := range []string{"github.com/foo/bar", "github.com/foo/history"} {
+		job := database.NewJob(repository, "master", "1.13")
+		job.SetState(database.JobFailed, map[string]string{"test": "exit status 2"})
+		db.SaveJob(context.Background(), job)
+	}
+	s := &Server{db: db}
+
+	var tests = []struct {
+		url        string
+		statusCode int
+	}{
+		{"/project/1.13/master/status/github.com/foo/bar", http.StatusOK},
+		{"/project/1.13/master/status/github.com/foo/history", http.StatusOK},
+		{"/project/1.12/master/status/github.com/foo/bar", http.StatusNotFound},
+		{"/project/1.13/master/status/github.com/foo/status", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.url, w.Code, tt.statusCode)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var res database.Job
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.State != database.JobFailed || res.Errors["test"] != "exit status 2" {
+			t.Errorf("Wrong job %#v", res)
+		}
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	var tests = []struct {
+		method     string
+		url        string
+		apiKey     string
+		state      database.JobState
+		statusCode int
+		code       string
+	}{
+		{"POST", "/project/1.13/master/cancel/github.com/foo/bar", "secret", "", http.StatusNotFound, codeNotFound},
+		{"POST", "/project/1.13/master/cancel/github.com/foo/bar", "secret", database.JobQueued, http.StatusOK, ""},
+		{"POST", "/project/1.13/master/cancel/github.com/foo/bar", "secret", database.JobRunning, http.StatusOK, ""},
+		{"POST", "/project/1.13/master/cancel/github.com/foo/bar", "secret", database.JobScored, http.StatusConflict, codeNotPending},
+		{"POST", "/project/1.13/master/cancel/github.com/foo/bar", "secret", database.JobCancelled, http.StatusConflict, codeNotPending},
+		{"POST", "/project/1.13/master/cancel/github.com/foo/bar", "", database.JobQueued, http.StatusUnauthorized, codeAPIKeyRequired},
+		{"POST", "/project/1.13/master/cancel/github.com/foo/bar", "unknown", database.JobQueued, http.StatusUnauthorized, codeAPIKeyRequired},
+		// Submits cancel/github.com/foo/bar, which is not a GitHub path
+		{"GET", "/project/1.13/master/cancel/github.com/foo/bar", "secret", database.JobQueued, http.StatusBadRequest, codeValidationFailed},
+		{"POST", "/project/1.13/master/github.com/foo/bar", "secret", database.JobQueued, http.StatusMethodNotAllowed, codeMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		db := memory.New()
+		if tt.state != "" {
+			job := database.NewJob("github.com/foo/bar", "master", "1.13")
+			job.SetState(tt.state, nil)
+			db.SaveJob(context.Background(), job)
+		}
+		s := &Server{db: db, apiKeys: map[string]bool{"secret": true}}
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tt.method, tt.url, nil)
+		req.Header.Set(apiKeyHeader, tt.apiKey)
+		s.routes().ServeHTTP(w, req)
+		if w.Code != tt.statusCode {
+			t.Errorf("%s %s (%s): got status %d, expected %d", tt.method, tt.url, tt.state, w.Code, tt.statusCode)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			var res ErrResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Error.Code != tt.code {
+				t.Errorf("%s %s (%s): wrong error %#v", tt.method, tt.url, tt.state, res.Error)
+			}
+			continue
+		}
+
+		if job, err := db.GetJob(context.Background(), "github.com/foo/bar", "master", "1.13"); err != nil || job.State != database.JobCancelled {
+			t.Errorf("%s (%s): the job should be cancelled, got %#v (%v)", tt.url, tt.state, job, err)
+		}
+	}
+}
+
+func TestProgressEvents(t *testing.T) {
+	progress := eventpub.NewBroker()
+	db := memory.New()
+	job := database.NewJob("github.com/foo/bar", "master", "1.13")
+	job.SetState(database.JobRunning, nil)
+	db.SaveJob(context.Background(), job)
+	s := &Server{db: db, progress: progress}
+	ts := httptest.NewServer(s.routes())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/project/1.13/master/events/github.com/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Wrong content type %s", ct)
+	}
+
+	// The headers are flushed once subscribed, the events can be sent.
+	// The last runner finishes once the analysis is over
+	job.SetState(database.JobScored, nil)
+	db.SaveJob(context.Background(), job)
+	progress.RunnerProgress(&eventpub.RunnerProgressEvent{
+		Repository: "github.com/foo/bar",
+		Branch:     "master",
+		GoVersion:  "1.13",
+		Label:      "Go Test",
+		Status:     eventpub.ProgressFinished,
+		Error:      "FAIL",
+	})
+
+	// The stream ends after the final state
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(b), "\n")
+	if len(lines) != 7 || lines[0] != "event: finished" || !strings.Contains(lines[1], `"label":"Go Test"`) || !strings.Contains(lines[1], `"error":"FAIL"`) {
+		t.Errorf("Wrong event %v", lines)
+	}
+	if len(lines) == 7 && (lines[3] != "event: state" || !strings.Contains(lines[4], `"state":"scored"`)) {
+		t.Errorf("Wrong final state %v", lines)
+	}
+}
+
+func TestProgressEventsOver(t *testing.T) {
+	db := memory.New()
+	job := database.NewJob("github.com/foo/bar", "master", "1.13")
+	job.SetState(database.JobCancelled, nil)
+	db.SaveJob(context.Background(), job)
+	s := &Server{db: db, progress: eventpub.NewBroker()}
+
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest("GET", "/project/1.13/master/events/github.com/foo/bar", nil))
+	if body := w.Body.String(); !strings.HasPrefix(body, "event: state\n") || !strings.Contains(body, `"state":"cancelled"`) {
+		t.Errorf("Got %q, the stream of an analysis over should only hold its state", body)
+	}
+}
+
+func TestProjectHistory(t *testing.T) {
+	db := memory.New()
+	now := time.Now()
+	for i, commit := range []string{"a1b2c3", "d4e5f6"} {
+		p := &database.Project{
+			Repository:  "github.com/foo/bar",
+			Branch:      "master",
+			GoVersion:   "1.13",
+			Commit:      commit,
+			ProcessedAt: now.Add(time.Duration(i) * time.Hour),
+		}
+		p.Data.Score.Value = float64(60 + i*30)
+		p.Data.Results.Linters.Data = exago.LinterResults{
+			"baz/qux.go": {{Linter: "golint", Messages: make([]exago.LinterMessage, 3-i)}},
+		}
+		p.Data.Results.CodeStats.Data = map[string]int{"loc": 1000 + i*100}
+		// Only the date of the first commit is known
+		if i == 0 {
+			p.CommitDate = database.OptionalDate(now)
+		}
+		db.SaveProject(context.Background(), p)
+	}
+	s := &Server{db: db}
+
+	var tests = []struct {
+		url        string
+		statusCode int
+		commits    []string
+	}{
+		{"/project/1.13/master/history/github.com/foo/bar", http.StatusOK, []string{"d4e5f6", "a1b2c3"}},
+		{"/project/1.13/master/history/github.com/foo/bar?limit=1", http.StatusOK, []string{"d4e5f6"}},
+		{"/project/1.13/master/history/github.com/foo/bar?limit=0", http.StatusBadRequest, nil},
+		{"/project/1.13/develop/history/github.com/foo/bar", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.url, w.Code, tt.statusCode)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var res historyResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.History) != len(tt.commits) {
+			t.Errorf("%s: got %d results, expected %d", tt.url, len(res.History), len(tt.commits))
+			continue
+		}
+		for i, commit := range tt.commits {
+			if res.History[i].Commit != commit {
+				t.Errorf("%s: got commit %s at #%d, expected %s", tt.url, res.History[i].Commit, i, commit)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest("GET", "/project/1.13/master/history/github.com/foo/bar", nil))
+	if n := strings.Count(w.Body.String(), `"commitDate"`); n != 1 {
+		t.Errorf("Got %d commit dates, the unknown one should be omitted", n)
+	}
+	var res historyResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	expected := historyItem{Commit: "d4e5f6", Score: 90, Rank: "A-", LintMessages: 2, LOC: 1100}
+	got := res.History[0]
+	got.ProcessedAt = time.Time{}
+	if got != expected {
+		t.Errorf("Got %#v, expected %#v", got, expected)
+	}
+}
+
+func TestCompareHandler(t *testing.T) {
+	db := memory.New()
+	now := time.Now()
+	for i, r := range []struct {
+		branch, commit string
+		score          float64
+		thirdParties   []string
+	}{
+		{"master", "a1b2c3d4", 60, []string{"github.com/pkg/errors"}},
+		{"master", "e5f6a7b8", 70, []string{"github.com/pkg/errors"}},
+		{"feature", "c9d0e1f2", 85, []string{"github.com/pkg/errors", "github.com/go-chi/chi"}},
+	} {
+		p := &database.Project{
+			Repository:  "github.com/foo/bar",
+			Branch:      r.branch,
+			GoVersion:   "1.13",
+			Commit:      r.commit,
+			ProcessedAt: now.Add(time.Duration(i) * time.Hour),
+		}
+		p.Data.Score.Value = r.score
+		p.Data.Results.ThirdParties.Data = r.thirdParties
+		db.SaveProject(context.Background(), p)
+	}
+	s := &Server{db: db}
+
+	var tests = []struct {
+		url        string
+		statusCode int
+		delta      float64
+	}{
+		{"/compare/github.com/foo/bar?head=feature", http.StatusOK, 15},
+		{"/compare/github.com/foo/bar?base=master@a1b2c3d&head=feature", http.StatusOK, 25},
+		{"/compare/github.com/foo/bar?base=feature&head=master&goVersion=1.13", http.StatusOK, -15},
+		{"/compare/github.com/foo/bar?base=master@fffffff&head=feature", http.StatusNotFound, 0},
+		{"/compare/github.com/foo/bar?base=master@zz&head=feature", http.StatusBadRequest, 0},
+		{"/compare/github.com/foo/bar", http.StatusBadRequest, 0},
+		{"/compare/github.com/foo/bar?head=develop", http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.url, w.Code, tt.statusCode)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var res compareResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Diff.Score.Delta != tt.delta {
+			t.Errorf("%s: got delta %.2f, expected %.2f", tt.url, res.Diff.Score.Delta, tt.delta)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest("GET", "/compare/github.com/foo/bar?base=master@a1b2c3d&head=feature", nil))
+	var res compareResponse
+	json.Unmarshal(w.Body.Bytes(), &res)
+	if res.Base.Commit != "a1b2c3d4" || res.Head.Branch != "feature" {
+		t.Errorf("Wrong snapshots %#v %#v", res.Base, res.Head)
+	}
+	if added := res.Diff.ThirdParties.Added; len(added) != 1 || added[0] != "github.com/go-chi/chi" {
+		t.Errorf("Wrong third parties %#v", res.Diff.ThirdParties)
+	}
+}
+
+func TestProcessRepository(t *testing.T) {
+	db := memory.New()
+	queue := eventpub.NewLocal()
+	s := &Server{db: db, evp: queue, locks: newRequestLocks(), host: fakeHost{
+		repos:    map[string]map[string]interface{}{"foo/bar": {"html_url": "https://github.com/foo/bar", "languages": map[string]int{"Go": 1200}}},
+		branches: map[string]bool{"foo/bar@master": true},
+	}}
+
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest("GET", "/project/1.13/master/github.com/foo/bar", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status %d, expected %d", w.Code, http.StatusOK)
+	}
+	if job, err := db.GetJob(context.Background(), "github.com/foo/bar", "master", "1.13"); err != nil || job.State != database.JobQueued {
+		t.Errorf("The job should be queued, got %#v (%v)", job, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var ev eventpub.RepositoryAddedEvent
+	queue.ReceiveRepositoryEvents(ctx, func(msg *eventpub.Message) error {
+		json.Unmarshal(msg.Data, &ev)
+		cancel()
+		return nil
+	})
+	if ev.Repository != "github.com/foo/bar" || ev.Branch != "master" || ev.GoVersion != "1.13" {
+		t.Errorf("Wrong event published %#v", ev)
+	}
+
+	// The job is failed if the analysis cannot be queued
+	queue.Close()
+	w = httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest("GET", "/project/1.12/master/github.com/foo/bar", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Got status %d, expected %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if job, _ := db.GetJob(context.Background(), "github.com/foo/bar", "master", "1.12"); job == nil || job.State != database.JobFailed {
+		t.Errorf("The job should be failed, got %#v", job)
+	}
+}
@@ -1,120 +1,254 @@
 package server
 
-//import (
-//	"errors"
-//	"fmt"
-//	"net/http"
-//	"strings"
-//	"time"
-//
-//	log "github.com/sirupsen/logrus"
-//	"github.com/didip/tollbooth"
-//	"github.com/gorilla/context"
-//	. "github.com/jgautheron/exago/src/api/config"
-//	"github.com/jgautheron/exago/src/api/requestlock"
-//	"github.com/julienschmidt/httprouter"
-//)
-//
-//const (
-//	rateLimitCount = 20
-//)
-//
-//var (
-//	ErrTooManyCalls      = errors.New("Too many calls in a short period of time")
-//	ErrRateLimitExceeded = errors.New("Rate limit exceeded")
-//)
-//
-//func (s *Server) recoverHandler(next http.Handler) http.Handler {
-//	fn := func(w http.ResponseWriter, r *http.Request) {
-//		defer func() {
-//			if err := recover(); err != nil {
-//				log.Errorf("panic: %+v", err)
-//				http.Error(w, http.StatusText(500), 500)
-//			}
-//		}()
-//
-//		next.ServeHTTP(w, r)
-//	}
-//	return http.HandlerFunc(fn)
-//}
-//
-//func (s *Server) checkValidRepository(next http.Handler) http.Handler {
-//	fn := func(w http.ResponseWriter, r *http.Request) {
-//		ps := context.Get(r, "params").(httprouter.Params)
-//		repo := ps.ByName("repository")[1:]
-//
-//		data, err := s.config.RepositoryLoader.IsValid(repo)
-//		if err != nil {
-//			writeError(w, r, err)
-//			return
-//		}
-//
-//		HTMLURL := strings.Replace(data["html_url"].(string), "https://", "", 1)
-//		rp := strings.Split(HTMLURL, "/")
-//		context.Set(r, "provider", rp[0])
-//		context.Set(r, "owner", rp[1])
-//		context.Set(r, "project", rp[2])
-//		context.Set(r, "repository", HTMLURL)
-//
-//		rp = strings.Split(repo, "/")
-//		if len(rp) > 3 {
-//			context.Set(r, "path", strings.Join(rp[3:], "/"))
-//		}
-//
-//		next.ServeHTTP(w, r)
-//	}
-//	return http.HandlerFunc(fn)
-//}
-//
-//func (s *Server) requestLock(next http.Handler) http.Handler {
-//	fn := func(w http.ResponseWriter, r *http.Request) {
-//		ps := context.Get(r, "params").(httprouter.Params)
-//		rp := fmt.Sprintf("%s/%s/%s", ps.ByName("registry"), ps.ByName("owner"), ps.ByName("repository"))
-//
-//		if requestlock.Contains(rp, getIP(r.RemoteAddr)) {
-//			writeError(w, r, ErrTooManyCalls)
-//			return
-//		}
-//
-//		next.ServeHTTP(w, r)
-//	}
-//	return http.HandlerFunc(fn)
-//}
-//
-//func (s *Server) setLogger(next http.Handler) http.Handler {
-//	fn := func(w http.ResponseWriter, r *http.Request) {
-//		ps := context.Get(r, "params").(httprouter.Params)
-//
-//		lgr := logger.WithFields(log.Fields{
-//			"repository": ps.ByName("repository")[1:],
-//			"ip":         getIP(r.RemoteAddr),
-//		})
-//		context.Set(r, "lgr", lgr)
-//		next.ServeHTTP(w, r)
-//	}
-//	return http.HandlerFunc(fn)
-//}
-//
-//func (s *Server) rateLimit(next http.Handler) http.Handler {
-//	limiter := tollbooth.NewLimiter(rateLimitCount, time.Hour*4)
-//	limiter.Methods = []string{"GET"}
-//
-//	fn := func(w http.ResponseWriter, r *http.Request) {
-//		lgr := context.Get(r, "lgr").(*log.Entry)
-//
-//		if r.Header.Get("Origin") == Config.AllowOrigin {
-//			next.ServeHTTP(w, r)
-//			return
-//		}
-//
-//		httpErr := tollbooth.LimitByRequest(limiter, r)
-//		if httpErr != nil {
-//			w.Header().Add("Content-Type", limiter.MessageContentType)
-//			lgr.WithField("URL", r.URL.String()).Error("Rate limit exceeded")
-//			writeError(w, r, ErrRateLimitExceeded)
-//			return
-//		}
-//		next.ServeHTTP(w, r)
-//	}
-//	return http.HandlerFunc(fn)
-//}
+import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/github"
+	"github.com/jgautheron/exago/internal/ratelimit"
+	"github.com/sirupsen/logrus"
+)
+
+type contextKey string
+
+// repositoryKey holds the canonical path of the validated repository
+const repositoryKey contextKey = "repository"
+
+var goVersionPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+// apiKeyHeader carries the API key, which raises the rate limit and gives
+// access to the endpoints refused to anonymous clients
+const apiKeyHeader = "X-API-Key"
+
+// newAPIKeys returns the set of the configured API keys, the empty ones are ignored.
+func newAPIKeys(apiKeys []string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, k := range apiKeys {
+		if k != "" {
+			keys[k] = true
+		}
+	}
+	return keys
+}
+
+// limiters holds the rate limit applied to anonymous clients, keyed by IP,
+// and the one applied to the clients identified by an API key.
+type limiters struct {
+	client *ratelimit.Limiter
+	key    *ratelimit.Limiter
+	keys   map[string]bool
+}
+
+func newLimiters(count, keyCount int, window time.Duration, apiKeys []string) *limiters {
+	return &limiters{
+		client: ratelimit.New(count, window),
+		key:    ratelimit.New(keyCount, window),
+		keys:   newAPIKeys(apiKeys),
+	}
+}
+
+// forRequest returns the limiter and the key the request is accounted on.
+// Unknown API keys are ignored, the client is then limited by IP.
+func (l *limiters) forRequest(r *http.Request) (*ratelimit.Limiter, string) {
+	if k := r.Header.Get(apiKeyHeader); l.keys[k] {
+		return l.key, "key:" + k
+	}
+	// RealIP already replaced the remote address by the client one, without port
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return l.client, "ip:" + ip
+}
+
+// rateLimit refuses the requests of the clients that exceeded their quota.
+func (s Server) rateLimit(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if s.limiters == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limiter, key := s.limiters.forRequest(r)
+		ok, remaining, reset := limiter.Allow(key)
+		if limiter.Limit() > 0 {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.Limit()))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		}
+		if !ok {
+			logrus.WithField("client", key).Warn("Rate limit exceeded")
+			w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+			writeError(w, r, newErrResponse(http.StatusTooManyRequests, codeRateLimited, ErrRateLimitExceeded, nil))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// refundRateLimit gives back the call charged by rateLimit, for the requests
+// that cost the client nothing.
+func (s Server) refundRateLimit(w http.ResponseWriter, r *http.Request) {
+	if s.limiters == nil {
+		return
+	}
+	limiter, key := s.limiters.forRequest(r)
+	if remaining := limiter.Refund(key); limiter.Limit() > 0 {
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	}
+}
+
+// requireAPIKey refuses the requests that do not carry one of the configured
+// API keys, none is accepted if there is no key configured.
+func (s Server) requireAPIKey(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !s.apiKeys[r.Header.Get(apiKeyHeader)] {
+			writeError(w, r, newErrResponse(http.StatusUnauthorized, codeAPIKeyRequired, ErrAPIKeyRequired, nil))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// requestLocks serializes the submissions of a given project within the instance.
+type requestLocks struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func newRequestLocks() *requestLocks {
+	return &requestLocks{held: make(map[string]bool)}
+}
+
+func (l *requestLocks) tryLock(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[id] {
+		return false
+	}
+	l.held[id] = true
+	return true
+}
+
+func (l *requestLocks) unlock(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, id)
+}
+
+// requestLock refuses to enqueue a project again while its analysis is pending,
+// the pending job is sent back so that the client can follow it. The client is
+// not charged for it, it is the submission of another client more often than not.
+func (s Server) requestLock(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		repository := requestedRepository(r)
+		branch, goVersion := chi.URLParam(r, "branch"), chi.URLParam(r, "goVersion")
+		id := database.ProjectID(repository, branch, goVersion)
+
+		// Concurrent submissions would all see the project as idle
+		if s.locks != nil {
+			if !s.locks.tryLock(id) {
+				s.refundRateLimit(w, r)
+				writeError(w, r, newErrResponse(http.StatusConflict, codeAlreadyPending, ErrAlreadyPending, nil))
+				return
+			}
+			defer s.locks.unlock(id)
+		}
+
+		job, err := s.db.GetJob(r.Context(), repository, branch, goVersion)
+		switch {
+		case err == database.ErrNotFound:
+		case err != nil:
+			logrus.WithError(err).Errorf("Could not load job of %s", repository)
+			writeError(w, r, errInternal())
+			return
+		case job.Pending() && time.Since(job.UpdatedAt) < s.lockTimeout:
+			s.refundRateLimit(w, r)
+			writeError(w, r, newErrResponse(http.StatusConflict, codeAlreadyPending, ErrAlreadyPending, map[string]string{
+				"state":     string(job.State),
+				"updatedAt": job.UpdatedAt.Format(time.RFC3339),
+			}))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// checkValidRepository makes sure that the repository exists, contains Go code
+// and has the requested branch before it gets enqueued.
+// The canonical path of the repository, as spelled by GitHub, is passed on in the context.
+func (s Server) checkValidRepository(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if !goVersionPattern.MatchString(chi.URLParam(r, "goVersion")) {
+			writeError(w, r, errValidation("goVersion", ErrInvalidGoVersion))
+			return
+		}
+
+		repository := projectPath(r)
+		owner, name, ok := github.ParseRepository(repository)
+		if !ok {
+			writeError(w, r, errValidation("repository", ErrRepositoryPath))
+			return
+		}
+
+		data, err := s.host.Get(r.Context(), owner, name)
+		switch {
+		case err == github.ErrNotFound:
+			writeError(w, r, newErrResponse(http.StatusNotFound, codeRepositoryNotFound, ErrRepositoryNotFound, nil))
+			return
+		case err != nil:
+			logrus.WithError(err).Errorf("Could not load repository %s", repository)
+			writeError(w, r, newErrResponse(http.StatusBadGateway, codeHostUnavailable, ErrHostUnavailable, nil))
+			return
+		}
+
+		if languages, _ := data["languages"].(map[string]int); languages["Go"] == 0 {
+			writeError(w, r, newErrResponse(http.StatusUnprocessableEntity, codeInvalidRepository, ErrInvalidRepository, nil))
+			return
+		}
+
+		// Renamed repositories are redirected, the canonical path may differ from the owner/name requested
+		htmlURL, _ := data["html_url"].(string)
+		canonical := strings.TrimPrefix(strings.TrimPrefix(htmlURL, "https://"), "http://")
+		if owner, name, ok = github.ParseRepository(canonical); !ok {
+			canonical = repository
+		}
+
+		exists, err := s.host.HasBranch(r.Context(), owner, name, chi.URLParam(r, "branch"))
+		switch {
+		case err != nil:
+			logrus.WithError(err).Errorf("Could not load the branches of %s", repository)
+			writeError(w, r, newErrResponse(http.StatusBadGateway, codeHostUnavailable, ErrHostUnavailable, nil))
+			return
+		case !exists:
+			writeError(w, r, newErrResponse(http.StatusNotFound, codeBranchNotFound, ErrBranchNotFound, nil))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), repositoryKey, canonical)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// requestedRepository returns the canonical repository path if it was validated,
+// the path found in the URL otherwise.
+func requestedRepository(r *http.Request) string {
+	if repository, ok := r.Context().Value(repositoryKey).(string); ok {
+		return repository
+	}
+	repository := projectPath(r)
+	return repository
+}
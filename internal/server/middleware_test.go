@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/jgautheron/exago/internal/eventpub"
+)
+
+func TestRateLimit(t *testing.T) {
+	s := &Server{limiters: newLimiters(1, 2, time.Hour, []string{"secret"})}
+	h := s.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var tests = []struct {
+		ip         string
+		apiKey     string
+		statusCode int
+	}{
+		{"1.2.3.4:1234", "", http.StatusOK},
+		{"1.2.3.4:5678", "", http.StatusTooManyRequests},
+		{"5.6.7.8:1234", "", http.StatusOK},
+		{"1.2.3.4:1234", "unknown", http.StatusTooManyRequests},
+		{"1.2.3.4:1234", "secret", http.StatusOK},
+		{"5.6.7.8:1234", "secret", http.StatusOK},
+		{"1.2.3.4:1234", "secret", http.StatusTooManyRequests},
+	}
+
+	for i, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.ip
+		if tt.apiKey != "" {
+			r.Header.Set(apiKeyHeader, tt.apiKey)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.statusCode {
+			t.Errorf("Request #%d: got status %d, expected %d", i, w.Code, tt.statusCode)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("Request #%d: Retry-After should be set", i)
+		}
+	}
+}
+
+func TestRequestLock(t *testing.T) {
+	db := memory.New()
+	db.SaveJob(context.Background(), database.NewJob("github.com/foo/pending", "master", "1.13"))
+	job := database.NewJob("github.com/foo/lost", "master", "1.13")
+	job.UpdatedAt = time.Now().Add(-time.Hour)
+	db.SaveJob(context.Background(), job)
+	job = database.NewJob("github.com/foo/scored", "master", "1.13")
+	job.SetState(database.JobScored, nil)
+	db.SaveJob(context.Background(), job)
+
+	s := &Server{db: db, locks: newRequestLocks(), lockTimeout: 30 * time.Minute}
+	r := chi.NewRouter()
+	r.Get("/project/{goVersion}/{branch}/*", s.requestLock(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP)
+
+	var tests = []struct {
+		url        string
+		statusCode int
+	}{
+		{"/project/1.13/master/github.com/foo/pending", http.StatusConflict},
+		{"/project/1.12/master/github.com/foo/pending", http.StatusOK},
+		{"/project/1.13/master/github.com/foo/lost", http.StatusOK},
+		{"/project/1.13/master/github.com/foo/scored", http.StatusOK},
+		{"/project/1.13/master/github.com/foo/unknown", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.url, w.Code, tt.statusCode)
+		}
+	}
+
+	// A submission in progress locks the project as well
+	s.locks.tryLock(database.ProjectID("github.com/foo/unknown", "master", "1.13"))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/project/1.13/master/github.com/foo/unknown", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Concurrent submission: got status %d, expected %d", w.Code, http.StatusConflict)
+	}
+}
+
+func TestCheckValidRepository(t *testing.T) {
+	s := &Server{host: fakeHost{
+		repos: map[string]map[string]interface{}{
+			"foo/bar":    {"html_url": "https://github.com/Foo/Bar", "languages": map[string]int{"Go": 1200}},
+			"Foo/Bar":    {"html_url": "https://github.com/Foo/Bar", "languages": map[string]int{"Go": 1200}},
+			"foo/python": {"html_url": "https://github.com/foo/python", "languages": map[string]int{"Python": 800}},
+		},
+		branches: map[string]bool{"Foo/Bar@master": true},
+	}}
+
+	var canonical string
+	r := chi.NewRouter()
+	r.Get("/project/{goVersion}/{branch}/*", s.checkValidRepository(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canonical = requestedRepository(r)
+	})).ServeHTTP)
+
+	var tests = []struct {
+		url        string
+		statusCode int
+		code       string
+	}{
+		{"/project/1.13/master/github.com/foo/bar", http.StatusOK, ""},
+		{"/project/1.13/master/GitHub.com/Foo/Bar", http.StatusOK, ""},
+		{"/project/1.13/develop/github.com/foo/bar", http.StatusNotFound, "branch_not_found"},
+		{"/project/1.13/master/github.com/foo/unknown", http.StatusNotFound, "repository_not_found"},
+		{"/project/1.13/master/github.com/foo/python", http.StatusUnprocessableEntity, "invalid_repository"},
+		{"/project/1.13/master/gitlab.com/foo/bar", http.StatusBadRequest, "validation_failed"},
+		{"/project/1.13/master/github.com/foo/bar/baz", http.StatusBadRequest, "validation_failed"},
+		{"/project/latest/master/github.com/foo/bar", http.StatusBadRequest, "validation_failed"},
+	}
+
+	for _, tt := range tests {
+		canonical = ""
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.url, w.Code, tt.statusCode)
+			continue
+		}
+		if w.Code == http.StatusOK {
+			if canonical != "github.com/Foo/Bar" {
+				t.Errorf("%s: got repository %s, expected github.com/Foo/Bar", tt.url, canonical)
+			}
+			continue
+		}
+
+		var res ErrResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Error.Code != tt.code {
+			t.Errorf("%s: got error %s, expected %s", tt.url, res.Error.Code, tt.code)
+		}
+	}
+}
+
+func TestRateLimitPending(t *testing.T) {
+	db := memory.New()
+	db.SaveJob(context.Background(), database.NewJob("github.com/foo/bar", "master", "1.11"))
+	s := &Server{
+		db:          db,
+		evp:         eventpub.NewLocal(),
+		limiters:    newLimiters(3, 3, time.Hour, nil),
+		locks:       newRequestLocks(),
+		lockTimeout: 30 * time.Minute,
+		host: fakeHost{
+			repos:    map[string]map[string]interface{}{"foo/bar": {"html_url": "https://github.com/foo/bar", "languages": map[string]int{"Go": 1200}}},
+			branches: map[string]bool{"foo/bar@master": true},
+		},
+	}
+
+	var tests = []struct {
+		url        string
+		statusCode int
+	}{
+		// The invalid submissions are charged, those of the pending projects are not
+		{"/project/1.13/master/github.com/foo/unknown", http.StatusNotFound},
+		{"/project/1.13/develop/github.com/foo/bar", http.StatusNotFound},
+		{"/project/1.11/master/github.com/foo/bar", http.StatusConflict},
+		{"/project/1.13/master/github.com/foo/bar", http.StatusOK},
+		{"/project/1.12/master/github.com/foo/bar", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tt.url, nil)
+		r.RemoteAddr = "1.2.3.4:1234"
+		s.routes().ServeHTTP(w, r)
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.url, w.Code, tt.statusCode)
+		}
+		if w.Code == http.StatusConflict && w.Header().Get("X-RateLimit-Remaining") != "1" {
+			t.Errorf("%s: got %s calls left, the pending project should be refunded", tt.url, w.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+}
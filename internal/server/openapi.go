@@ -0,0 +1,499 @@
+package server
+
+import "net/http"
+
+// openAPISpec documents the API endpoints and the error envelope,
+// it must be kept in sync with the routes and the error codes.
+const openAPISpec = `{
+	"openapi": "3.0.3",
+	"info": {
+		"title": "Exago API",
+		"description": "Code quality analysis of Go repositories.",
+		"version": "1.0.0"
+	},
+	"paths": {
+		"/project/{goVersion}/{branch}/{repository}": {
+			"get": {
+				"summary": "Request the analysis of a repository",
+				"description": "The repository must exist on GitHub, contain Go code and have the requested branch. Submissions are rate limited per client, or per API key when the X-API-Key header is set. The submissions of a project already pending don't count against the limit.",
+				"parameters": [
+					{"$ref": "#/components/parameters/goVersion"},
+					{"$ref": "#/components/parameters/branch"},
+					{"$ref": "#/components/parameters/repository"},
+					{"name": "X-API-Key", "in": "header", "required": false, "schema": {"type": "string"}}
+				],
+				"responses": {
+					"200": {"description": "The analysis is queued", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+					"400": {"$ref": "#/components/responses/Error"},
+					"404": {"$ref": "#/components/responses/Error"},
+					"409": {"$ref": "#/components/responses/Error"},
+					"422": {"$ref": "#/components/responses/Error"},
+					"429": {"$ref": "#/components/responses/Error"},
+					"500": {"$ref": "#/components/responses/Error"},
+					"502": {"$ref": "#/components/responses/Error"},
+					"503": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/project/{goVersion}/{branch}/status/{repository}": {
+			"get": {
+				"summary": "State of the latest analysis of a repository",
+				"parameters": [
+					{"$ref": "#/components/parameters/goVersion"},
+					{"$ref": "#/components/parameters/branch"},
+					{"$ref": "#/components/parameters/repository"}
+				],
+				"responses": {
+					"200": {"description": "The latest job", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+					"404": {"$ref": "#/components/responses/Error"},
+					"500": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/project/{goVersion}/{branch}/events/{repository}": {
+			"get": {
+				"summary": "Progress of the analysis as Server-Sent Events",
+				"description": "An event named after the status (started, finished) is sent each time a runner starts or finishes. Once the analysis is over, a state event holding the Job is sent and the stream ends.",
+				"parameters": [
+					{"$ref": "#/components/parameters/goVersion"},
+					{"$ref": "#/components/parameters/branch"},
+					{"$ref": "#/components/parameters/repository"}
+				],
+				"responses": {
+					"200": {"description": "The event stream", "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/RunnerProgress"}}}}
+				}
+			}
+		},
+		"/project/{goVersion}/{branch}/cancel/{repository}": {
+			"post": {
+				"summary": "Cancellation of the queued or running analysis of a repository",
+				"description": "A queued analysis is skipped, a running one is stopped and its commands killed once the consumer notices, within CANCEL_POLL_INTERVAL. No results are saved. Reserved to the clients sending one of the API keys in the X-API-Key header, and rate limited per key.",
+				"parameters": [
+					{"$ref": "#/components/parameters/goVersion"},
+					{"$ref": "#/components/parameters/branch"},
+					{"$ref": "#/components/parameters/repository"}
+				],
+				"responses": {
+					"200": {"description": "The cancelled job", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Job"}}}},
+					"401": {"$ref": "#/components/responses/Error"},
+					"404": {"$ref": "#/components/responses/Error"},
+					"409": {"$ref": "#/components/responses/Error"},
+					"429": {"$ref": "#/components/responses/Error"},
+					"500": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/project/{goVersion}/{branch}/history/{repository}": {
+			"get": {
+				"summary": "Score and main KPIs of each analyzed commit of a repository, the most recent first",
+				"parameters": [
+					{"$ref": "#/components/parameters/goVersion"},
+					{"$ref": "#/components/parameters/branch"},
+					{"$ref": "#/components/parameters/repository"},
+					{"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 20}}
+				],
+				"responses": {
+					"200": {"description": "The timeline", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/History"}}}},
+					"400": {"$ref": "#/components/responses/Error"},
+					"404": {"$ref": "#/components/responses/Error"},
+					"500": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/projects/{order}": {
+			"get": {
+				"summary": "List the analyzed projects",
+				"parameters": [
+					{"name": "order", "in": "path", "required": true, "schema": {"type": "string", "enum": ["recent", "top", "popular"]}},
+					{"name": "limit", "in": "query", "schema": {"type": "integer", "minimum": 1, "maximum": 100, "default": 20}},
+					{"name": "rank", "in": "query", "schema": {"type": "string", "pattern": "^[A-F][+-]?$"}},
+					{"name": "goVersion", "in": "query", "schema": {"type": "string"}},
+					{"name": "cursor", "in": "query", "description": "Cursor of the previous page", "schema": {"type": "string"}}
+				],
+				"responses": {
+					"200": {"description": "A page of projects", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ProjectList"}}}},
+					"400": {"$ref": "#/components/responses/Error"},
+					"500": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/file/{repository}/{path}": {
+			"get": {
+				"summary": "File content annotated with the linter messages and the coverage",
+				"parameters": [
+					{"$ref": "#/components/parameters/repository"},
+					{"name": "path", "in": "path", "required": true, "description": "Path of the file in the repository", "schema": {"type": "string"}},
+					{"$ref": "#/components/parameters/branchQuery"},
+					{"$ref": "#/components/parameters/goVersionQuery"}
+				],
+				"responses": {
+					"200": {"description": "The annotated file, as of the commit analyzed", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/AnnotatedFile"}}}},
+					"400": {"$ref": "#/components/responses/Error"},
+					"404": {"$ref": "#/components/responses/Error"},
+					"500": {"$ref": "#/components/responses/Error"},
+					"502": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/compare/{repository}": {
+			"get": {
+				"summary": "Differences between two analyses of a repository",
+				"description": "The references are a branch name, optionally followed by @ and a commit to pick a past analysis of the branch, e.g. master@a1b2c3d.",
+				"parameters": [
+					{"$ref": "#/components/parameters/repository"},
+					{"name": "base", "in": "query", "schema": {"type": "string", "default": "master"}},
+					{"name": "head", "in": "query", "required": true, "schema": {"type": "string"}},
+					{"$ref": "#/components/parameters/goVersionQuery"}
+				],
+				"responses": {
+					"200": {"description": "The differences", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Comparison"}}}},
+					"400": {"$ref": "#/components/responses/Error"},
+					"404": {"$ref": "#/components/responses/Error"},
+					"500": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/hooks/github": {
+			"post": {
+				"summary": "GitHub webhook receiver",
+				"description": "Requests the analysis of the branches pushed to and of the head branch of the opened or synchronized pull requests. The payload must be signed with the shared secret, successive pushes to a branch are debounced. Disabled if no secret is configured.",
+				"parameters": [
+					{"name": "X-GitHub-Event", "in": "header", "required": true, "schema": {"type": "string", "enum": ["push", "pull_request", "ping"]}},
+					{"name": "X-Hub-Signature-256", "in": "header", "required": true, "schema": {"type": "string"}}
+				],
+				"requestBody": {"required": true, "content": {"application/json": {"schema": {"type": "object"}}}},
+				"responses": {
+					"202": {"description": "The analysis will be requested", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/RepositoryAdded"}}}},
+					"204": {"description": "The event does not require an analysis"},
+					"400": {"$ref": "#/components/responses/Error"},
+					"401": {"$ref": "#/components/responses/Error"},
+					"404": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/upload": {
+			"post": {
+				"summary": "Analysis of a module archive",
+				"description": "Analyzes the gzipped tarball of a module, e.g. made with git archive, in an isolated workspace and sends back the results once scored. The go.mod must be at the root of the archive or in its single top directory, and declare a valid module path. Nothing is saved, the module does not need to be published. Disabled unless the uploads are enabled, and reserved to the clients sending one of the API keys in the X-API-Key header.",
+				"requestBody": {"required": true, "content": {"application/gzip": {"schema": {"type": "string", "format": "binary"}}}},
+				"responses": {
+					"200": {"description": "The results", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/UploadResult"}}}},
+					"400": {"$ref": "#/components/responses/Error"},
+					"401": {"$ref": "#/components/responses/Error"},
+					"404": {"$ref": "#/components/responses/Error"},
+					"413": {"$ref": "#/components/responses/Error"},
+					"422": {"$ref": "#/components/responses/Error"},
+					"429": {"$ref": "#/components/responses/Error"},
+					"500": {"$ref": "#/components/responses/Error"},
+					"503": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/badge/{type}/{repository}": {
+			"get": {
+				"summary": "Badge of the latest analysis",
+				"description": "An unknown badge is sent for repositories that were never analyzed.",
+				"parameters": [
+					{"name": "type", "in": "path", "required": true, "schema": {"type": "string", "enum": ["rank", "cov", "duration", "tests", "thirdparties", "loc"]}},
+					{"$ref": "#/components/parameters/repository"},
+					{"$ref": "#/components/parameters/branchQuery"},
+					{"$ref": "#/components/parameters/goVersionQuery"},
+					{"name": "style", "in": "query", "schema": {"type": "string", "enum": ["flat", "plastic"], "default": "flat"}},
+					{"name": "format", "in": "query", "description": "shields for the shields.io endpoint JSON", "schema": {"type": "string", "enum": ["shields"]}}
+				],
+				"responses": {
+					"200": {
+						"description": "The badge",
+						"content": {
+							"image/svg+xml": {"schema": {"type": "string"}},
+							"application/json": {"schema": {"$ref": "#/components/schemas/ShieldsEndpoint"}}
+						}
+					}
+				}
+			}
+		},
+		"/ready": {
+			"get": {
+				"summary": "Readiness of the instance",
+				"description": "Checks the database and the event publisher, the details of the error hold the outcome of each check.",
+				"responses": {
+					"200": {
+						"description": "The instance is ready",
+						"content": {"application/json": {"schema": {"type": "object", "properties": {"checks": {"type": "object", "additionalProperties": {"type": "string"}}}}}}
+					},
+					"503": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/openapi.json": {
+			"get": {
+				"summary": "This specification",
+				"responses": {"200": {"description": "The OpenAPI specification", "content": {"application/json": {}}}}
+			}
+		}
+	},
+	"components": {
+		"parameters": {
+			"goVersion": {"name": "goVersion", "in": "path", "required": true, "schema": {"type": "string", "example": "1.13"}},
+			"branch": {"name": "branch", "in": "path", "required": true, "schema": {"type": "string", "example": "master"}},
+			"repository": {"name": "repository", "in": "path", "required": true, "description": "Repository path, slashes included", "schema": {"type": "string", "example": "github.com/jgautheron/exago"}},
+			"branchQuery": {"name": "branch", "in": "query", "schema": {"type": "string", "default": "master"}},
+			"goVersionQuery": {"name": "goVersion", "in": "query", "description": "Latest analysis whatever the Go version if empty", "schema": {"type": "string"}}
+		},
+		"responses": {
+			"Error": {"description": "The error", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ErrorResponse"}}}}
+		},
+		"schemas": {
+			"ErrorResponse": {
+				"type": "object",
+				"required": ["error"],
+				"properties": {
+					"error": {
+						"type": "object",
+						"required": ["code", "message"],
+						"properties": {
+							"code": {
+								"type": "string",
+								"enum": ["validation_failed", "invalid_repository", "repository_not_found", "branch_not_found", "invalid_signature", "api_key_required", "not_found", "method_not_allowed", "already_pending", "not_pending", "rate_limited", "host_unavailable", "queue_unavailable", "not_ready", "uploads_busy", "analysis_failed", "internal_error"]
+							},
+							"message": {"type": "string"},
+							"details": {"type": "object", "additionalProperties": {"type": "string"}}
+						}
+					}
+				}
+			},
+			"Job": {
+				"type": "object",
+				"properties": {
+					"repository": {"type": "string"},
+					"branch": {"type": "string"},
+					"goVersion": {"type": "string"},
+					"requestId": {"type": "string", "description": "Identifies the analysis request tracked, the cancellation only applies to it"},
+					"state": {"type": "string", "enum": ["queued", "downloading", "running", "scored", "failed", "cancelled"]},
+					"errors": {"type": "object", "additionalProperties": {"type": "string"}},
+					"createdAt": {"type": "string", "format": "date-time"},
+					"updatedAt": {"type": "string", "format": "date-time"}
+				}
+			},
+			"RunnerProgress": {
+				"type": "object",
+				"properties": {
+					"repository": {"type": "string"},
+					"branch": {"type": "string"},
+					"goVersion": {"type": "string"},
+					"runner": {"type": "string"},
+					"label": {"type": "string"},
+					"status": {"type": "string", "enum": ["started", "finished"]},
+					"executionTime": {"type": "integer", "description": "Nanoseconds"},
+					"error": {"type": "string"}
+				}
+			},
+			"ProjectList": {
+				"type": "object",
+				"properties": {
+					"projects": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"properties": {
+								"repository": {"type": "string"},
+								"branch": {"type": "string"},
+								"goVersion": {"type": "string"},
+								"rank": {"type": "string"},
+								"score": {"type": "number"},
+								"stars": {"type": "integer"},
+								"description": {"type": "string"},
+								"image": {"type": "string"},
+								"processedAt": {"type": "string", "format": "date-time"}
+							}
+						}
+					},
+					"cursor": {"type": "string", "description": "Cursor of the next page, absent on the last page"}
+				}
+			},
+			"History": {
+				"type": "object",
+				"properties": {
+					"history": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"properties": {
+								"commit": {"type": "string"},
+								"commitDate": {"type": "string", "format": "date-time", "description": "Omitted if unknown"},
+								"processedAt": {"type": "string", "format": "date-time"},
+								"score": {"type": "number"},
+								"rank": {"type": "string"},
+								"coverage": {"type": "number"},
+								"lintMessages": {"type": "integer"},
+								"thirdParties": {"type": "integer"},
+								"loc": {"type": "integer"}
+							}
+						}
+					}
+				}
+			},
+			"Comparison": {
+				"type": "object",
+				"properties": {
+					"repository": {"type": "string"},
+					"base": {"$ref": "#/components/schemas/Snapshot"},
+					"head": {"$ref": "#/components/schemas/Snapshot"},
+					"diff": {
+						"type": "object",
+						"properties": {
+							"score": {
+								"type": "object",
+								"properties": {
+									"base": {"type": "number"},
+									"head": {"type": "number"},
+									"delta": {"type": "number"},
+									"baseRank": {"type": "string"},
+									"headRank": {"type": "string"},
+									"details": {"type": "array", "items": {"$ref": "#/components/schemas/EvaluatorDelta"}}
+								}
+							},
+							"lintMessages": {
+								"type": "object",
+								"description": "New and fixed messages keyed by file path",
+								"additionalProperties": {
+									"type": "object",
+									"properties": {
+										"new": {"type": "array", "items": {"$ref": "#/components/schemas/LintMessage"}},
+										"fixed": {"type": "array", "items": {"$ref": "#/components/schemas/LintMessage"}}
+									}
+								}
+							},
+							"coverage": {
+								"type": "array",
+								"items": {
+									"type": "object",
+									"properties": {
+										"name": {"type": "string"},
+										"path": {"type": "string"},
+										"base": {"type": "number"},
+										"head": {"type": "number"},
+										"delta": {"type": "number"}
+									}
+								}
+							},
+							"thirdParties": {
+								"type": "object",
+								"properties": {
+									"added": {"type": "array", "items": {"type": "string"}},
+									"removed": {"type": "array", "items": {"type": "string"}}
+								}
+							},
+							"failingTests": {
+								"type": "array",
+								"description": "Tests failing in the head that did not fail in the base, without test name when the whole package fails",
+								"items": {
+									"type": "object",
+									"properties": {
+										"package": {"type": "string"},
+										"test": {"type": "string"}
+									}
+								}
+							}
+						}
+					}
+				}
+			},
+			"RepositoryAdded": {
+				"type": "object",
+				"properties": {
+					"repository": {"type": "string"},
+					"branch": {"type": "string"},
+					"goVersion": {"type": "string"},
+					"commit": {"type": "string"}
+				}
+			},
+			"UploadResult": {
+				"type": "object",
+				"properties": {
+					"repository": {"type": "string", "description": "The module path"},
+					"commit": {"type": "string"},
+					"commitDate": {"type": "string", "format": "date-time", "description": "Omitted if unknown"},
+					"processedAt": {"type": "string", "format": "date-time"},
+					"data": {"type": "object", "description": "The results of the runners, the errors and the score"}
+				}
+			},
+			"Snapshot": {
+				"type": "object",
+				"properties": {
+					"branch": {"type": "string"},
+					"goVersion": {"type": "string"},
+					"commit": {"type": "string"},
+					"commitDate": {"type": "string", "format": "date-time", "description": "Omitted if unknown"},
+					"processedAt": {"type": "string", "format": "date-time"}
+				}
+			},
+			"EvaluatorDelta": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"base": {"type": "number"},
+					"head": {"type": "number"},
+					"delta": {"type": "number"},
+					"details": {"type": "array", "items": {"$ref": "#/components/schemas/EvaluatorDelta"}}
+				}
+			},
+			"LintMessage": {
+				"type": "object",
+				"properties": {
+					"linter": {"type": "string"},
+					"row": {"type": "integer"},
+					"column": {"type": "integer"},
+					"message": {"type": "string"},
+					"severity": {"type": "string"}
+				}
+			},
+			"AnnotatedFile": {
+				"type": "object",
+				"properties": {
+					"path": {"type": "string"},
+					"content": {"type": "string"},
+					"lines": {
+						"type": "array",
+						"items": {
+							"type": "object",
+							"properties": {
+								"line": {"type": "integer"},
+								"covered": {"type": "boolean"},
+								"messages": {
+									"type": "array",
+									"items": {
+										"type": "object",
+										"properties": {
+											"linter": {"type": "string"},
+											"column": {"type": "integer"},
+											"message": {"type": "string"},
+											"severity": {"type": "string"}
+										}
+									}
+								}
+							}
+						}
+					}
+				}
+			},
+			"ShieldsEndpoint": {
+				"type": "object",
+				"properties": {
+					"schemaVersion": {"type": "integer"},
+					"label": {"type": "string"},
+					"message": {"type": "string"},
+					"color": {"type": "string"},
+					"isError": {"type": "boolean"}
+				}
+			}
+		}
+	}
+}
+`
+
+// openAPIHandler serves the OpenAPI specification of the API.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
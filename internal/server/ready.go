@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/sirupsen/logrus"
+)
+
+// readyTimeout bounds the time spent checking each dependency
+const readyTimeout = 3 * time.Second
+
+// Pinger is implemented by the dependencies the API needs to serve requests,
+// such as the database and the event publisher.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// readinessCheck names a dependency checked by /ready.
+type readinessCheck struct {
+	name   string
+	pinger Pinger
+}
+
+type readyResponse struct {
+	Checks map[string]string `json:"checks"`
+}
+
+// readyHandler reports whether the instance can serve requests, unlike the /ping
+// heartbeat it checks every dependency. The instance is no longer ready once it
+// started shutting down so that the load balancer stops sending it requests.
+func (s Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-s.done:
+		writeError(w, r, newErrResponse(http.StatusServiceUnavailable, codeNotReady, ErrShuttingDown, nil))
+		return
+	default:
+	}
+
+	checks, ready := make(map[string]string), true
+	for _, c := range s.checks {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		err := c.pinger.Ping(ctx)
+		cancel()
+		if err != nil {
+			logrus.WithError(err).Warnf("The %s is not ready", c.name)
+			checks[c.name], ready = err.Error(), false
+			continue
+		}
+		checks[c.name] = "ok"
+	}
+
+	if !ready {
+		writeError(w, r, newErrResponse(http.StatusServiceUnavailable, codeNotReady, ErrNotReady, checks))
+		return
+	}
+	render.JSON(w, r, readyResponse{Checks: checks})
+}
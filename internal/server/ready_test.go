@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakePinger fails with err if set.
+type fakePinger struct {
+	err error
+}
+
+func (p fakePinger) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestReadyHandler(t *testing.T) {
+	closed := make(chan struct{})
+	close(closed)
+
+	var tests = []struct {
+		checks     []readinessCheck
+		done       chan struct{}
+		statusCode int
+		expected   map[string]string
+	}{
+		{
+			[]readinessCheck{{"database", fakePinger{}}, {"publisher", fakePinger{}}},
+			nil,
+			http.StatusOK,
+			map[string]string{"database": "ok", "publisher": "ok"},
+		},
+		{
+			[]readinessCheck{{"database", fakePinger{}}, {"publisher", fakePinger{errors.New("unreachable")}}},
+			nil,
+			http.StatusServiceUnavailable,
+			map[string]string{"database": "ok", "publisher": "unreachable"},
+		},
+		{
+			[]readinessCheck{{"database", fakePinger{}}},
+			closed,
+			http.StatusServiceUnavailable,
+			nil,
+		},
+	}
+
+	for i, tt := range tests {
+		s := &Server{checks: tt.checks, done: tt.done}
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+		if w.Code != tt.statusCode {
+			t.Errorf("Case #%d: got status %d, expected %d", i, w.Code, tt.statusCode)
+			continue
+		}
+
+		var got map[string]string
+		if w.Code == http.StatusOK {
+			var res readyResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			got = res.Checks
+		} else {
+			var res ErrResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.Error.Code != codeNotReady {
+				t.Errorf("Case #%d: got error %s", i, res.Error.Code)
+			}
+			got = res.Error.Details
+		}
+		if len(got) != len(tt.expected) {
+			t.Errorf("Case #%d: got checks %v, expected %v", i, got, tt.expected)
+			continue
+		}
+		for k, v := range tt.expected {
+			if got[k] != v {
+				t.Errorf("Case #%d: got checks %v, expected %v", i, got, tt.expected)
+			}
+		}
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	Config.HTTPBind, Config.HTTPPort, Config.ShutdownTimeout = "127.0.0.1", port, time.Second
+	Config.ShutdownDrainDelay = 300 * time.Millisecond
+	s := &Server{done: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.ListenAndServe(ctx)
+	}()
+	url := fmt.Sprintf("http://127.0.0.1:%d/ready", port)
+	for i := 0; ; i++ {
+		if res, err := http.Get(url); err == nil {
+			res.Body.Close()
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	// The instance is still serving while it drains, but not ready anymore
+	<-s.done
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("The server should serve while draining, got %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Got status %d while draining, expected %d", res.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("The server should stop cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The server did not stop")
+	}
+}
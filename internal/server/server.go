@@ -4,26 +4,94 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
-	"github.com/jgautheron/exago/internal/database/firestore"
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/backend"
 	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/jgautheron/exago/internal/github"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+	"github.com/sirupsen/logrus"
 )
 
-var ErrInvalidRepository = errors.New("The repository doesn't contain Go code")
+var (
+	ErrInvalidRepository  = errors.New("The repository doesn't contain Go code")
+	ErrRepositoryPath     = errors.New("The repository must be a GitHub path such as github.com/owner/name")
+	ErrRepositoryNotFound = errors.New("The repository does not exist or is private")
+	ErrBranchNotFound     = errors.New("The branch does not exist")
+	ErrInvalidGoVersion   = errors.New("The Go version is not valid")
+	ErrHostUnavailable    = errors.New("The repository host could not be reached")
+	ErrQueueUnavailable   = errors.New("The analysis could not be queued")
+	ErrAlreadyPending     = errors.New("An analysis of the project is already pending")
+	ErrRateLimitExceeded  = errors.New("Rate limit exceeded")
+	ErrProjectNotFound    = errors.New("The project has not been analyzed yet")
+	ErrJobNotFound        = errors.New("No analysis was requested for the project")
+	ErrFileNotFound       = errors.New("The file does not exist")
+	ErrFilePath           = errors.New("The path must be made of the repository followed by the file path")
+	ErrInvalidLimit       = errors.New("The limit must be a number between 1 and 100")
+	ErrInvalidRank        = errors.New("The rank must be a letter between A and F, optionally followed by + or -")
+	ErrInvalidRef         = errors.New("The reference must be a branch, optionally followed by @ and a commit")
+	ErrRouteNotFound      = errors.New("The requested resource does not exist")
+	ErrMethodNotAllowed   = errors.New("The method is not allowed on this resource")
+	ErrInternal           = errors.New("An internal error occurred")
+	ErrNotReady           = errors.New("A dependency of the API is not available")
+	ErrShuttingDown       = errors.New("The instance is shutting down")
+	ErrInvalidSignature   = errors.New("The webhook signature does not match the payload")
+	ErrWebhookPayload     = errors.New("The webhook payload could not be decoded")
+	ErrInvalidArchive     = errors.New("The archive must be a gzipped tarball")
+	ErrArchiveEntry       = errors.New("The archive must not contain links nor paths outside of it")
+	ErrArchiveTooLarge    = errors.New("The archive exceeds the maximum size")
+	ErrArchiveModule      = errors.New("The archive must contain a go.mod declaring a valid module path at its root or in its single top directory")
+	ErrUploadsBusy        = errors.New("Too many archives are being analyzed, try again later")
+	ErrAnalysisFailed     = errors.New("The module could not be analyzed")
+	ErrNotPending         = errors.New("The analysis is neither queued nor running")
+	ErrAPIKeyRequired     = errors.New("A valid API key must be sent in the X-API-Key header")
+)
 
 type Server struct {
-	db  *firestore.Firestore
-	evp *eventpub.EventPub
-}
+	db   database.ResultStore
+	evp  eventpub.PubSub
+	host github.RepositoryHost
+
+	// progress fans out the progress events received from the consumers
+	progress *eventpub.Broker
+
+	// hooks debounces the analyses requested by the GitHub webhooks
+	hooks            *debouncer
+	webhookSecret    []byte
+	defaultGoVersion string
 
-func New() (*Server, error) {
-	ctx := context.Background()
+	// apiKeys are the keys accepted by the endpoints refused to anonymous clients
+	apiKeys     map[string]bool
+	limiters    *limiters
+	locks       *requestLocks
+	lockTimeout time.Duration
 
-	db, err := firestore.NewFromConfig(ctx, &Config.GoogleCloudConfig)
+	// uploads limits the archives analyzed at once, nil if the uploads are disabled
+	uploads       chan struct{}
+	uploadMaxSize int64
+	// goProxy and goSumDB are used to download the dependencies of the uploaded modules
+	goProxy string
+	goSumDB string
+	// timeouts bound the analyses of the uploaded modules
+	timeouts task.Timeouts
+
+	// checks are the dependencies reported by /ready, closers are released on shutdown
+	checks  []readinessCheck
+	closers []io.Closer
+	// done is closed once the server starts shutting down
+	done chan struct{}
+}
+
+// New connects to the database, Google Pub/Sub and GitHub.
+// The progress events are received until the context is cancelled.
+func New(ctx context.Context) (*Server, error) {
+	db, err := backend.NewFromConfig(ctx, &Config.DatabaseConfig, &Config.GoogleCloudConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -33,11 +101,119 @@ func New() (*Server, error) {
 		return nil, err
 	}
 
-	return &Server{db: db, evp: evp}, nil
+	host, err := github.NewWithConfig(ctx, &Config.GitHubConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWith(ctx, db, evp, host), nil
+}
+
+// NewWith creates a server on top of the given dependencies, e.g. an in-process
+// queue shared with a consumer. They are released along with the server.
+// The progress events are received until the context is cancelled.
+func NewWith(ctx context.Context, db database.ResultStore, evp eventpub.PubSub, host github.RepositoryHost) *Server {
+	progress := eventpub.NewBroker()
+	go func() {
+		if err := evp.ReceiveRunnerProgress(ctx, func(ev *eventpub.RunnerProgressEvent) {
+			progress.RunnerProgress(ev)
+		}); err != nil {
+			logrus.WithError(err).Error("Stopped receiving progress events")
+		}
+	}()
+
+	s := &Server{
+		db:       db,
+		evp:      evp,
+		host:     host,
+		progress: progress,
+		limiters: newLimiters(
+			Config.RateLimitCount, Config.RateLimitKeyCount, Config.RateLimitWindow, Config.APIKeys,
+		),
+		apiKeys:     newAPIKeys(Config.APIKeys),
+		locks:       newRequestLocks(),
+		lockTimeout: Config.RequestLockTimeout,
+		checks: []readinessCheck{
+			{"database", db},
+			{"publisher", evp},
+		},
+		webhookSecret:    []byte(Config.GithubWebhookSecret),
+		defaultGoVersion: Config.GithubWebhookGoVersion,
+		uploadMaxSize:    Config.UploadMaxSize,
+		goProxy:          Config.AnalysisGoProxy,
+		goSumDB:          Config.AnalysisGoSumDB,
+		done:             make(chan struct{}),
+		timeouts: task.Timeouts{
+			Analysis: Config.AnalysisTimeout,
+			Runner:   Config.RunnerTimeout,
+			Runners:  Config.RunnerTimeouts,
+		},
+	}
+	if Config.UploadEnabled {
+		slots := Config.UploadMaxConcurrent
+		if slots < 1 {
+			slots = 1
+		}
+		s.uploads = make(chan struct{}, slots)
+	}
+	s.hooks = newDebouncer(Config.GithubWebhookDebounce, s.queueWebhookAnalysis)
+	// The pending webhook analyses are published before the publisher is closed
+	s.closers = []io.Closer{s.hooks, db, evp}
+	return s
+}
+
+// ListenAndServe binds the HTTP port and serves the requests until the context
+// is cancelled. The instance then reports that it is not ready but keeps serving
+// for ShutdownDrainDelay, so that the load balancer stops sending it requests,
+// and the requests in progress are given ShutdownTimeout to complete.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", Config.HTTPBind, Config.HTTPPort),
+		Handler: s.routes(),
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	// End the event streams, they would otherwise keep the server busy until the timeout
+	close(s.done)
+	logrus.WithField("delay", Config.ShutdownDrainDelay).Info("Shutting down, draining the requests")
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(Config.ShutdownDrainDelay):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Config.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return errors.New("The requests in progress did not complete in time")
+	}
+	return nil
+}
+
+// Close releases the database and event publisher clients.
+func (s *Server) Close() error {
+	var failed error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil {
+			logrus.WithError(err).Error("Could not close client")
+			failed = err
+		}
+	}
+	return failed
 }
 
-// ListenAndServe binds the HTTP port and listens for requests.
-func (s *Server) ListenAndServe() error {
+// routes registers the middlewares and the API endpoints.
+func (s *Server) routes() http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Heartbeat("/ping"))
@@ -48,23 +224,42 @@ func (s *Server) ListenAndServe() error {
 	cors := cors.New(cors.Options{
 		AllowedOrigins:   Config.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "PATCH", "PUT", "POST", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Cache-Control"},
-		ExposedHeaders:   []string{"Location"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Cache-Control", apiKeyHeader},
+		ExposedHeaders:   []string{"Location", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	})
 	r.Use(cors.Handler)
 
-	r.Get("/project/{goVersion}/{branch}/*", s.processRepository)
-	r.Get("/file/*", s.testHandler)
-	r.Get("/badge/{type}/*", s.testHandler)
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowed)
+
+	// Only the submissions are limited, following an analysis is free. They are
+	// charged before the repository is validated, which costs GitHub API calls
+	enqueue := s.rateLimit(s.checkValidRepository(s.requestLock(http.HandlerFunc(s.processRepository))))
+	r.Get("/project/{goVersion}/{branch}/*", enqueue.ServeHTTP)
+	// The actions precede the repository, which is a host followed by a path
+	// that may end with any of them (e.g. github.com/foo/status)
+	r.Get("/project/{goVersion}/{branch}/status/*", s.jobStatus)
+	r.Get("/project/{goVersion}/{branch}/events/*", s.progressEvents)
+	r.Get("/project/{goVersion}/{branch}/history/*", s.projectHistory)
+	// Cancelling stops the analyses requested by others, it is reserved to the API keys
+	r.With(s.requireAPIKey, s.rateLimit).Post("/project/{goVersion}/{branch}/cancel/*", s.cancelJob)
+	r.Get("/file/*", s.fileHandler)
+	r.Get("/badge/{type}/*", s.badgeHandler)
+	r.Get("/compare/*", s.compareHandler)
+	r.Post("/hooks/github", s.githubWebhook)
+	// Uploads run arbitrary code on the instance, they are reserved to the API keys
+	if s.uploads != nil {
+		r.With(s.requireAPIKey, s.rateLimit).Post("/upload", s.uploadHandler)
+	}
+
+	r.Get("/projects/recent", s.listProjects(database.OrderRecent))
+	r.Get("/projects/top", s.listProjects(database.OrderTop))
+	r.Get("/projects/popular", s.listProjects(database.OrderPopular))
 
-	r.Get("/projects/recent", s.testHandler)
-	r.Get("/projects/top", s.testHandler)
-	r.Get("/projects/popular", s.testHandler)
+	r.Get("/ready", s.readyHandler)
+	r.Get("/openapi.json", openAPIHandler)
 
-	return http.ListenAndServe(
-		fmt.Sprintf("%s:%d", Config.HTTPBind, Config.HTTPPort),
-		r,
-	)
+	return r
 }
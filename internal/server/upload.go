@@ -0,0 +1,209 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jgautheron/exago/internal/database"
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/jgautheron/exago/pkg/analysis/score"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// uploadResult holds the results of an uploaded module, which are not saved.
+type uploadResult struct {
+	Repository  string     `json:"repository"`
+	Commit      string     `json:"commit,omitempty"`
+	CommitDate  *time.Time `json:"commitDate,omitempty"`
+	ProcessedAt time.Time  `json:"processedAt"`
+	Data        exago.Data `json:"data"`
+}
+
+// uploadHandler analyzes the gzipped tarball of a module sent in the body,
+// synchronously, so that the private modules can be scored without being
+// published. The request is refused if too many archives are being analyzed.
+func (s Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	select {
+	case s.uploads <- struct{}{}:
+		defer func() { <-s.uploads }()
+	default:
+		w.Header().Set("Retry-After", "60")
+		writeError(w, r, newErrResponse(http.StatusServiceUnavailable, codeUploadsBusy, ErrUploadsBusy, nil))
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "exago-upload-")
+	if err != nil {
+		logrus.WithError(err).Error("Could not create the upload directory")
+		writeError(w, r, errInternal())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	err = extractArchive(&sizeLimiter{r: r.Body, n: s.uploadMaxSize}, dir, s.uploadMaxSize)
+	switch errors.Cause(err) {
+	case nil:
+	case ErrArchiveTooLarge:
+		writeError(w, r, newErrResponse(http.StatusRequestEntityTooLarge, codeValidationFailed, ErrArchiveTooLarge, map[string]string{"field": "archive"}))
+		return
+	case ErrArchiveEntry:
+		writeError(w, r, errValidation("archive", ErrArchiveEntry))
+		return
+	default:
+		logrus.WithError(err).Debug("Could not extract the uploaded archive")
+		writeError(w, r, errValidation("archive", ErrInvalidArchive))
+		return
+	}
+
+	root, module, err := findModule(dir)
+	if err != nil {
+		writeError(w, r, errValidation("archive", ErrArchiveModule))
+		return
+	}
+
+	start := time.Now()
+	m := task.NewManager(module)
+	m.UseLocalPath(root)
+	m.UseGoProxy(s.goProxy, s.goSumDB)
+	m.UseTimeouts(s.timeouts)
+	defer func() {
+		if err := m.Cleanup(); err != nil {
+			logrus.WithError(err).Warnf("Could not clean up the workspace of %s", module)
+		}
+	}()
+	// The analysis is interrupted if the client goes away
+	ctx := r.Context()
+	if err := m.Download(ctx); err == task.ErrCancelled {
+		return
+	} else if err != nil {
+		writeError(w, r, newErrResponse(http.StatusUnprocessableEntity, codeAnalysisFailed, ErrAnalysisFailed, m.Errors))
+		return
+	}
+	if m.Analyze(ctx); ctx.Err() != nil {
+		return
+	}
+
+	results, err := m.Results()
+	if err != nil {
+		logrus.WithError(err).Errorf("Could not load the results of the uploaded module %s", module)
+		writeError(w, r, errInternal())
+		return
+	}
+	logrus.WithField("module", module).Infof("Analyzed the uploaded module in %s", time.Since(start))
+
+	render.JSON(w, r, &uploadResult{
+		Repository:  module,
+		Commit:      m.Commit(),
+		CommitDate:  database.OptionalDate(m.CommitDate()),
+		ProcessedAt: time.Now(),
+		// The uploaded modules have no metadata
+		Data: score.Data(results, m.Errors),
+	})
+}
+
+// extractArchive extracts the gzipped tarball in dir. The links and the paths
+// escaping dir are refused, as well as a content larger than maxSize.
+func extractArchive(r io.Reader, dir string, maxSize int64) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "Could not decompress the archive")
+	}
+	defer gz.Close()
+
+	var size int64
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "Could not read the archive")
+		}
+
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return ErrArchiveEntry
+		}
+		target := filepath.Join(dir, name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if size += hdr.Size; size > maxSize {
+				return ErrArchiveTooLarge
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := writeEntry(tr, target, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return errors.Wrap(err, "Could not extract the archive")
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			return ErrArchiveEntry
+		}
+		// The other entries, such as the global header of git archive, hold no file
+	}
+}
+
+// sizeLimiter fails with ErrArchiveTooLarge once more than n bytes are read.
+type sizeLimiter struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		return n, ErrArchiveTooLarge
+	}
+	return n, err
+}
+
+func writeEntry(r io.Reader, path string, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// findModule returns the directory holding the go.mod, at the root of the
+// archive or in its single top directory as made by git archive --prefix,
+// along with the module path.
+func findModule(dir string) (string, string, error) {
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) == 1 && entries[0].IsDir() {
+		dir = filepath.Join(dir, entries[0].Name())
+	}
+
+	gomod, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return "", "", err
+	}
+	// The module path names the directory of the workspace the archive is copied to
+	module := task.ModulePath(gomod)
+	if err := task.CheckModulePath(module); err != nil {
+		return "", "", ErrArchiveModule
+	}
+	return dir, module, nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+type archiveEntry struct {
+	name     string
+	typeflag byte
+	body     string
+}
+
+var moduleArchive = []archiveEntry{
+	{"baz/", tar.TypeDir, ""},
+	{"baz/go.mod", tar.TypeReg, "module example.com/private/baz\n\ngo 1.13\n"},
+	{"baz/baz.go", tar.TypeReg, "package baz\n\n// Answer is the answer\nfunc Answer() int { return 42 }\n"},
+	{"baz/baz_test.go", tar.TypeReg, "package baz\n\nimport \"testing\"\n\nfunc TestAnswer(t *testing.T) {\n\tif Answer() != 42 {\n\t\tt.Fail()\n\t}\n}\n"},
+}
+
+var uploadKeys = map[string]bool{"secret": true}
+
+func TestUploadValidation(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	var tests = []struct {
+		name       string
+		body       []byte
+		statusCode int
+		code       string
+	}{
+		{"not gzipped", []byte("package main"), http.StatusBadRequest, codeValidationFailed},
+		{"not a tarball", gzipped(t, []byte("package main")), http.StatusBadRequest, codeValidationFailed},
+		{"escaping path", archive(t, []archiveEntry{
+			{"go.mod", tar.TypeReg, "module example.com/baz\n"},
+			{"../baz.go", tar.TypeReg, "package baz\n"},
+		}), http.StatusBadRequest, codeValidationFailed},
+		{"link", archive(t, []archiveEntry{
+			{"go.mod", tar.TypeReg, "module example.com/baz\n"},
+			{"passwd", tar.TypeSymlink, "/etc/passwd"},
+		}), http.StatusBadRequest, codeValidationFailed},
+		{"too large", archive(t, []archiveEntry{
+			{"go.mod", tar.TypeReg, "module example.com/baz\n"},
+			{"baz.go", tar.TypeReg, "package baz\n\n" + strings.Repeat("// Filler\n", 1000)},
+		}), http.StatusRequestEntityTooLarge, codeValidationFailed},
+		{"without go.mod", archive(t, []archiveEntry{
+			{"baz/baz.go", tar.TypeReg, "package baz\n"},
+		}), http.StatusBadRequest, codeValidationFailed},
+		{"escaping module path", archive(t, []archiveEntry{
+			{"go.mod", tar.TypeReg, "module ../../../../home/app/x\n"},
+			{"baz.go", tar.TypeReg, "package baz\n"},
+		}), http.StatusBadRequest, codeValidationFailed},
+		{"missing dependency", archive(t, []archiveEntry{
+			{"go.mod", tar.TypeReg, "module example.com/baz\n\nrequire example.com/missing v1.0.0\n"},
+			{"baz.go", tar.TypeReg, "package baz\n"},
+		}), http.StatusUnprocessableEntity, codeAnalysisFailed},
+	}
+
+	s := &Server{uploads: make(chan struct{}, 1), uploadMaxSize: 4096, goProxy: "off", goSumDB: "off", apiKeys: uploadKeys}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, uploadRequest(tt.body))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.name, w.Code, tt.statusCode)
+			continue
+		}
+
+		var res ErrResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if res.Error.Code != tt.code {
+			t.Errorf("%s: wrong error %#v", tt.name, res.Error)
+		}
+	}
+}
+
+func TestUploadUnavailable(t *testing.T) {
+	body := archive(t, moduleArchive)
+
+	w := httptest.NewRecorder()
+	(&Server{}).routes().ServeHTTP(w, httptest.NewRequest("POST", "/upload", bytes.NewReader(body)))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Got status %d, the uploads should be disabled by default", w.Code)
+	}
+
+	s := &Server{uploads: make(chan struct{}, 1), uploadMaxSize: 4096, apiKeys: uploadKeys}
+	w = httptest.NewRecorder()
+	s.routes().ServeHTTP(w, httptest.NewRequest("POST", "/upload", bytes.NewReader(body)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Got status %d, the uploads should require an API key", w.Code)
+	}
+
+	s.uploads <- struct{}{}
+	w = httptest.NewRecorder()
+	s.routes().ServeHTTP(w, uploadRequest(body))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Got status %d, the upload should be refused while another is analyzed", w.Code)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Builds the module with an empty cache")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	s := &Server{uploads: make(chan struct{}, 1), uploadMaxSize: 4096, goProxy: "off", goSumDB: "off", apiKeys: uploadKeys}
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, uploadRequest(archive(t, moduleArchive)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Got status %d: %s", w.Code, w.Body)
+	}
+
+	var res uploadResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Repository != "example.com/private/baz" || res.Data.Score.Rank == "" {
+		t.Errorf("Wrong result %#v", res)
+	}
+	if len(res.Data.Results.Test.Data) != 1 || res.Data.Results.Coverage.Data.Coverage != 100 {
+		t.Errorf("The module should be analyzed, got %#v", res.Data)
+	}
+	if len(s.uploads) != 0 {
+		t.Error("The upload slot should be released")
+	}
+}
+
+// uploadRequest sends the body with the API key of uploadKeys
+func uploadRequest(body []byte) *http.Request {
+	r := httptest.NewRequest("POST", "/upload", bytes.NewReader(body))
+	r.Header.Set(apiKeyHeader, "secret")
+	return r
+}
+
+func archive(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644}
+		switch e.typeflag {
+		case tar.TypeReg:
+			hdr.Size = int64(len(e.body))
+		case tar.TypeSymlink:
+			hdr.Linkname = e.body
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return gzipped(t, buf.Bytes())
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/eventpub"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	githubEventHeader         = "X-GitHub-Event"
+	githubSignatureHeader     = "X-Hub-Signature-256"
+	githubSHA1SignatureHeader = "X-Hub-Signature"
+	// maxWebhookSize is the largest payload accepted, GitHub caps them at 25MB
+	maxWebhookSize = 25 << 20
+)
+
+// pullRequestActions are the pull request actions changing the head of the branch.
+var pullRequestActions = map[string]bool{
+	"opened":      true,
+	"reopened":    true,
+	"synchronize": true,
+}
+
+type githubRepository struct {
+	FullName string `json:"full_name"`
+}
+
+// githubPushEvent and githubPullRequestEvent are the parts of the
+// GitHub webhook payloads needed to request an analysis.
+type githubPushEvent struct {
+	Ref        string           `json:"ref"`
+	After      string           `json:"after"`
+	Deleted    bool             `json:"deleted"`
+	Repository githubRepository `json:"repository"`
+}
+
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Head struct {
+			Ref  string           `json:"ref"`
+			SHA  string           `json:"sha"`
+			Repo githubRepository `json:"repo"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+// githubWebhook requests the analysis of the branches pushed to, and of the
+// head branch of the pull requests. Successive pushes to a branch are debounced
+// so that only the last one is analyzed. The endpoint is disabled without secret.
+func (s Server) githubWebhook(w http.ResponseWriter, r *http.Request) {
+	if len(s.webhookSecret) == 0 {
+		notFound(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		writeError(w, r, errValidation("payload", ErrWebhookPayload))
+		return
+	}
+	if !validSignature(s.webhookSecret, body, r.Header) {
+		writeError(w, r, newErrResponse(http.StatusUnauthorized, codeInvalidSignature, ErrInvalidSignature, nil))
+		return
+	}
+
+	ev, err := parseWebhook(r.Header.Get(githubEventHeader), body)
+	if err != nil {
+		writeError(w, r, errValidation("payload", ErrWebhookPayload))
+		return
+	}
+	if ev == nil {
+		// ping, tags, deleted branches and closed pull requests
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ev.GoVersion = s.webhookGoVersion(r.Context(), ev.Repository, ev.Branch)
+	s.hooks.Push(ev)
+
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, ev)
+}
+
+// validSignature checks the HMAC of the payload, the SHA-256 signature
+// is preferred to the SHA-1 one that older GitHub setups send alone.
+func validSignature(secret, body []byte, header http.Header) bool {
+	signature, newHash := header.Get(githubSignatureHeader), sha256.New
+	if signature == "" {
+		signature, newHash = header.Get(githubSHA1SignatureHeader), sha1.New
+	}
+
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(newHash, secret)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// parseWebhook extracts the repository, branch and commit to analyze from
+// a push or pull_request payload. Nil is returned for the other events.
+func parseWebhook(event string, body []byte) (*eventpub.RepositoryAddedEvent, error) {
+	switch event {
+	case "push":
+		var push githubPushEvent
+		if err := json.Unmarshal(body, &push); err != nil {
+			return nil, err
+		}
+		if push.Deleted || !strings.HasPrefix(push.Ref, "refs/heads/") {
+			return nil, nil
+		}
+		return &eventpub.RepositoryAddedEvent{
+			Repository: "github.com/" + push.Repository.FullName,
+			Branch:     strings.TrimPrefix(push.Ref, "refs/heads/"),
+			Commit:     push.After,
+		}, nil
+
+	case "pull_request":
+		var pr githubPullRequestEvent
+		if err := json.Unmarshal(body, &pr); err != nil {
+			return nil, err
+		}
+		if !pullRequestActions[pr.Action] {
+			return nil, nil
+		}
+		head := pr.PullRequest.Head
+		return &eventpub.RepositoryAddedEvent{
+			Repository: "github.com/" + head.Repo.FullName,
+			Branch:     head.Ref,
+			Commit:     head.SHA,
+		}, nil
+	}
+	return nil, nil
+}
+
+// webhookGoVersion returns the Go version of the latest analysis of the branch,
+// or the configured one if it was never analyzed.
+func (s Server) webhookGoVersion(ctx context.Context, repository, branch string) string {
+	p, err := s.findProject(ctx, repository, branch, "")
+	switch {
+	case err == nil:
+		return p.GoVersion
+	case err != database.ErrNotFound:
+		logrus.WithError(err).Errorf("Could not load project %s", repository)
+	}
+	return s.defaultGoVersion
+}
+
+// queueWebhookAnalysis is called once the pushes to a branch settled.
+func (s Server) queueWebhookAnalysis(ev *eventpub.RepositoryAddedEvent) {
+	if _, err := s.enqueue(context.Background(), ev); err != nil {
+		logrus.WithError(err).Errorf("Could not enqueue the analysis of %s@%s", ev.Repository, ev.Branch)
+	}
+}
+
+// debouncer delays the analysis requests until no other push
+// happened on the branch for the given delay.
+type debouncer struct {
+	delay   time.Duration
+	publish func(*eventpub.RepositoryAddedEvent)
+
+	mu      sync.Mutex
+	pending map[string]*pendingEvent
+}
+
+type pendingEvent struct {
+	ev    *eventpub.RepositoryAddedEvent
+	timer *time.Timer
+}
+
+func newDebouncer(delay time.Duration, publish func(*eventpub.RepositoryAddedEvent)) *debouncer {
+	return &debouncer{
+		delay:   delay,
+		publish: publish,
+		pending: make(map[string]*pendingEvent),
+	}
+}
+
+// Push schedules the event, replacing the one pending for the same branch.
+func (d *debouncer) Push(ev *eventpub.RepositoryAddedEvent) {
+	key := database.ProjectID(ev.Repository, ev.Branch, ev.GoVersion)
+	p := &pendingEvent{ev: ev}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if prev, ok := d.pending[key]; ok {
+		prev.timer.Stop()
+	}
+	d.pending[key] = p
+	p.timer = time.AfterFunc(d.delay, func() {
+		d.mu.Lock()
+		if d.pending[key] != p {
+			// Replaced by a later push
+			d.mu.Unlock()
+			return
+		}
+		delete(d.pending, key)
+		d.mu.Unlock()
+		d.publish(p.ev)
+	})
+}
+
+// Close publishes the pending events right away.
+func (d *debouncer) Close() error {
+	d.mu.Lock()
+	var events []*eventpub.RepositoryAddedEvent
+	for key, p := range d.pending {
+		// A timer that already fired finds its event gone and does not publish it twice
+		p.timer.Stop()
+		events = append(events, p.ev)
+		delete(d.pending, key)
+	}
+	d.mu.Unlock()
+
+	for _, ev := range events {
+		d.publish(ev)
+	}
+	return nil
+}
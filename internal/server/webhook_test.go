@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/internal/database"
+	"github.com/jgautheron/exago/internal/database/memory"
+	"github.com/jgautheron/exago/internal/eventpub"
+)
+
+const testWebhookSecret = "It's a Secret to Everybody"
+
+func TestGithubWebhook(t *testing.T) {
+	db := memory.New()
+	db.SaveProject(context.Background(), &database.Project{
+		Repository: "github.com/foo/bar", Branch: "feature/cache", GoVersion: "1.12", ProcessedAt: time.Now(),
+	})
+
+	published := make(chan *eventpub.RepositoryAddedEvent, 10)
+	s := &Server{
+		db:               db,
+		webhookSecret:    []byte(testWebhookSecret),
+		defaultGoVersion: "1.13",
+		hooks: newDebouncer(0, func(ev *eventpub.RepositoryAddedEvent) {
+			published <- ev
+		}),
+	}
+
+	var tests = []struct {
+		event      string
+		payload    string
+		secret     string
+		statusCode int
+		expected   *eventpub.RepositoryAddedEvent
+	}{
+		{"push", "push.json", testWebhookSecret, http.StatusAccepted, &eventpub.RepositoryAddedEvent{
+			Repository: "github.com/foo/bar", Branch: "feature/cache", GoVersion: "1.12", Commit: "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+		}},
+		{"pull_request", "pull_request.json", testWebhookSecret, http.StatusAccepted, &eventpub.RepositoryAddedEvent{
+			Repository: "github.com/baz/bar", Branch: "cache", GoVersion: "1.13", Commit: "34c5c7793cb3b279e22454cb6750c80560547b3a",
+		}},
+		{"push", "push_tag.json", testWebhookSecret, http.StatusNoContent, nil},
+		{"push", "push_deleted.json", testWebhookSecret, http.StatusNoContent, nil},
+		{"pull_request", "pull_request_closed.json", testWebhookSecret, http.StatusNoContent, nil},
+		{"ping", "ping.json", testWebhookSecret, http.StatusNoContent, nil},
+		{"push", "push.json", "wrong secret", http.StatusUnauthorized, nil},
+	}
+
+	for _, tt := range tests {
+		payload, err := ioutil.ReadFile(filepath.Join("testdata", tt.payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.routes().ServeHTTP(w, newWebhookRequest(tt.event, payload, tt.secret))
+		if w.Code != tt.statusCode {
+			t.Errorf("%s: got status %d, expected %d", tt.payload, w.Code, tt.statusCode)
+			continue
+		}
+		if tt.expected == nil {
+			continue
+		}
+
+		select {
+		case ev := <-published:
+			if *ev != *tt.expected {
+				t.Errorf("%s: got %#v, expected %#v", tt.payload, ev, tt.expected)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: no analysis requested", tt.payload)
+		}
+	}
+
+	select {
+	case ev := <-published:
+		t.Errorf("Unexpected analysis requested %#v", ev)
+	default:
+	}
+}
+
+func TestGithubWebhookDisabled(t *testing.T) {
+	s := &Server{}
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, newWebhookRequest("push", []byte("{}"), ""))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Got status %d, the webhook should be disabled without secret", w.Code)
+	}
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte(`{"zen":"Design for failure."}`)
+	sha1Mac := hmac.New(sha1.New, []byte(testWebhookSecret))
+	sha1Mac.Write(body)
+
+	var tests = []struct {
+		header, signature string
+		expected          bool
+	}{
+		{githubSignatureHeader, "sha256=" + sign(testWebhookSecret, body), true},
+		{githubSignatureHeader, "sha256=" + sign("other secret", body), false},
+		{githubSHA1SignatureHeader, "sha1=" + hex.EncodeToString(sha1Mac.Sum(nil)), true},
+		{githubSHA1SignatureHeader, "sha1=" + sign(testWebhookSecret, body)[:40], false},
+		{githubSignatureHeader, "sha256=zz", false},
+		{githubSignatureHeader, "", false},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set(tt.header, tt.signature)
+		if got := validSignature([]byte(testWebhookSecret), body, header); got != tt.expected {
+			t.Errorf("%s %s: got %v, expected %v", tt.header, tt.signature, got, tt.expected)
+		}
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	published := make(chan *eventpub.RepositoryAddedEvent, 10)
+	d := newDebouncer(50*time.Millisecond, func(ev *eventpub.RepositoryAddedEvent) {
+		published <- ev
+	})
+
+	for _, commit := range []string{"a", "b", "c"} {
+		d.Push(&eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13", Commit: commit})
+	}
+	d.Push(&eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "develop", GoVersion: "1.13", Commit: "d"})
+
+	got := map[string]string{}
+	for len(got) < 2 {
+		select {
+		case ev := <-published:
+			if _, ok := got[ev.Branch]; ok {
+				t.Fatalf("The pushes to %s were not debounced", ev.Branch)
+			}
+			got[ev.Branch] = ev.Commit
+		case <-time.After(time.Second):
+			t.Fatal("The pushes were never published")
+		}
+	}
+	if got["master"] != "c" || got["develop"] != "d" {
+		t.Errorf("Only the last push of each branch should be published, got %v", got)
+	}
+
+	// The pending pushes are published on close instead of being lost
+	d = newDebouncer(time.Hour, func(ev *eventpub.RepositoryAddedEvent) {
+		published <- ev
+	})
+	d.Push(&eventpub.RepositoryAddedEvent{Repository: "github.com/foo/bar", Branch: "master", GoVersion: "1.13", Commit: "e"})
+	d.Close()
+	select {
+	case ev := <-published:
+		if ev.Commit != "e" {
+			t.Errorf("Wrong event published on close %#v", ev)
+		}
+	default:
+		t.Error("The pending push was not published on close")
+	}
+}
+
+func newWebhookRequest(event string, payload []byte, secret string) *http.Request {
+	r := httptest.NewRequest("POST", "/hooks/github", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(githubEventHeader, event)
+	r.Header.Set(githubSignatureHeader, "sha256="+sign(secret, payload))
+	return r
+}
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,31 @@
+// Package shutdown lets the services stop gracefully when the platform terminates them.
+package shutdown
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Context returns a context cancelled on SIGTERM or SIGINT,
+// a second signal exits immediately.
+func Context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		s := <-sig
+		logrus.WithField("signal", s).Info("Shutting down")
+		cancel()
+
+		<-sig
+		logrus.Warn("Forced to exit")
+		os.Exit(1)
+	}()
+
+	return ctx, cancel
+}
@@ -1,7 +1,7 @@
 package checklist
 
 import (
-	"os"
+	"context"
 	"strings"
 	"sync"
 )
@@ -10,10 +10,14 @@ type CheckList struct {
 	checkList    []CheckItem
 	sourcePath   string
 	sourceGoPath string
+	// env is the environment of the go commands, holding the GOPATH
+	env []string
 }
 
-func New(sourcePath string) *CheckList {
-	sourceGoPath := strings.Replace(sourcePath, os.Getenv("GOPATH")+"/src/", "", 1)
+// New prepares the checklist of the repository in sourcePath,
+// within the GOPATH of the given environment.
+func New(sourcePath string, env []string) *CheckList {
+	sourceGoPath := strings.Replace(sourcePath, lookupEnv(env, "GOPATH")+"/src/", "", 1)
 
 	checkList := []CheckItem{
 		{
@@ -83,17 +87,18 @@ func New(sourcePath string) *CheckList {
 		//},
 	}
 
-	return &CheckList{checkList, sourcePath, sourceGoPath}
+	return &CheckList{checkList, sourcePath, sourceGoPath, env}
 }
 
-// RunTasks is a wrapper for running all tasks from the list
-func (c CheckList) RunTasks() (successful []string, failed []string) {
+// RunTasks is a wrapper for running all tasks from the list,
+// the commands are interrupted once the context is done
+func (c CheckList) RunTasks(ctx context.Context) (successful []string, failed []string) {
 	var wg sync.WaitGroup
 
 	wg.Add(len(c.checkList))
 	for _, task := range c.checkList {
 		go func(task CheckItem) {
-			if ok := task.run(c.sourcePath, c.sourceGoPath); ok {
+			if ok := task.run(ctx, c.sourcePath, c.sourceGoPath, c.env); ok {
 				successful = append(successful, task.Name)
 			} else {
 				failed = append(failed, task.Name)
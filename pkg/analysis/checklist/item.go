@@ -1,6 +1,8 @@
 package checklist
 
-type CheckItemParams func(sp, sgp string) bool
+import "context"
+
+type CheckItemParams func(ctx context.Context, sp, sgp string, env []string) bool
 
 type CheckItem struct {
 	Name string `json:"name"`
@@ -8,6 +10,6 @@ type CheckItem struct {
 	fn   func() CheckItemParams
 }
 
-func (ci CheckItem) run(sp, sgp string) (success bool) {
-	return ci.fn()(sp, sgp)
+func (ci CheckItem) run(ctx context.Context, sp, sgp string, env []string) (success bool) {
+	return ci.fn()(ctx, sp, sgp, env)
 }
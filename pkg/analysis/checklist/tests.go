@@ -1,18 +1,19 @@
 package checklist
 
 import (
+	"context"
 	"go/format"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/jgautheron/exago/pkg/analysis/proc"
 	"golang.org/x/lint"
 )
 
 func isFormatted() CheckItemParams {
-	return func(sourcePath, sourceGoPath string) bool {
+	return func(ctx context.Context, sourcePath, sourceGoPath string, env []string) bool {
 		errors := 0
 		filepath.Walk(sourcePath, func(path string, f os.FileInfo, err error) error {
 			if !strings.HasSuffix(filepath.Ext(path), ".go") {
@@ -36,7 +37,7 @@ func isFormatted() CheckItemParams {
 }
 
 func isLinted() CheckItemParams {
-	return func(sourcePath, sourceGoPath string) bool {
+	return func(ctx context.Context, sourcePath, sourceGoPath string, env []string) bool {
 		errors := 0
 		l := new(lint.Linter)
 
@@ -65,15 +66,19 @@ func isLinted() CheckItemParams {
 }
 
 func isVetted() CheckItemParams {
-	return func(sourcePath, sourceGoPath string) bool {
-		_, err := exec.Command("go", "vet", sourceGoPath).Output()
+	return func(ctx context.Context, sourcePath, sourceGoPath string, env []string) bool {
+		// The root package is vetted from its directory, in module mode as well
+		cmd := proc.Command(ctx, "go", "vet", ".")
+		cmd.Dir = sourcePath
+		cmd.Env = env
+		_, err := cmd.Output()
 		return err == nil
 	}
 }
 
 func hasFiles(tp FileType, files ...string) func() CheckItemParams {
 	return func() CheckItemParams {
-		return func(sourcePath, sourceGoPath string) bool {
+		return func(ctx context.Context, sourcePath, sourceGoPath string, env []string) bool {
 			return FilesExistAny(sourcePath, tp, files...)
 		}
 	}
@@ -81,7 +86,7 @@ func hasFiles(tp FileType, files ...string) func() CheckItemParams {
 
 func hasOccurrence(regex, filePattern string) func() CheckItemParams {
 	return func() CheckItemParams {
-		return func(sourcePath, sourceGoPath string) bool {
+		return func(ctx context.Context, sourcePath, sourceGoPath string, env []string) bool {
 			return FindOccurrencesInTree(sourcePath, regex, filePattern) > 0
 		}
 	}
@@ -90,3 +90,13 @@ func FindOccurrencesInTree(path, regex, filePattern string) int {
 	}
 	return matches
 }
+
+// lookupEnv returns the value of the variable in the environment.
+func lookupEnv(env []string, name string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], name+"=") {
+			return strings.TrimPrefix(env[i], name+"=")
+		}
+	}
+	return ""
+}
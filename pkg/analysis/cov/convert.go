@@ -6,13 +6,23 @@ import (
 	"go/build"
 	"go/parser"
 	"go/token"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"golang.org/x/tools/cover"
 )
 
 type converter struct {
+	// repository is the import path of the repository root
+	repository string
+	// build resolves the import paths of the profile, srcDir being the repository directory
+	build  build.Context
+	srcDir string
+	// listed are the packages of the repository by import path,
+	// the others are resolved with the build context
+	listed   map[string]listedPackage
 	packages map[string]*Package
 }
 
@@ -46,6 +56,7 @@ func (c *converter) convertProfile(p *cover.Profile) error {
 	if err != nil {
 		return err
 	}
+	cf := &File{Path: strings.TrimPrefix(path.Join(pkgpath, filepath.Base(file)), c.repository+"/")}
 	var stmts []statement
 	for _, fe := range extents {
 		f := &Function{
@@ -90,6 +101,19 @@ func (c *converter) convertProfile(p *cover.Profile) error {
 		}
 	}
 
+	// Keep the lines spanned by the statements for the file annotations
+	var spans []*Block
+	for _, fn := range pkg.Functions {
+		if fn.File != abspath {
+			continue
+		}
+		for _, stmt := range fn.Statements {
+			spans = append(spans, &Block{Start: stmt.Start, End: stmt.End, Covered: stmt.Reached > 0})
+		}
+	}
+	cf.Blocks = lineRanges(spans)
+	pkg.Files = append(pkg.Files, cf)
+
 	// Loop on each statement and determine coverage and TLOC by function
 	var totalStmts int
 	var totalReached int64
@@ -111,13 +135,54 @@ func (c *converter) convertProfile(p *cover.Profile) error {
 	return nil
 }
 
+// lineRanges compresses the blocks of the statements into the ranges of
+// consecutive lines sharing the same state, so that the annotations of large
+// files stay small. Statements may be nested (e.g. closures), the innermost
+// statement decides the state of a line.
+func lineRanges(blocks []*Block) []*Block {
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].End-blocks[i].Start > blocks[j].End-blocks[j].Start
+	})
+	covered := map[int]bool{}
+	for _, b := range blocks {
+		for n := b.Start; n <= b.End; n++ {
+			covered[n] = b.Covered
+		}
+	}
+	lines := make([]int, 0, len(covered))
+	for n := range covered {
+		lines = append(lines, n)
+	}
+	sort.Ints(lines)
+
+	var ranges []*Block
+	for _, n := range lines {
+		if last := len(ranges) - 1; last >= 0 && ranges[last].End == n-1 && ranges[last].Covered == covered[n] {
+			ranges[last].End = n
+			continue
+		}
+		ranges = append(ranges, &Block{Start: n, End: n, Covered: covered[n]})
+	}
+	return ranges
+}
+
 // findFile finds the location of the named file in GOROOT, GOPATH etc.
 func (c *converter) findFile(file string) (pkgname string, filename string, pkgpath string, abspath string, err error) {
 	dir, file := filepath.Split(file)
 	if dir != "" {
 		dir = dir[:len(dir)-1] // drop trailing '/'
 	}
-	pkg, err := build.Import(dir, ".", build.IgnoreVendor)
+	// The listed packages are found in module mode as well
+	if l, ok := c.listed[dir]; ok {
+		abs := strings.Replace(filepath.Join(l.Dir, file), filepath.Join(c.build.GOPATH, "src"), "$GOPATH", 1)
+		return l.Name, filepath.Join(l.Dir, file), l.ImportPath, abs, nil
+	}
+
+	srcDir := c.srcDir
+	if srcDir == "" {
+		srcDir = "."
+	}
+	pkg, err := c.build.Import(dir, srcDir, build.IgnoreVendor)
 	if err != nil {
 		return "", "", "", "", fmt.Errorf("can't find %q: %v", file, err)
 	}
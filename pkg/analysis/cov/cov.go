@@ -1,20 +1,23 @@
 package cov
 
 import (
+	"context"
 	"os"
+	"strings"
 
 	"golang.org/x/tools/cover"
 )
 
-// ConvertRepository converts a given repository to a Report struct
-func ConvertRepository(repo string) (*Report, error) {
-	r := &Report{}
-	err := r.collectPackages()
+// ConvertRepository converts a given repository to a Report struct,
+// the go commands run in dir with the given environment until the context is done.
+func ConvertRepository(ctx context.Context, repo, dir string, env []string) (*Report, error) {
+	r := &Report{repository: repo, dir: dir, env: env}
+	err := r.collectPackages(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	p, err := createProfile()
+	p, err := r.createProfile(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -33,3 +36,13 @@ func ConvertRepository(repo string) (*Report, error) {
 
 	return r, nil
 }
+
+// lookupEnv returns the value of the variable in the environment.
+func lookupEnv(env []string, name string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], name+"=") {
+			return strings.TrimPrefix(env[i], name+"=")
+		}
+	}
+	return ""
+}
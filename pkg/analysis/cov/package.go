@@ -12,10 +12,30 @@ type Package struct {
 	Coverage float64 `json:"coverage"`
 	// LOC contains the number of lines of code for a given package
 	LOC int `json:"loc"`
+	// Files holds the statements coverage of each file of the package.
+	Files []*File `json:"files,omitempty"`
 	// Functions is a list of functions registered with this package.
 	Functions []*Function `json:"-"`
 }
 
+// File describes the statements coverage of a source file
+type File struct {
+	// Path is the path of the file relative to the repository root.
+	Path string `json:"path"`
+	// Blocks lists the ranges of lines spanned by the statements, in order.
+	Blocks []*Block `json:"blocks"`
+}
+
+// Block is a range of consecutive lines of statements sharing the same state
+type Block struct {
+	// Start is the first line of the range.
+	Start int `json:"start"`
+	// End is the last line of the range.
+	End int `json:"end"`
+	// Covered tells whether the statements were reached by the tests.
+	Covered bool `json:"covered"`
+}
+
 // Accumulate will accumulate the coverage information from the provided
 // Package into this Package.
 func (p *Package) Accumulate(p2 *Package) error {
@@ -28,6 +48,9 @@ func (p *Package) Accumulate(p2 *Package) error {
 	if p.Path != p2.Path {
 		p.Path = p2.Path
 	}
+	if len(p.Files) == 0 {
+		p.Files = p2.Files
+	}
 	if len(p.Functions) != len(p2.Functions) {
 		return fmt.Errorf("Function counts do not match: %d != %d", len(p.Functions), len(p2.Functions))
 	}
@@ -2,10 +2,10 @@ package cov
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"regexp"
 	"runtime"
 	"sync"
@@ -20,7 +20,7 @@ const (
 // processPackage executes go test command with coverage and outputs
 // errors and output into channels so they are combined later in a single
 // file and passed to cov for getting the expected JSON output
-func processPackage(rel string) (string, error) {
+func (r *Report) processPackage(ctx context.Context, rel string) (string, error) {
 	// Create temporary file to output the file coverage
 	// this file is trashed after processing
 	tmp, err := ioutil.TempFile("", "")
@@ -30,7 +30,7 @@ func processPackage(rel string) (string, error) {
 	defer os.Remove(tmp.Name())
 
 	logrus.Debugf("go test -covermode=%s -coverprofile=%s %s", coverMode, tmp.Name(), rel)
-	_, err = exec.Command("go", "test", "-covermode="+coverMode, "-coverprofile="+tmp.Name(), rel).CombinedOutput()
+	_, err = r.command(ctx, "go", "test", "-covermode="+coverMode, "-coverprofile="+tmp.Name(), rel).CombinedOutput()
 	if err != nil {
 		return "", nil
 	}
@@ -47,10 +47,10 @@ func processPackage(rel string) (string, error) {
 // lookupTestFiles crawls the filesystem from the repository path
 // and finds test files using glob, if a package doesn't have tests
 // it is automatically skipped.
-func createProfile() (*os.File, error) {
-	pkgs, err := packageList("ImportPath")
-	if err != nil {
-		return nil, err
+func (r *Report) createProfile(ctx context.Context) (*os.File, error) {
+	var pkgs []string
+	for path := range r.packages {
+		pkgs = append(pkgs, path)
 	}
 
 	file, err := ioutil.TempFile("", "hotolab-coverage")
@@ -71,7 +71,7 @@ func createProfile() (*os.File, error) {
 		wg.Add(1)
 		go func() {
 			for pkg := range tasks {
-				res, err := processPackage(pkg)
+				res, err := r.processPackage(ctx, pkg)
 				if err != nil {
 					errBuff.WriteString(err.Error())
 					return
@@ -2,8 +2,12 @@ package cov
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"go/build"
 	"go/parser"
 	"go/token"
 	"io"
@@ -12,6 +16,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/jgautheron/exago/pkg/analysis/proc"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/tools/cover"
 	"simonwaldherr.de/go/golibs/xmath"
@@ -23,11 +28,42 @@ type Report struct {
 	Packages []*Package `json:"packages"`
 	// Coverage
 	Coverage float64 `json:"coverage"`
+
+	// repository is the import path of the analyzed repository
+	repository string
+	// dir is the directory of the repository, where the go commands run
+	dir string
+	// env is the environment of the go commands, holding the GOPATH
+	env []string
+	// packages are the packages of the repository, by import path
+	packages map[string]listedPackage
+}
+
+// command prepares a command run in the repository directory,
+// killed along with its children once the context is done.
+func (r *Report) command(ctx context.Context, name string, args ...string) *proc.Cmd {
+	cmd := proc.Command(ctx, name, args...)
+	cmd.Dir = r.dir
+	cmd.Env = r.env
+	return cmd
+}
+
+// buildContext resolves the packages in the GOPATH of the environment.
+func (r *Report) buildContext() build.Context {
+	ctx := build.Default
+	if gopath := lookupEnv(r.env, "GOPATH"); gopath != "" {
+		ctx.GOPATH = gopath
+	}
+	return ctx
 }
 
 func (r *Report) parseProfile(profiles []*cover.Profile) error {
 	conv := converter{
-		packages: make(map[string]*Package),
+		repository: r.repository,
+		build:      r.buildContext(),
+		srcDir:     r.dir,
+		listed:     r.packages,
+		packages:   make(map[string]*Package),
 	}
 	for _, p := range profiles {
 		if err := conv.convertProfile(p); err != nil {
@@ -42,15 +78,18 @@ func (r *Report) parseProfile(profiles []*cover.Profile) error {
 }
 
 // collectPackages collects ALL packages
-func (r *Report) collectPackages() error {
+func (r *Report) collectPackages(ctx context.Context) error {
 	set := token.NewFileSet()
-	dirs, err := packageList("Dir")
+	listed, err := r.listPackages(ctx)
 	if err != nil {
 		return err
 	}
 
+	r.packages = make(map[string]listedPackage, len(listed))
 	var errs []string
-	for _, dir := range dirs {
+	for _, l := range listed {
+		r.packages[l.ImportPath] = l
+		dir := l.Dir
 		pkgs, err := parser.ParseDir(set, dir, nil, 0)
 		if err != nil {
 			err := fmt.Sprintf("Directory %s returned error: `%s`", dir, err.Error())
@@ -63,13 +102,10 @@ func (r *Report) collectPackages() error {
 				logrus.Debugf("Ignoring test package `%s`", pkg.Name)
 				continue
 			}
-			// Craft package path
-			path := strings.Replace(dir, os.Getenv("GOPATH")+"/src/", "", 1)
-
-			logrus.Debugf("path %v / package %v", path, pkg.Name)
+			logrus.Debugf("path %v / package %v", l.ImportPath, pkg.Name)
 			p := &Package{
 				Name: pkg.Name,
-				Path: path,
+				Path: l.ImportPath,
 			}
 			// Count LOCs for each file in the package
 			for fn := range pkg.Files {
@@ -184,14 +220,36 @@ func (r *Report) computeGlobalCoverage() {
 	r.Coverage = xmath.Sum(sums) / xmath.Sum(weights)
 }
 
-// packageList returns a list of Go-like files or directories from PWD,
-func packageList(arg string) ([]string, error) {
-	cmd, err := exec.Command("sh", "-c", `go list -f '{{.`+arg+`}}' ./... | grep -v vendor | grep -v Godeps`).CombinedOutput()
+// listedPackage is a package of the repository, as listed by go list.
+type listedPackage struct {
+	ImportPath string
+	Name       string
+	Dir        string
+}
+
+// listPackages lists the packages of the repository, module-aware
+// or in GOPATH mode depending on the environment. The vendored
+// dependencies are left out.
+func (r *Report) listPackages(ctx context.Context) ([]listedPackage, error) {
+	out, err := r.command(ctx, "go", "list", "-json", "./...").Output()
 	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok {
+			return nil, errors.New(string(e.Stderr))
+		}
 		return nil, err
 	}
 
-	pl := strings.Split(strings.TrimSpace(string(cmd)), "\n")
-
-	return pl, nil
+	var pkgs []listedPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			return nil, err
+		}
+		if strings.Contains(p.ImportPath, "/vendor/") || strings.Contains(p.ImportPath, "Godeps") {
+			continue
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
 }
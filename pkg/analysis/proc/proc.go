@@ -0,0 +1,76 @@
+// Package proc runs the commands of the analyses, their whole process tree
+// is killed once their context is done, so that a stuck test or a hanging
+// download cannot outlive the analysis.
+package proc
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+)
+
+// Cmd is an exec.Cmd bound to a context.
+type Cmd struct {
+	*exec.Cmd
+	ctx context.Context
+}
+
+// Command prepares the command, run in its own process group.
+func Command(ctx context.Context, name string, args ...string) *Cmd {
+	cmd := exec.Command(name, args...)
+	setProcessGroup(cmd)
+	return &Cmd{Cmd: cmd, ctx: ctx}
+}
+
+// Run starts the command and waits for it to complete. Once the context
+// is done, the process tree is killed and the error of the context returned.
+func (c *Cmd) Run() error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.Cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			killProcessGroup(c.Process)
+		case <-done:
+		}
+	}()
+	err := c.Cmd.Wait()
+	close(done)
+
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// Output runs the command and returns its standard output,
+// the standard error is kept in the *exec.ExitError if it fails.
+func (c *Cmd) Output() ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	captureErr := c.Stderr == nil
+	if captureErr {
+		c.Stderr = &stderr
+	}
+
+	err := c.Run()
+	if e, ok := err.(*exec.ExitError); ok && captureErr {
+		e.Stderr = stderr.Bytes()
+	}
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs the command and returns its standard output and error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = &out
+	err := c.Run()
+	return out.Bytes(), err
+}
@@ -0,0 +1,52 @@
+package proc_test
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jgautheron/exago/pkg/analysis/proc"
+)
+
+func TestCommand(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	var tests = []struct {
+		script  string
+		timeout time.Duration
+		out     string
+		err     error
+	}{
+		{"echo done", time.Minute, "done", nil},
+		// The child keeps the output open, it must be killed along with the shell
+		{"echo started; sleep 60 & sleep 60", 200 * time.Millisecond, "started", context.DeadlineExceeded},
+		{"echo started; (sleep 60; echo late)", 200 * time.Millisecond, "started", context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		start := time.Now()
+		out, err := proc.Command(ctx, "bash", "-c", tt.script).CombinedOutput()
+		cancel()
+
+		if err != tt.err {
+			t.Errorf("%s: got error %v, expected %v", tt.script, err, tt.err)
+		}
+		if strings.TrimSpace(string(out)) != tt.out {
+			t.Errorf("%s: got output %q", tt.script, out)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("%s: the process tree should be killed, took %s", tt.script, elapsed)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := proc.Command(ctx, "bash", "-c", "echo never").Output(); err != context.Canceled {
+		t.Errorf("Got %v, the command should not start once cancelled", err)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package proc
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a new process group,
+// which its children belong to as well.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the command and every process of its group.
+func killProcessGroup(p *os.Process) {
+	syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,14 @@
+package proc
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op, there are no process groups to kill at once.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills the command, its children are left running.
+func killProcessGroup(p *os.Process) {
+	p.Kill()
+}
@@ -66,13 +66,11 @@ func (ce *checkListEvaluator) Calculate(d exago.Data) *exago.EvaluatorResponse {
 			msg = "check succeeded"
 		}
 		details = append(details, &exago.EvaluatorResponse{
-			n,
-			c.score,
-			c.weight,
-			"",
-			msg,
-			c.url,
-			nil,
+			Name:    n,
+			Score:   c.score,
+			Weight:  c.weight,
+			Message: msg,
+			URL:     c.url,
 		})
 
 		logrus.WithFields(logrus.Fields{
@@ -24,7 +24,15 @@ type Evaluator struct {
 
 // NewResponse creates an EvaluatorResponse instance
 func (c *Evaluator) NewResponse(score float64, weight float64, msg string, details []*exago.EvaluatorResponse) *exago.EvaluatorResponse {
-	return &exago.EvaluatorResponse{c.Name(), score, weight, c.desc, msg, c.url, details}
+	return &exago.EvaluatorResponse{
+		Name:    c.Name(),
+		Score:   score,
+		Weight:  weight,
+		Desc:    c.desc,
+		Message: msg,
+		URL:     c.url,
+		Details: details,
+	}
 }
 
 // Calculate computes the criteria evaluation score
@@ -61,18 +61,12 @@ func (le *lintMessagesEvaluator) Calculate(d exago.Data) *exago.EvaluatorRespons
 	r := le.NewResponse(100, 2, "", nil)
 	lm, cs := d.Results.Linters, d.Results.CodeStats.Data
 
-	// Loop over messages, counting all warnings
-	// @todo improve incoming structure so we avoid these ugly nested loops
-	for _, m := range lm {
-		for ln, lr := range m {
-			if l, ok := le.linters[ln]; ok {
-				l.warnings = 0
-				for _, a := range lr {
-					// Count the number of warnings
-					if a["severity"].(string) == "warning" {
-						l.warnings++
-					}
-				}
+	// Loop over messages, counting all warnings across the files
+	// golangci-lint does not report severities, every message is a warning
+	for _, results := range lm.Data {
+		for _, lr := range results {
+			if l, ok := le.linters[lr.Linter]; ok {
+				l.warnings += float64(len(lr.Messages))
 			}
 		}
 	}
@@ -6,7 +6,6 @@ import (
 	exago "github.com/jgautheron/exago/pkg"
 
 	"github.com/jgautheron/exago/pkg/analysis/score"
-	"github.com/jgautheron/exago/repository/model"
 )
 
 func TestLintMessages(t *testing.T) {
@@ -20,11 +19,12 @@ func TestLintMessages(t *testing.T) {
 		{map[string]int{"gas": 5}, 500, "<", 60, "1 potential security issue every 100 loc"},
 		{map[string]int{"gofmt": 5}, 500, "<", 20, "gofmt is a must-have"},
 		{map[string]int{"golint": 20}, 500, ">", 50, "golint is verbose"},
+		{map[string]int{}, 500, "=", 100, "no message"},
 	}
 
 	for _, tt := range tests {
 		d := exago.Data{}
-		d.Results.Linters = getStubMessages(tt.messages)
+		d.Results.Linters.Data = getStubMessages(tt.messages, "error")
 		d.Results.CodeStats.Data = map[string]int{"loc": tt.loc}
 		evaluator := score.LintMessagesEvaluator()
 		evaluator.Setup()
@@ -47,17 +47,36 @@ func TestLintMessages(t *testing.T) {
 	}
 }
 
-func getStubMessages(messages map[string]int) exago.LinterResults {
+// TestLintMessagesFiles makes sure that the messages of every file are counted.
+func TestLintMessagesFiles(t *testing.T) {
+	single, split := exago.Data{}, exago.Data{}
+	single.Results.Linters.Data = getStubMessages(map[string]int{"gas": 4}, "error")
+	split.Results.Linters.Data = exago.LinterResults{}
+	for _, f := range []string{"foo.go", "bar.go"} {
+		split.Results.Linters.Data[f] = getStubMessages(map[string]int{"gas": 2}, "error")["foo.go"]
+	}
+
+	var scores []float64
+	for _, d := range []exago.Data{single, split} {
+		d.Results.CodeStats.Data = map[string]int{"loc": 500}
+		evaluator := score.LintMessagesEvaluator()
+		evaluator.Setup()
+		scores = append(scores, evaluator.Calculate(d).Score)
+	}
+	if scores[0] != scores[1] || scores[0] == 100 {
+		t.Errorf("Wrong score %.2f for the messages split across files, expected %.2f", scores[1], scores[0])
+	}
+}
+
+func getStubMessages(messages map[string]int, severity string) exago.LinterResults {
 	fileName := "foo.go"
-	m := map[string]map[string][]map[string]interface{}{}
+	m := exago.LinterResults{}
 	for linter, count := range messages {
-		m[fileName] = map[string][]map[string]interface{}{}
-		m[fileName][linter] = []map[string]interface{}{}
+		lr := exago.LinterResult{Linter: linter}
 		for i := 0; i < count; i++ {
-			m[fileName][linter] = append(m[fileName][linter], map[string]interface{}{
-				"severity": "warning",
-			})
+			lr.Messages = append(lr.Messages, exago.LinterMessage{Severity: severity})
 		}
+		m[fileName] = append(m[fileName], lr)
 	}
-	return model.LinterResults(m)
+	return m
 }
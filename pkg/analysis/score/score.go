@@ -44,3 +44,15 @@ func Process(data exago.Data) (score float64, details []*exago.EvaluatorResponse
 
 	return avg, res
 }
+
+// Data gathers the results along with the errors of the runners, then scores
+// and ranks them. The metadata is left to the caller, it is not scored.
+func Data(results exago.Results, errs map[string]string) exago.Data {
+	data := exago.Data{Results: results, Errors: make(map[string]string)}
+	for name, e := range errs {
+		data.Errors[name] = e
+	}
+	data.Score.Value, data.Score.Details = Process(data)
+	data.Score.Rank = Rank(data.Score.Value)
+	return data
+}
@@ -18,6 +18,20 @@ func TestScore(t *testing.T) {
 	}
 }
 
+func TestData(t *testing.T) {
+	d := getStubData(2500, 200, 0.8, 75, 5, []string{"projectBuilds", "isFormatted", "hasReadme", "isDirMatch"})
+	errs := map[string]string{"lint": "timeout"}
+	data := score.Data(d.Results, errs)
+
+	sc, _ := score.Process(d)
+	if data.Score.Value != sc || data.Score.Rank != score.Rank(sc) || len(data.Score.Details) == 0 {
+		t.Errorf("Got score %#v, expected %.2f", data.Score, sc)
+	}
+	if errs["metadata"] = "not found"; len(data.Errors) != 1 || data.Errors["lint"] != "timeout" {
+		t.Errorf("Got errors %v, the errors of the runners should be copied", data.Errors)
+	}
+}
+
 func getStubData(loc int, cloc int, duration, coverage float64, thirdParties int, checklist []string) exago.Data {
 	d := exago.Data{}
 
@@ -26,7 +40,7 @@ func getStubData(loc int, cloc int, duration, coverage float64, thirdParties int
 	pr.ThirdParties.Data = getThirdParties(thirdParties)
 	pr.Checklist.Data = getStubChecklist(checklist)
 	pr.CodeStats.Data = map[string]int{"loc": loc, "cloc": cloc, "test": 123}
-	pr.Linters.Data = getStubMessages(map[string]int{"gas": 3})
+	pr.Linters.Data = getStubMessages(map[string]int{"gas": 3}, "error")
 	d.Results = pr
 
 	return d
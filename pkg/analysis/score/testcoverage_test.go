@@ -40,15 +40,15 @@ func TestCoverage(t *testing.T) {
 		switch tt.operator {
 		case "<":
 			if res.Score > tt.expected {
-				t.Errorf("Wrong score %s: %d is not > to %d", tt.desc, res.Score, tt.expected)
+				t.Errorf("Wrong score %s: %.2f is not > to %.2f", tt.desc, res.Score, tt.expected)
 			}
 		case ">":
 			if res.Score < tt.expected {
-				t.Errorf("Wrong score %s: %d is not < to %d", tt.desc, res.Score, tt.expected)
+				t.Errorf("Wrong score %s: %.2f is not < to %.2f", tt.desc, res.Score, tt.expected)
 			}
 		case "=":
 			if res.Score != tt.expected {
-				t.Errorf("Wrong score %s: %d is not = to %d", tt.desc, res.Score, tt.expected)
+				t.Errorf("Wrong score %s: %.2f is not = to %.2f", tt.desc, res.Score, tt.expected)
 			}
 		}
 	}
@@ -1,6 +1,7 @@
 package score_test
 
 import (
+	"strconv"
 	"testing"
 
 	exago "github.com/jgautheron/exago/pkg"
@@ -49,7 +50,7 @@ func TestThirdParties(t *testing.T) {
 
 func getThirdParties(count int) (tp []string) {
 	for i := 0; i < count; i++ {
-		tp = append(tp, string(i))
+		tp = append(tp, strconv.Itoa(i))
 	}
 	return tp
 }
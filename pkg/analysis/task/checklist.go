@@ -1,6 +1,7 @@
 package task
 
 import (
+	"context"
 	"time"
 
 	exago "github.com/jgautheron/exago/pkg"
@@ -20,11 +21,11 @@ func ChecklistRunner(m *Manager) Runnable {
 }
 
 // Execute checklist
-func (r *checklistRunner) Execute() error {
+func (r *checklistRunner) Execute(ctx context.Context) error {
 	defer r.trackTime(time.Now())
 
-	cl := checklist.New(r.Manager().RepositoryPath())
-	passed, failed := cl.RunTasks()
+	cl := checklist.New(r.Manager().RepositoryPath(), r.Manager().Env())
+	passed, failed := cl.RunTasks(ctx)
 
 	r.Data = exago.Checklist{Failed: failed, Passed: passed}
 
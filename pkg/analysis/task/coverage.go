@@ -1,6 +1,7 @@
 package task
 
 import (
+	"context"
 	"os"
 	"time"
 
@@ -20,9 +21,9 @@ func CoverageRunner(m *Manager) Runnable {
 }
 
 // Execute gets all the coverage files and returns the output
-func (r *coverageRunner) Execute() error {
+func (r *coverageRunner) Execute(ctx context.Context) error {
 	defer r.trackTime(time.Now())
-	rep, err := cov.ConvertRepository(r.Manager().Repository())
+	rep, err := cov.ConvertRepository(ctx, r.Manager().ImportPath(), r.Manager().RepositoryPath(), r.Manager().Env())
 	if err != nil {
 		return err
 	}
@@ -1,13 +1,25 @@
 package task
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+// commitPattern matches full or abbreviated commit SHAs
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// ErrInvalidModulePath is returned by CheckModulePath
+var ErrInvalidModulePath = errors.New("Invalid module path")
+
 type downloadRunner struct {
 	Runner
 }
@@ -20,43 +32,144 @@ func DownloadRunner(m *Manager) Runnable {
 	}
 }
 
-// Execute, downloads a Go repository using the go get command
-// too bad, we can't do this as a library :/
-func (r *downloadRunner) Execute() error {
+// Execute fetches the repository in the workspace at the requested reference,
+// then downloads its dependencies.
+func (r *downloadRunner) Execute(ctx context.Context) error {
 	defer r.trackTime(time.Now())
 
-	// Return early if repository is already in the GOPATH
-	if _, err := os.Stat(r.Manager().RepositoryPath()); err == nil {
-		return r.toRepoDir()
+	if err := r.clone(ctx); err != nil {
+		// If we can't download, stop execution as BreakOnError is true with this runner
+		return err
 	}
+	m := r.Manager()
+	m.resolveCommit(ctx)
 
-	// Go get the package
-	p := []string{"get", "-d", "-t"}
-	rep := r.Manager().Repository()
-	if r.Manager().Reference() != "" {
-		rep += ":" + r.Manager().Reference()
+	out, err := m.downloadDependencies(ctx)
+	r.RawOutput += string(out)
+	return err
+}
+
+// clone fetches the reference in the workspace and checks it out detached,
+// so that the source analyzed does not depend on the branches moving meanwhile.
+// The branches, tags and full commit SHAs are fetched alone, the whole history
+// is needed to find an abbreviated SHA.
+func (r *downloadRunner) clone(ctx context.Context) error {
+	m := r.Manager()
+	if err := os.MkdirAll(m.RepositoryPath(), 0755); err != nil {
+		return errors.Wrap(err, "Could not create the repository directory")
 	}
-	p = append(p, rep+"/...")
 
-	os.Setenv("GO111MODULE", "off")
-	out, err := exec.Command("go", p...).CombinedOutput()
-	if err != nil {
-		// If we can't download, stop execution as BreakOnError is true with this runner
-		return errors.Wrap(err, string(out))
+	ref := m.Reference()
+	if ref == "" {
+		ref = "HEAD"
 	}
+	if err := r.git(ctx, "init", "--quiet"); err != nil {
+		return err
+	}
+	if err := r.git(ctx, "remote", "add", "origin", m.CloneURL()); err != nil {
+		return err
+	}
+	if err := r.git(ctx, "fetch", "--quiet", "--depth", "1", "origin", ref); err != nil {
+		if !commitPattern.MatchString(ref) {
+			return err
+		}
+		if err := r.git(ctx, "fetch", "--quiet", "origin"); err != nil {
+			return err
+		}
+		return r.git(ctx, "checkout", "--quiet", "--detach", ref)
+	}
+	return r.git(ctx, "checkout", "--quiet", "--detach", "FETCH_HEAD")
+}
 
-	r.RawOutput = string(out)
+// git runs the git command in the repository directory, keeping its output.
+func (r *downloadRunner) git(ctx context.Context, args ...string) error {
+	out, err := r.Manager().command(ctx, "git", args...).CombinedOutput()
+	r.RawOutput += string(out)
+	if err != nil {
+		return errors.Wrapf(err, "git %s: %s", args[0], out)
+	}
+	return nil
+}
 
-	// cd into repository
-	return r.toRepoDir()
+// resolveCommit records the SHA and the date of the commit checked out,
+// they are left empty if the repository is not versioned with git.
+func (m *Manager) resolveCommit(ctx context.Context) {
+	out, err := m.command(ctx, "git", "log", "-1", "--format=%H %cI").Output()
+	if err != nil {
+		return
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return
+	}
+	m.commit = fields[0]
+	m.commitDate, _ = time.Parse(time.RFC3339, fields[1])
 }
 
-func (r *downloadRunner) toRepoDir() error {
-	// Change directory
-	err := os.Chdir(r.Manager().RepositoryPath())
+// downloadDependencies switches to module mode if the repository has a go.mod,
+// then downloads the dependencies: through the module proxy in module mode,
+// with go get in GOPATH mode otherwise.
+func (m *Manager) downloadDependencies(ctx context.Context) ([]byte, error) {
+	gomod, err := ioutil.ReadFile(filepath.Join(m.RepositoryPath(), "go.mod"))
+	if err == nil {
+		m.modules = true
+		m.importPath = ModulePath(gomod)
+	}
+
+	// Both download the test dependencies as well
+	args := []string{"get", "-d", "-t", "./..."}
+	if m.modules {
+		args = []string{"mod", "download"}
+	}
+	out, err := m.command(ctx, "go", args...).CombinedOutput()
 	if err != nil {
-		return err
+		return out, errors.Wrap(err, string(out))
 	}
+	return out, nil
+}
 
+// ModulePath returns the path declared by the module directive of go.mod.
+func ModulePath(gomod []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(gomod))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// CheckModulePath follows the rules of golang.org/x/mod/module.CheckPath:
+// the path is made of non-empty elements separated by slashes, the first
+// being a lowercase domain name, with neither . nor .. among them, so that
+// it can be joined to a directory without escaping it.
+func CheckModulePath(path string) error {
+	elems := strings.Split(path, "/")
+	for i, elem := range elems {
+		if elem == "" || elem == "." || elem == ".." || elem[0] == '.' || elem[len(elem)-1] == '.' {
+			return ErrInvalidModulePath
+		}
+		for _, r := range elem {
+			if !isModulePathChar(r, i == 0) {
+				return ErrInvalidModulePath
+			}
+		}
+	}
+	if first := elems[0]; !strings.Contains(first, ".") || first[0] == '-' {
+		return ErrInvalidModulePath
+	}
 	return nil
 }
+
+// isModulePathChar tells whether the rune is allowed in an element
+// of a module path, the first one being restricted to a domain name
+func isModulePathChar(r rune, first bool) bool {
+	switch {
+	case 'a' <= r && r <= 'z', '0' <= r && r <= '9', r == '-', r == '.':
+		return true
+	case first:
+		return false
+	}
+	return 'A' <= r && r <= 'Z' || r == '_' || r == '~'
+}
@@ -0,0 +1,282 @@
+package task_test
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+)
+
+const depModule = "example.com/acme/dep"
+
+var depFiles = map[string]string{
+	"go.mod": "module " + depModule + "\n",
+	"dep.go": "package dep\n\n// Answer is the answer\nfunc Answer() int { return 42 }\n",
+}
+
+func TestDownloadModules(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Builds the repository with an empty cache")
+	}
+	for _, bin := range []string{"git", "go"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "exago-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	proxy := writeProxy(t, filepath.Join(tmp, "proxy"), depModule, "v1.0.0", depFiles)
+	repo := filepath.Join(tmp, "repo")
+	git(t, "", "init", "--quiet", repo)
+	writeFiles(t, repo, map[string]string{
+		"go.mod": "module github.com/foo/bar\n\ngo 1.13\n\nrequire " + depModule + " v1.0.0\n",
+		"go.sum": goSum(depModule, "v1.0.0", depFiles),
+		"bar.go": "package bar\n\nimport \"" + depModule + "\"\n\n// Answer is the answer\nfunc Answer() int { return dep.Answer() }\n",
+	})
+	git(t, repo, "add", ".")
+	git(t, repo, "commit", "--quiet", "-m", "Initial commit")
+	git(t, repo, "checkout", "--quiet", "-b", "develop")
+	writeFiles(t, repo, map[string]string{
+		"bar_test.go": "package bar\n\nimport \"testing\"\n\nfunc TestAnswer(t *testing.T) {\n\tif Answer() != 42 {\n\t\tt.Fail()\n\t}\n}\n",
+	})
+	git(t, repo, "add", ".")
+	git(t, repo, "commit", "--quiet", "-m", "Add tests")
+	head := strings.TrimSpace(git(t, repo, "rev-parse", "HEAD"))
+
+	m := task.NewManager("github.com/foo/bar")
+	defer m.Cleanup()
+	m.UseCloneURL("file://" + repo)
+	m.UseReference("develop")
+	m.UseGoProxy("file://"+proxy, "off")
+	m.Runners = map[string]task.Runnable{
+		"download":     task.DownloadRunner(m),
+		"thirdparties": task.ThirdPartiesRunner(m),
+		"test":         task.TestRunner(m),
+		"coverage":     task.CoverageRunner(m),
+	}
+	m.ExecuteRunners(context.Background())
+	if !m.Success {
+		t.Fatalf("The analysis should succeed, got %v", m.Errors)
+	}
+
+	if !m.Modules() || m.ImportPath() != "github.com/foo/bar" {
+		t.Error("The repository should be analyzed in module mode")
+	}
+	if m.Commit() != head {
+		t.Errorf("Got commit %s, expected the head of the branch %s", m.Commit(), head)
+	}
+	res, err := m.Results()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.ThirdParties.Data) != 1 || res.ThirdParties.Data[0] != depModule {
+		t.Errorf("Got third parties %v, expected the module dependency", res.ThirdParties.Data)
+	}
+	if len(res.Test.Data) != 1 || !passed(res.Test.Data[0]) {
+		t.Errorf("The tests of the branch should pass, got %#v", res.Test.Data)
+	}
+	if pkgs := res.Coverage.Data.Packages; len(pkgs) != 1 || pkgs[0].Path != "github.com/foo/bar" || pkgs[0].Coverage != 100 {
+		t.Errorf("The module should be fully covered, got %#v", pkgs)
+	}
+}
+
+func TestDownloadReference(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo, err := ioutil.TempDir("", "exago-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+
+	// master <- v1.0.0 <- develop, master being the default branch
+	git(t, repo, "init", "--quiet")
+	git(t, repo, "symbolic-ref", "HEAD", "refs/heads/master")
+	commits := map[string]string{}
+	for _, version := range []string{"master", "v1.0.0", "develop"} {
+		writeFiles(t, repo, map[string]string{
+			"go.mod":     "module github.com/foo/bar\n",
+			"version.go": "package bar\n\nconst version = \"" + version + "\"\n",
+		})
+		git(t, repo, "add", ".")
+		git(t, repo, "commit", "--quiet", "-m", version)
+		commits[version] = strings.TrimSpace(git(t, repo, "rev-parse", "HEAD"))
+		if version == "v1.0.0" {
+			git(t, repo, "tag", "-a", version, "-m", version)
+			git(t, repo, "checkout", "--quiet", "-b", "develop")
+		}
+		if version == "master" {
+			git(t, repo, "checkout", "--quiet", "-b", "release")
+		}
+	}
+	git(t, repo, "checkout", "--quiet", "master")
+
+	var tests = []struct {
+		reference string
+		expected  string
+	}{
+		{"", commits["master"]},
+		{"develop", commits["develop"]},
+		{"v1.0.0", commits["v1.0.0"]},
+		{commits["v1.0.0"], commits["v1.0.0"]},
+		{commits["v1.0.0"][:7], commits["v1.0.0"]},
+	}
+
+	for _, tt := range tests {
+		m := task.NewManager("github.com/foo/bar")
+		m.UseCloneURL("file://" + repo)
+		m.UseReference(tt.reference)
+		m.Runners = map[string]task.Runnable{"download": task.DownloadRunner(m)}
+		if err := m.Download(context.Background()); err != nil {
+			t.Errorf("%q: %v", tt.reference, err)
+			m.Cleanup()
+			continue
+		}
+
+		if m.Commit() != tt.expected || !m.CommitDate().Equal(commitDate) {
+			t.Errorf("%q: got commit %s of %s, expected %s of %s", tt.reference, m.Commit(), m.CommitDate(), tt.expected, commitDate)
+		}
+		m.Cleanup()
+	}
+
+	m := task.NewManager("github.com/foo/bar")
+	defer m.Cleanup()
+	m.UseCloneURL("file://" + repo)
+	m.UseReference("unknown")
+	m.Runners = map[string]task.Runnable{"download": task.DownloadRunner(m)}
+	if err := m.Download(context.Background()); err == nil {
+		t.Error("An unknown reference should fail the download")
+	}
+}
+
+func TestCheckModulePath(t *testing.T) {
+	var tests = []struct {
+		path  string
+		valid bool
+	}{
+		{"example.com/private/baz", true},
+		{"github.com/Foo/bar_baz.v2", true},
+		{"", false},
+		{"baz", false},
+		{"../../../../home/app/x", false},
+		{"example.com/../../x", false},
+		{"example.com/./x", false},
+		{"/example.com/x", false},
+		{"example.com//x", false},
+		{"example.com/x/", false},
+		{"Example.com/x", false},
+		{"example.com/x y", false},
+		{`example.com\..\x`, false},
+	}
+
+	for _, tt := range tests {
+		if err := task.CheckModulePath(tt.path); (err == nil) != tt.valid {
+			t.Errorf("%q: got %v", tt.path, err)
+		}
+	}
+}
+
+func passed(p exago.TestPackage) bool {
+	return p.Success && len(p.Tests) == 1 && p.Tests[0].Passed
+}
+
+// commitDate is the date of the commits of the test repositories
+var commitDate = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func git(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=exago", "-c", "user.email=exago@example.com"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_DATE="+commitDate.Format(time.RFC3339),
+		"GIT_COMMITTER_DATE="+commitDate.Format(time.RFC3339),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeProxy lays out a single module version the way GOPROXY=file:// expects it.
+func writeProxy(t *testing.T, root, module, version string, files map[string]string) string {
+	dir := filepath.Join(root, module, "@v")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{
+		"list":            version + "\n",
+		version + ".info": `{"Version":"` + version + `","Time":"2020-01-01T00:00:00Z"}`,
+		version + ".mod":  files["go.mod"],
+	})
+
+	f, err := os.Create(filepath.Join(dir, version+".zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := z.Create(module + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// goSum returns the go.sum lines of the module version.
+func goSum(module, version string, files map[string]string) string {
+	zipped := map[string]string{}
+	for name, content := range files {
+		zipped[module+"@"+version+"/"+name] = content
+	}
+	return fmt.Sprintf("%s %s %s\n%s %s/go.mod %s\n",
+		module, version, hash1(zipped),
+		module, version, hash1(map[string]string{"go.mod": files["go.mod"]}))
+}
+
+// hash1 computes the h1: hash of the files, as the go command does.
+func hash1(files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	summary := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(summary, "%x  %s\n", sha256.Sum256([]byte(files[name])), name)
+	}
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil))
+}
@@ -1,9 +1,8 @@
 package task
 
 import (
+	"context"
 	"encoding/json"
-	"os"
-	"os/exec"
 	"time"
 
 	exago "github.com/jgautheron/exago/pkg"
@@ -42,19 +41,12 @@ func LintRunner(m *Manager) Runnable {
 }
 
 // Execute runs linters for files using golangci-lint
-func (r *lintRunner) Execute() error {
+func (r *lintRunner) Execute(ctx context.Context) error {
 	defer r.trackTime(time.Now())
 
-	// Run linter
-	p := []string{"run", "--out-format=json", "--issues-exit-code=0"}
-	rep := r.Manager().RepositoryPath()
-	if r.Manager().Reference() != "" {
-		rep += ":" + r.Manager().Reference()
-	}
-	p = append(p, rep+"/...")
-
-	os.Setenv("GO111MODULE", "off")
-	out, err := exec.Command("golangci-lint", p...).CombinedOutput()
+	// Run linter, from the repository directory so that the module is found
+	p := []string{"run", "--out-format=json", "--issues-exit-code=0", "./..."}
+	out, err := r.Manager().command(ctx, "golangci-lint", p...).CombinedOutput()
 
 	if err != nil {
 		// If we cannot run linter return with error
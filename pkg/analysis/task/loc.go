@@ -1,6 +1,7 @@
 package task
 
 import (
+	"context"
 	"time"
 
 	"github.com/jgautheron/golocc"
@@ -20,8 +21,8 @@ func LocRunner(m *Manager) Runnable {
 	}
 }
 
-// Execute calls the golocc library
-func (r *locRunner) Execute() error {
+// Execute calls the golocc library, which cannot be interrupted
+func (r *locRunner) Execute(ctx context.Context) error {
 	defer r.trackTime(time.Now())
 
 	parser := golocc.New(r.Manager().RepositoryPath(), ignore, true)
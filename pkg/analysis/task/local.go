@@ -0,0 +1,101 @@
+package task
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type localRunner struct {
+	Runner
+	path string
+}
+
+// LocalRunner is a runner used in place of the download runner for the
+// projects found on the local filesystem, e.g. private repositories checked
+// out in CI or extracted from an archive.
+func LocalRunner(m *Manager, path string) Runnable {
+	return &localRunner{
+		Runner: Runner{Label: "Local Copy", Mgr: m},
+		path:   path,
+	}
+}
+
+// Execute copies the project in the workspace, so that the analysis leaves
+// the original untouched, then downloads its dependencies.
+func (r *localRunner) Execute(ctx context.Context) error {
+	defer r.trackTime(time.Now())
+
+	m := r.Manager()
+	if err := copyTree(ctx, r.path, m.RepositoryPath()); err != nil {
+		return errors.Wrapf(err, "Could not copy %s", r.path)
+	}
+	m.resolveCommit(ctx)
+
+	out, err := m.downloadDependencies(ctx)
+	r.RawOutput += string(out)
+	return err
+}
+
+// copyTree copies the directory recursively, keeping the permissions
+// of the files and the symbolic links as is, until the context is done.
+func copyTree(ctx context.Context, src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("Not a directory")
+	}
+
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			return os.MkdirAll(target, mode.Perm()|0700)
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case mode.IsRegular():
+			return copyFile(path, target, mode.Perm())
+		}
+		// Sockets, devices and named pipes have no place in a Go project
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,87 @@
+package task_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jgautheron/exago/pkg/analysis/task"
+)
+
+func TestLocalPath(t *testing.T) {
+	for _, bin := range []string{"git", "go"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	tmp, err := ioutil.TempDir("", "exago-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	git(t, "", "init", "--quiet", src)
+	if err := os.Mkdir(filepath.Join(src, "cmd"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, src, map[string]string{
+		"go.mod":     "module example.com/private/baz\n\ngo 1.13\n",
+		"baz.go":     "package baz\n\n// Answer is the answer\nfunc Answer() int { return 42 }\n",
+		"cmd/baz.go": "package main\n\nfunc main() {}\n",
+	})
+	git(t, src, "add", ".")
+	git(t, src, "commit", "--quiet", "-m", "Initial commit")
+	head := strings.TrimSpace(git(t, src, "rev-parse", "HEAD"))
+
+	var tests = []struct {
+		path    string
+		success bool
+	}{
+		{src, true},
+		{filepath.Join(tmp, "missing"), false},
+		{filepath.Join(src, "go.mod"), false},
+	}
+
+	for _, tt := range tests {
+		m := task.NewManager("baz")
+		m.UseLocalPath(tt.path)
+		m.UseGoProxy("off", "off")
+		err := m.Download(context.Background())
+		if (err == nil) != tt.success {
+			t.Errorf("%s: got %v", tt.path, err)
+			m.Cleanup()
+			continue
+		}
+		if !tt.success {
+			if m.Errors["download"] == "" {
+				t.Errorf("%s: the error should be kept", tt.path)
+			}
+			m.Cleanup()
+			continue
+		}
+
+		if !m.Modules() || m.ImportPath() != "example.com/private/baz" {
+			t.Errorf("%s: the project should be analyzed in module mode", tt.path)
+		}
+		if m.Commit() != head || m.CommitDate().IsZero() {
+			t.Errorf("%s: got commit %s at %s, expected %s", tt.path, m.Commit(), m.CommitDate(), head)
+		}
+		if !strings.HasPrefix(m.RepositoryPath(), m.Workspace().Root) {
+			t.Errorf("%s: the project should be copied in the workspace, got %s", tt.path, m.RepositoryPath())
+		}
+		if _, err := os.Stat(filepath.Join(m.RepositoryPath(), "cmd", "baz.go")); err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+		}
+		m.Cleanup()
+	}
+
+	if status := git(t, src, "status", "--porcelain"); status != "" {
+		t.Errorf("The local project should be left untouched, got %s", status)
+	}
+}
@@ -1,6 +1,9 @@
 package task
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 const (
 	downloadName     = "download"
@@ -37,7 +40,7 @@ type Runner struct {
 // Runnable interface
 type Runnable interface {
 	Name() string
-	Execute() error
+	Execute(ctx context.Context) error
 	Manager() *Manager
 }
 
@@ -52,7 +55,7 @@ func (r *Runner) Name() string {
 }
 
 // Execute launches the runner
-func (r *Runner) Execute() {
+func (r *Runner) Execute(ctx context.Context) {
 }
 
 // trackTime measures time elapsed given the time passed to the func
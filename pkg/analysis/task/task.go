@@ -1,12 +1,46 @@
 package task
 
 import (
-	"fmt"
-	"os"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/jgautheron/exago/pkg/analysis/proc"
 )
 
+// resultFields maps the runners whose name differs from their field in exago.Results
+var resultFields = map[string]string{
+	locName:  "codeStats",
+	lintName: "linters",
+}
+
+// Progress is reported each time a runner starts or finishes
+type Progress struct {
+	// Runner is the name of the runner (e.g. lint)
+	Runner string
+	// Label is the human readable name of the runner
+	Label string
+	// Finished is false when the runner starts
+	Finished bool
+	// ExecutionTime is the time the runner took to complete
+	ExecutionTime time.Duration
+	// Err is the error returned by the runner, if any
+	Err error
+}
+
+// ProgressFunc receives the progress of the runners, it is called
+// concurrently by the analysis runners.
+type ProgressFunc func(p Progress)
+
+// ErrRepositoryPath is returned by Download if the repository would be
+// downloaded outside the workspace, e.g. a module path holding ..
+var ErrRepositoryPath = errors.New("The repository path escapes the workspace")
+
 // Manager contains all registered runnables
 type Manager struct {
 	Success bool                `json:"success"`
@@ -16,6 +50,23 @@ type Manager struct {
 	repository     string
 	repositoryPath string
 	reference      string
+	commit         string
+	commitDate     time.Time
+	progress       ProgressFunc
+	// workspace is created by Download, every command runs in it
+	workspace *Workspace
+	// cloneURL is where the repository is cloned from, https://<repository> by default
+	cloneURL string
+	// goProxy and goSumDB override GOPROXY and GOSUMDB in module mode
+	goProxy string
+	goSumDB string
+	// modules is set once downloaded if the repository has a go.mod,
+	// importPath being then the path of the module
+	modules    bool
+	importPath string
+	// timeouts bound the runners, deadline is that of the whole analysis once started
+	timeouts Timeouts
+	deadline time.Time
 }
 
 // NewManager instantiates a runnable manager
@@ -23,9 +74,8 @@ type Manager struct {
 // and decide whether a runner should run in parallel processing or not
 func NewManager(r string) *Manager {
 	m := &Manager{
-		repository:     r,
-		repositoryPath: fmt.Sprintf("%s/src/%s", os.Getenv("GOPATH"), r),
-		Errors:         make(map[string]string),
+		repository: r,
+		Errors:     make(map[string]string),
 	}
 
 	if strings.TrimSpace(r) == "" {
@@ -46,40 +96,191 @@ func NewManager(r string) *Manager {
 	return m
 }
 
-// UseReference sets reference flag
+// UseReference sets the branch, tag or commit to analyze, the default branch if empty
 func (m *Manager) UseReference(r string) {
 	m.reference = r
 }
 
+// UseLocalPath analyzes the project found in the directory rather than
+// downloading the repository, which then only names the project (its import
+// path in GOPATH mode). The directory is copied in the workspace
+func (m *Manager) UseLocalPath(dir string) {
+	if m.Runners == nil {
+		return
+	}
+	m.Runners[downloadName] = LocalRunner(m, dir)
+}
+
+// UseCloneURL sets where the repository is cloned from, e.g. a local mirror
+func (m *Manager) UseCloneURL(url string) {
+	m.cloneURL = url
+}
+
+// UseGoProxy sets the GOPROXY and GOSUMDB the dependencies of modules are
+// downloaded through (e.g. file:///var/cache/goproxy and off to run offline),
+// the environment of the process is used if empty
+func (m *Manager) UseGoProxy(proxy, sumdb string) {
+	m.goProxy = proxy
+	m.goSumDB = sumdb
+}
+
+// UseTimeouts bounds the duration of the analysis and of each runner,
+// the runners still running past them are interrupted
+func (m *Manager) UseTimeouts(t Timeouts) {
+	m.timeouts = t
+}
+
+// OnProgress registers the function notified when a runner starts or finishes
+func (m *Manager) OnProgress(fn ProgressFunc) {
+	m.progress = fn
+}
+
 // Reference returns reference
 func (m *Manager) Reference() string {
 	return m.reference
 }
 
-// RepositoryPath returns repository path
+// Commit returns the SHA of the commit analyzed, once downloaded
+func (m *Manager) Commit() string {
+	return m.commit
+}
+
+// CommitDate returns the date of the commit analyzed, once downloaded
+func (m *Manager) CommitDate() time.Time {
+	return m.commitDate
+}
+
+// RepositoryPath returns repository path, in the workspace
 func (m *Manager) RepositoryPath() string {
 	return m.repositoryPath
 }
 
+// CloneURL returns the URL the repository is cloned from
+func (m *Manager) CloneURL() string {
+	if m.cloneURL != "" {
+		return m.cloneURL
+	}
+	return "https://" + m.repository
+}
+
+// Modules tells whether the repository is analyzed in module mode, once downloaded
+func (m *Manager) Modules() bool {
+	return m.modules
+}
+
+// ImportPath returns the import path of the repository root,
+// the module path in module mode and the repository otherwise
+func (m *Manager) ImportPath() string {
+	if m.importPath != "" {
+		return m.importPath
+	}
+	return m.repository
+}
+
+// Workspace returns the workspace of the analysis, nil until downloaded
+func (m *Manager) Workspace() *Workspace {
+	return m.workspace
+}
+
+// Env returns the environment of the commands run on the repository,
+// module-aware if the repository has a go.mod and in GOPATH mode otherwise
+func (m *Manager) Env() []string {
+	// Never wait for credentials, the private repositories cannot be analyzed anyway
+	env := append(m.workspace.Env(), "GIT_TERMINAL_PROMPT=0")
+	if !m.modules {
+		return append(env, "GO111MODULE=off")
+	}
+
+	env = append(env, "GO111MODULE=on")
+	if m.goProxy != "" {
+		env = append(env, "GOPROXY="+m.goProxy)
+	}
+	if m.goSumDB != "" {
+		env = append(env, "GOSUMDB="+m.goSumDB)
+	}
+	return env
+}
+
+// Cleanup removes the workspace, once the results are no longer needed
+func (m *Manager) Cleanup() error {
+	if m.workspace == nil {
+		return nil
+	}
+	err := m.workspace.Remove()
+	m.workspace = nil
+	return err
+}
+
+// command prepares a command run in the repository directory of the workspace,
+// its process tree is killed once the context is done
+func (m *Manager) command(ctx context.Context, name string, args ...string) *proc.Cmd {
+	cmd := proc.Command(ctx, name, args...)
+	cmd.Dir = m.repositoryPath
+	cmd.Env = m.Env()
+	return cmd
+}
+
 // Repository returns repository (e.g. :vcs/:owner/:package+)
 func (m *Manager) Repository() string {
 	return m.repository
 }
 
-// ExecuteRunners launches the runners
-func (m *Manager) ExecuteRunners() *Manager {
-	// Execute download runner synchronously
-	dlr := m.Runners[downloadName]
-	// Execute synchronously
-	err := dlr.Execute()
+// ExecuteRunners downloads the repository and launches the analysis runners,
+// until the context is cancelled
+func (m *Manager) ExecuteRunners(ctx context.Context) *Manager {
+	if err := m.Download(ctx); err != nil {
+		return m
+	}
+	return m.Analyze(ctx)
+}
+
+// Download creates the workspace and executes the download runner
+// synchronously, the analysis cannot start if it fails.
+// The workspace is kept until Cleanup is called, even if it failed.
+// A *TimeoutError or ErrCancelled is returned if it was interrupted
+func (m *Manager) Download(ctx context.Context) error {
+	dlr, ok := m.Runners[downloadName]
+	if !ok {
+		return errors.New(m.Errors[downloadName])
+	}
+
+	if m.workspace == nil {
+		ws, err := NewWorkspace()
+		if err != nil {
+			m.Errors[downloadName] = err.Error()
+			m.Runners = nil
+			return err
+		}
+		m.workspace = ws
+		m.repositoryPath = filepath.Join(ws.GOPATH(), "src", m.repository)
+	}
+	// The repository names a directory of the workspace, nothing may be written outside
+	if rel, err := filepath.Rel(m.workspace.Root, m.repositoryPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		m.Errors[downloadName] = ErrRepositoryPath.Error()
+		m.Runners = nil
+		return ErrRepositoryPath
+	}
+
+	m.start()
+	err := m.execute(ctx, downloadName, dlr)
 	// Exit early if we can't download
 	if err != nil {
 		m.Errors[downloadName] = err.Error()
 		m.Runners = nil
-		return m
+		return err
 	}
+	return nil
+}
 
-	var wg sync.WaitGroup
+// Analyze launches the analysis runners concurrently,
+// the repository must have been downloaded beforehand.
+// The runners that failed are dropped, their error is kept in Errors
+func (m *Manager) Analyze(ctx context.Context) *Manager {
+	m.start()
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
 	for n, ru := range m.Runners {
 		// Skip download runner
 		if n == downloadName {
@@ -91,9 +292,11 @@ func (m *Manager) ExecuteRunners() *Manager {
 			// Decrement the counter when the goroutine completes.
 			defer wg.Done()
 			// Execute the runner
-			err := r.Execute()
+			err := m.execute(ctx, name, r)
 			if err != nil {
+				mu.Lock()
 				m.Errors[name] = err.Error()
+				mu.Unlock()
 			}
 		}(ru, n)
 	}
@@ -101,11 +304,89 @@ func (m *Manager) ExecuteRunners() *Manager {
 	// Wait for all runners to complete.
 	wg.Wait()
 
-	if len(m.Errors) == 0 {
-		m.Success = true
-	} else {
-		m.Runners = nil
+	for name := range m.Errors {
+		delete(m.Runners, name)
 	}
+	m.Success = len(m.Errors) == 0
 
 	return m
 }
+
+// Results converts the data of the runners into exago.Results,
+// the fields of the runners that failed are left empty.
+func (m *Manager) Results() (exago.Results, error) {
+	runners := make(map[string]Runnable, len(m.Runners))
+	for name, r := range m.Runners {
+		if field, ok := resultFields[name]; ok {
+			name = field
+		}
+		runners[name] = r
+	}
+
+	var res exago.Results
+	b, err := json.Marshal(runners)
+	if err != nil {
+		return res, err
+	}
+	err = json.Unmarshal(b, &res)
+	return res, err
+}
+
+// start sets the deadline of the analysis, the first time it is called
+func (m *Manager) start() {
+	if m.deadline.IsZero() && m.timeouts.Analysis > 0 {
+		m.deadline = time.Now().Add(m.timeouts.Analysis)
+	}
+}
+
+// execute runs the runner, reporting its progress
+func (m *Manager) execute(ctx context.Context, name string, r Runnable) error {
+	if m.progress == nil {
+		return m.run(ctx, name, r)
+	}
+
+	m.progress(Progress{Runner: name, Label: r.Name()})
+	start := time.Now()
+	err := m.run(ctx, name, r)
+	m.progress(Progress{
+		Runner:        name,
+		Label:         r.Name(),
+		Finished:      true,
+		ExecutionTime: time.Since(start),
+		Err:           err,
+	})
+	return err
+}
+
+// run executes the runner within its own timeout and the deadline of the analysis,
+// whichever comes first. Its error is replaced if it was interrupted, the results
+// of a command killed midway are not to be trusted
+func (m *Manager) run(ctx context.Context, name string, r Runnable) error {
+	limit := m.timeouts.forRunner(name)
+	var deadline time.Time
+	if limit > 0 {
+		deadline = time.Now().Add(limit)
+	}
+	analysis := !m.deadline.IsZero() && (deadline.IsZero() || m.deadline.Before(deadline))
+	if analysis {
+		deadline = m.deadline
+	}
+
+	rctx := ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	err := r.Execute(rctx)
+	switch {
+	case ctx.Err() != nil:
+		return ErrCancelled
+	case rctx.Err() == context.DeadlineExceeded && analysis:
+		return &TimeoutError{Runner: name, Limit: m.timeouts.Analysis, Analysis: true}
+	case rctx.Err() == context.DeadlineExceeded:
+		return &TimeoutError{Runner: name, Limit: limit}
+	}
+	return err
+}
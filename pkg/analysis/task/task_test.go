@@ -0,0 +1,228 @@
+package task_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	exago "github.com/jgautheron/exago/pkg"
+	"github.com/jgautheron/exago/pkg/analysis/task"
+)
+
+type stubRunner struct {
+	task.Runner
+	err error
+}
+
+func (r *stubRunner) Execute(ctx context.Context) error {
+	return r.err
+}
+
+// slowRunner completes after the delay, unless interrupted
+type slowRunner struct {
+	task.Runner
+	delay time.Duration
+}
+
+func (r *slowRunner) Execute(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(r.delay):
+		return nil
+	}
+}
+
+func TestProgress(t *testing.T) {
+	m := task.NewManager("github.com/foo/bar")
+	m.Runners = map[string]task.Runnable{
+		"download": &stubRunner{Runner: task.Runner{Label: "Go Get", Mgr: m}},
+		"test":     &stubRunner{Runner: task.Runner{Label: "Go Test", Mgr: m}, err: errors.New("FAIL")},
+		"lint":     &stubRunner{Runner: task.Runner{Label: "Go Lint", Mgr: m}},
+	}
+
+	var (
+		mu       sync.Mutex
+		started  = map[string]bool{}
+		finished = map[string]error{}
+	)
+	m.OnProgress(func(p task.Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !p.Finished {
+			started[p.Label] = true
+			return
+		}
+		if !started[p.Label] {
+			t.Errorf("%s finished before starting", p.Label)
+		}
+		finished[p.Label] = p.Err
+	})
+
+	res := m.ExecuteRunners(context.Background())
+	defer m.Cleanup()
+	if res.Success {
+		t.Error("The analysis should have failed")
+	}
+	if len(finished) != 3 {
+		t.Fatalf("Got %d finished runners, expected 3", len(finished))
+	}
+	if finished["Go Test"] == nil || finished["Go Lint"] != nil {
+		t.Error("The runner errors should be reported")
+	}
+	if res.Errors["test"] != "FAIL" {
+		t.Error("The runner error should be kept by the manager")
+	}
+}
+
+func TestResults(t *testing.T) {
+	m := task.NewManager("github.com/foo/bar")
+	m.Runners = map[string]task.Runnable{
+		"download":     &stubRunner{Runner: task.Runner{Label: "Go Get", Mgr: m}},
+		"thirdparties": &stubRunner{Runner: task.Runner{Label: "Go List", Mgr: m, Data: []string{"github.com/pkg/errors"}}},
+		"loc":          &stubRunner{Runner: task.Runner{Label: "LOC", Mgr: m, Data: map[string]int{"loc": 120}}},
+		"lint":         &stubRunner{Runner: task.Runner{Label: "Go Lint", Mgr: m, Data: exago.LinterResults{"foo.go": nil}}},
+		"test":         &stubRunner{Runner: task.Runner{Label: "Go Test", Mgr: m, Data: []exago.TestPackage{{Name: "foo"}}}, err: errors.New("FAIL")},
+	}
+
+	m.ExecuteRunners(context.Background())
+	defer m.Cleanup()
+	res, err := m.Results()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.ThirdParties.Data) != 1 || res.CodeStats.Data["loc"] != 120 {
+		t.Errorf("Wrong results %#v", res)
+	}
+	if _, ok := res.Linters.Data["foo.go"]; !ok {
+		t.Error("The linter messages should be kept")
+	}
+	if len(res.Test.Data) != 0 {
+		t.Error("The failed runners should be dropped")
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	runnerTimeout := &task.TimeoutError{Runner: "lint", Limit: 50 * time.Millisecond}
+	analysisTimeout := &task.TimeoutError{Runner: "lint", Limit: 100 * time.Millisecond, Analysis: true}
+
+	var tests = []struct {
+		desc     string
+		timeouts task.Timeouts
+		cancel   bool
+		errors   map[string]string
+	}{
+		{"No limit", task.Timeouts{}, false, map[string]string{}},
+		{"Runner timeout", task.Timeouts{Runner: 50 * time.Millisecond, Runners: map[string]time.Duration{"test": time.Minute}}, false, map[string]string{
+			"lint": runnerTimeout.Error(),
+		}},
+		{"Analysis timeout", task.Timeouts{Analysis: 100 * time.Millisecond, Runner: time.Minute}, false, map[string]string{
+			"lint": analysisTimeout.Error(),
+		}},
+		{"Cancelled", task.Timeouts{}, true, map[string]string{
+			"lint": task.ErrCancelled.Error(),
+		}},
+	}
+
+	for _, tt := range tests {
+		m := task.NewManager("github.com/foo/bar")
+		m.UseTimeouts(tt.timeouts)
+		m.Runners = map[string]task.Runnable{
+			"download": &stubRunner{Runner: task.Runner{Label: "Go Get", Mgr: m}},
+			"lint":     &slowRunner{Runner: task.Runner{Label: "Go Lint", Mgr: m}, delay: 500 * time.Millisecond},
+			"test":     &slowRunner{Runner: task.Runner{Label: "Go Test", Mgr: m}, delay: 60 * time.Millisecond},
+			"loc":      &slowRunner{Runner: task.Runner{Label: "LOC", Mgr: m}},
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancel {
+			time.AfterFunc(80*time.Millisecond, cancel)
+		}
+		m.ExecuteRunners(ctx)
+		cancel()
+		m.Cleanup()
+
+		if len(m.Errors) != len(tt.errors) {
+			t.Errorf("%s: got errors %v, expected %v", tt.desc, m.Errors, tt.errors)
+			continue
+		}
+		for name, msg := range tt.errors {
+			if m.Errors[name] != msg {
+				t.Errorf("%s: got %s error %q, expected %q", tt.desc, name, m.Errors[name], msg)
+			}
+		}
+	}
+
+	// The download is interrupted as well, the analysis does not start then
+	m := task.NewManager("github.com/foo/bar")
+	defer m.Cleanup()
+	m.UseTimeouts(task.Timeouts{Runner: 10 * time.Millisecond})
+	m.Runners = map[string]task.Runnable{
+		"download": &slowRunner{Runner: task.Runner{Label: "Go Get", Mgr: m}, delay: time.Minute},
+	}
+	if err := m.Download(context.Background()); !task.IsTimeout(err) {
+		t.Errorf("Got %v, the download should time out", err)
+	}
+}
+
+func TestWorkspace(t *testing.T) {
+	m := task.NewManager("github.com/foo/bar")
+	m.Runners = map[string]task.Runnable{
+		"download": &stubRunner{Runner: task.Runner{Label: "Go Get", Mgr: m}},
+	}
+	if err := m.Download(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := m.Workspace()
+	if ws == nil {
+		t.Fatal("The workspace should be created by the download")
+	}
+	if !strings.HasPrefix(m.RepositoryPath(), ws.GOPATH()+"/src/") {
+		t.Errorf("The repository %s should be downloaded in the workspace", m.RepositoryPath())
+	}
+
+	vars := map[string]int{}
+	for _, v := range m.Env() {
+		vars[strings.SplitN(v, "=", 2)[0]]++
+		for _, name := range []string{"GOPATH", "GOCACHE", "GOMODCACHE"} {
+			if strings.HasPrefix(v, name+"=") && !strings.HasPrefix(v, name+"="+ws.Root) {
+				t.Errorf("%s should point to the workspace", v)
+			}
+		}
+	}
+	if vars["GOPATH"] != 1 || vars["GO111MODULE"] != 1 {
+		t.Errorf("The environment of the process should be overridden, got %v", vars)
+	}
+
+	// The module cache is read-only
+	mod := filepath.Join(ws.GOMODCACHE(), "github.com", "pkg", "errors@v0.9.1")
+	if err := os.MkdirAll(mod, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(mod, 0555); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ws.Root); !os.IsNotExist(err) {
+		t.Error("The workspace should be removed")
+	}
+}
+
+func TestWorkspaceEscape(t *testing.T) {
+	m := task.NewManager("../../../../home/app/x")
+	m.Runners = map[string]task.Runnable{
+		"download": &stubRunner{Runner: task.Runner{Label: "Go Get", Mgr: m}},
+	}
+	defer m.Cleanup()
+	if err := m.Download(context.Background()); err != task.ErrRepositoryPath {
+		t.Errorf("Got %v, the repository should not be downloaded outside the workspace", err)
+	}
+}
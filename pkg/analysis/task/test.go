@@ -1,6 +1,7 @@
 package task
 
 import (
+	"context"
 	"errors"
 	"os/exec"
 	"regexp"
@@ -34,10 +35,10 @@ func TestRunner(m *Manager) Runnable {
 }
 
 // Execute tests and determine which tests are passing/failing
-func (r *testRunner) Execute() error {
+func (r *testRunner) Execute(ctx context.Context) error {
 	defer r.trackTime(time.Now())
 
-	out, err := exec.Command("bash", "-c", "go test -v $(go list ./... | grep -v vendor | grep -v Godeps)").CombinedOutput()
+	out, err := r.Manager().command(ctx, "bash", "-c", "go test -v $(go list ./... | grep -v vendor | grep -v Godeps)").CombinedOutput()
 	if err != nil {
 		if e, ok := err.(*exec.ExitError); ok && !e.Success() {
 			return errors.New(string(out))
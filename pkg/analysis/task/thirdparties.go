@@ -1,7 +1,7 @@
 package task
 
 import (
-	"os/exec"
+	"context"
 	"regexp"
 	"strings"
 	"time"
@@ -19,10 +19,10 @@ func ThirdPartiesRunner(m *Manager) Runnable {
 }
 
 // Execute go list
-func (r *thirdPartiesRunner) Execute() error {
+func (r *thirdPartiesRunner) Execute(ctx context.Context) error {
 	defer r.trackTime(time.Now())
 
-	list, err := exec.Command("go", "list", "-f", `'{{ join .Deps ", " }}'`, "./...").CombinedOutput()
+	list, err := r.Manager().command(ctx, "go", "list", "-f", `'{{ join .Deps ", " }}'`, "./...").CombinedOutput()
 	if err != nil {
 		return err
 	}
@@ -50,7 +50,7 @@ func (r *thirdPartiesRunner) parseListOutput(output string) (out []string) {
 		// That way we support imports made this way:
 		// github.com/heroku/hk/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid
 		lastMatch := m[len(m)-1]
-		if lastMatch == r.Manager().Repository() {
+		if lastMatch == r.Manager().Repository() || lastMatch == r.Manager().ImportPath() {
 			continue
 		}
 
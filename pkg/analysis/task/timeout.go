@@ -0,0 +1,54 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrCancelled is the error of the runners interrupted by the cancellation of the analysis
+var ErrCancelled = errors.New("The analysis was cancelled")
+
+// Timeouts bounds the duration of the analysis, zero meaning no limit
+type Timeouts struct {
+	// Analysis bounds the whole analysis, from the download to the last runner
+	Analysis time.Duration
+	// Runner bounds each runner, unless overridden by name in Runners (e.g. test)
+	Runner  time.Duration
+	Runners map[string]time.Duration
+}
+
+// forRunner returns the timeout of the runner
+func (t Timeouts) forRunner(name string) time.Duration {
+	if d, ok := t.Runners[name]; ok {
+		return d
+	}
+	return t.Runner
+}
+
+// TimeoutError is the error of a runner that did not complete in time,
+// each runner interrupted gets its own
+type TimeoutError struct {
+	Runner string
+	// Limit is the timeout exceeded, that of the whole analysis if Analysis is set
+	Limit    time.Duration
+	Analysis bool
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Analysis {
+		return fmt.Sprintf("Timed out: the analysis exceeded %s", e.Limit)
+	}
+	return fmt.Sprintf("Timed out: the %s runner exceeded %s", e.Runner, e.Limit)
+}
+
+// Timeout tells that the runner timed out, the same way as net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// IsTimeout tells whether the runner returning the error timed out
+func IsTimeout(err error) bool {
+	_, ok := err.(*TimeoutError)
+	return ok
+}
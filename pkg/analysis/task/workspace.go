@@ -0,0 +1,84 @@
+package task
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// workspaceVars are the variables of the environment replaced by the workspace
+var workspaceVars = []string{"GOPATH", "GOCACHE", "GOMODCACHE", "GOLANGCI_LINT_CACHE", "GO111MODULE", "GOFLAGS", "PWD"}
+
+// Workspace is the temporary GOPATH and caches of an analysis,
+// so that concurrent analyses share neither files nor state.
+type Workspace struct {
+	Root string
+}
+
+// NewWorkspace creates an empty workspace in the temporary directory.
+func NewWorkspace() (*Workspace, error) {
+	root, err := ioutil.TempDir("", "exago-")
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create the workspace")
+	}
+	return &Workspace{Root: root}, nil
+}
+
+// GOPATH is where the repository and its dependencies are downloaded.
+func (w *Workspace) GOPATH() string {
+	return filepath.Join(w.Root, "gopath")
+}
+
+// GOCACHE is the build and test cache.
+func (w *Workspace) GOCACHE() string {
+	return filepath.Join(w.Root, "cache", "go-build")
+}
+
+// GOMODCACHE is the module cache, the default one of the GOPATH
+// so that it is used as well by the versions of Go ignoring GOMODCACHE.
+func (w *Workspace) GOMODCACHE() string {
+	return filepath.Join(w.GOPATH(), "pkg", "mod")
+}
+
+// Env returns the environment of the process, pointing to the workspace.
+func (w *Workspace) Env() []string {
+	env := make([]string, 0, len(os.Environ())+len(workspaceVars))
+	for _, v := range os.Environ() {
+		if !isWorkspaceVar(v) {
+			env = append(env, v)
+		}
+	}
+	return append(env,
+		"GOPATH="+w.GOPATH(),
+		"GOCACHE="+w.GOCACHE(),
+		"GOMODCACHE="+w.GOMODCACHE(),
+		"GOLANGCI_LINT_CACHE="+filepath.Join(w.Root, "cache", "golangci-lint"),
+	)
+}
+
+// Remove deletes the workspace, the module cache being read-only
+// its directories are made writable first.
+func (w *Workspace) Remove() error {
+	filepath.Walk(w.Root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			os.Chmod(path, 0755)
+		}
+		return nil
+	})
+	if err := os.RemoveAll(w.Root); err != nil {
+		return errors.Wrapf(err, "Could not remove the workspace %s", w.Root)
+	}
+	return nil
+}
+
+func isWorkspaceVar(v string) bool {
+	for _, name := range workspaceVars {
+		if strings.HasPrefix(v, name+"=") {
+			return true
+		}
+	}
+	return false
+}
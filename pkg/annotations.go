@@ -0,0 +1,93 @@
+package exago
+
+import (
+	"path"
+	"sort"
+)
+
+// AnnotatedFile is a source file along with what the analysis reported about its lines.
+type AnnotatedFile struct {
+	Path    string           `json:"path"`
+	Content string           `json:"content"`
+	Lines   []LineAnnotation `json:"lines"`
+}
+
+// LineAnnotation gathers the linter messages and the coverage state of a line.
+// Covered is nil when the line does not belong to any statement.
+type LineAnnotation struct {
+	Line     int           `json:"line"`
+	Covered  *bool         `json:"covered,omitempty"`
+	Messages []LineMessage `json:"messages,omitempty"`
+}
+
+// LineMessage is a linter message attached to a line.
+type LineMessage struct {
+	Linter   string `json:"linter"`
+	Column   int    `json:"column"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+}
+
+// AnnotateFile collects the linter messages and the statements coverage
+// of the given file, the path is relative to the repository root.
+func (t Results) AnnotateFile(filename string) []LineAnnotation {
+	filename = path.Clean(filename)
+	lines := map[int]*LineAnnotation{}
+	line := func(n int) *LineAnnotation {
+		if _, ok := lines[n]; !ok {
+			lines[n] = &LineAnnotation{Line: n}
+		}
+		return lines[n]
+	}
+
+	for file, results := range t.Linters.Data {
+		if path.Clean(file) != filename {
+			continue
+		}
+		for _, lr := range results {
+			for _, m := range lr.Messages {
+				l := line(m.Row)
+				l.Messages = append(l.Messages, LineMessage{
+					Linter:   lr.Linter,
+					Column:   m.Column,
+					Message:  m.Message,
+					Severity: m.Severity,
+				})
+			}
+		}
+	}
+
+	var blocks []CoverageBlock
+	for _, pkg := range t.Coverage.Data.Packages {
+		for _, f := range pkg.Files {
+			if path.Clean(f.Path) == filename {
+				blocks = append(blocks, f.Blocks...)
+			}
+		}
+	}
+
+	// Statements may be nested (e.g. closures), apply the widest first
+	// so that the innermost statement decides the state of a line
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].End-blocks[i].Start > blocks[j].End-blocks[j].Start
+	})
+	for _, b := range blocks {
+		for n := b.Start; n <= b.End; n++ {
+			covered := b.Covered
+			line(n).Covered = &covered
+		}
+	}
+
+	out := make([]LineAnnotation, 0, len(lines))
+	for _, l := range lines {
+		sort.SliceStable(l.Messages, func(i, j int) bool {
+			return l.Messages[i].Column < l.Messages[j].Column
+		})
+		out = append(out, *l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Line < out[j].Line
+	})
+
+	return out
+}
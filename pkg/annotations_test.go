@@ -0,0 +1,64 @@
+package exago_test
+
+import (
+	"testing"
+
+	exago "github.com/jgautheron/exago/pkg"
+)
+
+func TestAnnotateFile(t *testing.T) {
+	res := exago.Results{}
+	res.Linters.Data = exago.LinterResults{
+		"./foo/bar.go": {
+			{Linter: "golint", Messages: []exago.LinterMessage{{Row: 3, Column: 8, Message: "b"}, {Row: 3, Column: 2, Message: "a"}}},
+			{Linter: "gocyclo", Messages: []exago.LinterMessage{{Row: 10, Column: 1, Message: "c"}}},
+		},
+		"foo/baz.go": {
+			{Linter: "golint", Messages: []exago.LinterMessage{{Row: 1, Column: 1, Message: "other file"}}},
+		},
+	}
+	res.Coverage.Data.Packages = []exago.CoveragePackage{{
+		Name: "foo",
+		Files: []exago.CoverageFile{{
+			Path: "foo/bar.go",
+			Blocks: []exago.CoverageBlock{
+				{Start: 4, End: 4, Covered: false},
+				{Start: 3, End: 5, Covered: true},
+			},
+		}},
+	}}
+
+	lines := res.AnnotateFile("foo/bar.go")
+
+	var expected = []struct {
+		line     int
+		covered  string
+		messages []string
+	}{
+		{3, "covered", []string{"a", "b"}},
+		{4, "uncovered", nil},
+		{5, "covered", nil},
+		{10, "none", []string{"c"}},
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Got %d annotated lines, expected %d", len(lines), len(expected))
+	}
+	for i, e := range expected {
+		l := lines[i]
+		covered := "none"
+		if l.Covered != nil && *l.Covered {
+			covered = "covered"
+		} else if l.Covered != nil {
+			covered = "uncovered"
+		}
+		if l.Line != e.line || covered != e.covered || len(l.Messages) != len(e.messages) {
+			t.Errorf("Wrong annotation for line %d: %#v", e.line, l)
+			continue
+		}
+		for j, m := range e.messages {
+			if l.Messages[j].Message != m {
+				t.Errorf("Line %d: got message %s, expected %s", e.line, l.Messages[j].Message, m)
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package exago
+
+import (
+	"path"
+	"sort"
+)
+
+// Comparison is the difference between two analyses of a repository,
+// from a base (e.g. master) to a head (e.g. a feature branch).
+type Comparison struct {
+	Score        ScoreDelta           `json:"score"`
+	LintMessages map[string]LintDelta `json:"lintMessages"`
+	Coverage     []CoverageDelta      `json:"coverage"`
+	ThirdParties ThirdPartiesDelta    `json:"thirdParties"`
+	FailingTests []FailingTest        `json:"failingTests"`
+}
+
+// ScoreDelta is the evolution of the overall score and of each evaluator.
+type ScoreDelta struct {
+	Base     float64          `json:"base"`
+	Head     float64          `json:"head"`
+	Delta    float64          `json:"delta"`
+	BaseRank string           `json:"baseRank"`
+	HeadRank string           `json:"headRank"`
+	Details  []EvaluatorDelta `json:"details,omitempty"`
+}
+
+// EvaluatorDelta is the evolution of the score given by an evaluator,
+// an evaluator missing on one side counts as zero.
+type EvaluatorDelta struct {
+	Name    string           `json:"name"`
+	Base    float64          `json:"base"`
+	Head    float64          `json:"head"`
+	Delta   float64          `json:"delta"`
+	Details []EvaluatorDelta `json:"details,omitempty"`
+}
+
+// LintDelta holds the linter messages of a file that appeared or disappeared.
+type LintDelta struct {
+	New   []LintMessage `json:"new,omitempty"`
+	Fixed []LintMessage `json:"fixed,omitempty"`
+}
+
+// LintMessage is a linter message along with the linter that reported it.
+type LintMessage struct {
+	Linter string `json:"linter"`
+	LinterMessage
+}
+
+// CoverageDelta is the evolution of the coverage of a package,
+// a package missing on one side counts as zero.
+type CoverageDelta struct {
+	Name  string  `json:"name"`
+	Path  string  `json:"path"`
+	Base  float64 `json:"base"`
+	Head  float64 `json:"head"`
+	Delta float64 `json:"delta"`
+}
+
+// ThirdPartiesDelta lists the third parties added and removed.
+type ThirdPartiesDelta struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// FailingTest is a test that did not fail in the base but fails in the head.
+// Test is empty when the package itself fails, e.g. it does not build.
+type FailingTest struct {
+	Package string `json:"package"`
+	Test    string `json:"test,omitempty"`
+}
+
+// Compare computes what changed from the base analysis to the head analysis.
+func Compare(base, head Data) Comparison {
+	return Comparison{
+		Score:        compareScores(base.Score, head.Score),
+		LintMessages: compareLinters(base.Results.Linters.Data, head.Results.Linters.Data),
+		Coverage:     compareCoverage(base.Results.Coverage.Data.Packages, head.Results.Coverage.Data.Packages),
+		ThirdParties: compareThirdParties(base.Results.ThirdParties.Data, head.Results.ThirdParties.Data),
+		FailingTests: compareTests(base.Results.Test.Data, head.Results.Test.Data),
+	}
+}
+
+func compareScores(base, head Score) ScoreDelta {
+	return ScoreDelta{
+		Base:     base.Value,
+		Head:     head.Value,
+		Delta:    head.Value - base.Value,
+		BaseRank: base.Rank,
+		HeadRank: head.Rank,
+		Details:  compareEvaluators(base.Details, head.Details),
+	}
+}
+
+// compareEvaluators matches the evaluators by name, recursively,
+// keeping the order of the head and appending the ones that were dropped.
+func compareEvaluators(base, head []*EvaluatorResponse) []EvaluatorDelta {
+	byName := map[string]*EvaluatorResponse{}
+	for _, e := range base {
+		byName[e.Name] = e
+	}
+
+	var out []EvaluatorDelta
+	seen := map[string]bool{}
+	for _, h := range head {
+		seen[h.Name] = true
+		d := EvaluatorDelta{Name: h.Name, Head: h.Score}
+		var details []*EvaluatorResponse
+		if b, ok := byName[h.Name]; ok {
+			d.Base = b.Score
+			details = b.Details
+		}
+		d.Delta = d.Head - d.Base
+		d.Details = compareEvaluators(details, h.Details)
+		out = append(out, d)
+	}
+	for _, b := range base {
+		if seen[b.Name] {
+			continue
+		}
+		out = append(out, EvaluatorDelta{
+			Name:    b.Name,
+			Base:    b.Score,
+			Delta:   -b.Score,
+			Details: compareEvaluators(b.Details, nil),
+		})
+	}
+	return out
+}
+
+// compareLinters reports per file the messages that are new in the head and
+// the ones of the base that are gone. Messages are first matched on their line,
+// then regardless of it so that code moving around is not seen as new issues.
+func compareLinters(base, head LinterResults) map[string]LintDelta {
+	bm, hm := lintMessages(base), lintMessages(head)
+	files := map[string]bool{}
+	for f := range bm {
+		files[f] = true
+	}
+	for f := range hm {
+		files[f] = true
+	}
+
+	out := map[string]LintDelta{}
+	for f := range files {
+		fixed, added := unmatched(bm[f], hm[f])
+		if len(fixed) > 0 || len(added) > 0 {
+			out[f] = LintDelta{New: added, Fixed: fixed}
+		}
+	}
+	return out
+}
+
+// lintMessages flattens the linter results, keyed by the cleaned file path.
+func lintMessages(res LinterResults) map[string][]LintMessage {
+	out := map[string][]LintMessage{}
+	for file, results := range res {
+		file = path.Clean(file)
+		for _, lr := range results {
+			for _, m := range lr.Messages {
+				out[file] = append(out[file], LintMessage{Linter: lr.Linter, LinterMessage: m})
+			}
+		}
+	}
+	for _, messages := range out {
+		sort.SliceStable(messages, func(i, j int) bool {
+			if messages[i].Row != messages[j].Row {
+				return messages[i].Row < messages[j].Row
+			}
+			return messages[i].Column < messages[j].Column
+		})
+	}
+	return out
+}
+
+// unmatched returns the messages of a and of b that have no counterpart in the other.
+func unmatched(a, b []LintMessage) (onlyA, onlyB []LintMessage) {
+	matchedA, matchedB := make([]bool, len(a)), make([]bool, len(b))
+	match := func(same func(x, y LintMessage) bool) {
+		for i := range a {
+			for j := range b {
+				if !matchedA[i] && !matchedB[j] && same(a[i], b[j]) {
+					matchedA[i], matchedB[j] = true, true
+				}
+			}
+		}
+	}
+	match(func(x, y LintMessage) bool {
+		return x.Linter == y.Linter && x.Message == y.Message && x.Row == y.Row
+	})
+	match(func(x, y LintMessage) bool {
+		return x.Linter == y.Linter && x.Message == y.Message
+	})
+
+	for i, m := range a {
+		if !matchedA[i] {
+			onlyA = append(onlyA, m)
+		}
+	}
+	for j, m := range b {
+		if !matchedB[j] {
+			onlyB = append(onlyB, m)
+		}
+	}
+	return onlyA, onlyB
+}
+
+// compareCoverage matches the packages by name, sorted by name.
+func compareCoverage(base, head []CoveragePackage) []CoverageDelta {
+	deltas := map[string]*CoverageDelta{}
+	for _, p := range base {
+		deltas[p.Name] = &CoverageDelta{Name: p.Name, Path: p.Path, Base: p.Coverage}
+	}
+	for _, p := range head {
+		d, ok := deltas[p.Name]
+		if !ok {
+			d = &CoverageDelta{Name: p.Name}
+			deltas[p.Name] = d
+		}
+		d.Path, d.Head = p.Path, p.Coverage
+	}
+
+	out := []CoverageDelta{}
+	for _, d := range deltas {
+		d.Delta = d.Head - d.Base
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func compareThirdParties(base, head []string) ThirdPartiesDelta {
+	return ThirdPartiesDelta{
+		Added:   difference(head, base),
+		Removed: difference(base, head),
+	}
+}
+
+// difference returns the sorted values of a missing from b.
+func difference(a, b []string) []string {
+	inB := map[string]bool{}
+	for _, v := range b {
+		inB[v] = true
+	}
+	out := []string{}
+	for _, v := range a {
+		if !inB[v] {
+			inB[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// compareTests lists the tests failing in the head that passed
+// or did not exist in the base.
+func compareTests(base, head []TestPackage) []FailingTest {
+	failed := map[FailingTest]bool{}
+	for _, p := range base {
+		for _, ft := range failingTests(p) {
+			failed[ft] = true
+		}
+	}
+
+	out := []FailingTest{}
+	for _, p := range head {
+		for _, ft := range failingTests(p) {
+			if !failed[ft] {
+				out = append(out, ft)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Package != out[j].Package {
+			return out[i].Package < out[j].Package
+		}
+		return out[i].Test < out[j].Test
+	})
+	return out
+}
+
+// failingTests returns the failed tests of the package, or the package
+// itself if it failed without any failing test.
+func failingTests(p TestPackage) (out []FailingTest) {
+	for _, t := range p.Tests {
+		if !t.Passed {
+			out = append(out, FailingTest{Package: p.Name, Test: t.Name})
+		}
+	}
+	if len(out) == 0 && !p.Success {
+		out = append(out, FailingTest{Package: p.Name})
+	}
+	return out
+}
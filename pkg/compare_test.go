@@ -0,0 +1,116 @@
+package exago_test
+
+import (
+	"reflect"
+	"testing"
+
+	exago "github.com/jgautheron/exago/pkg"
+)
+
+func TestCompare(t *testing.T) {
+	var base, head exago.Data
+
+	base.Score = exago.Score{Value: 70, Rank: "C", Details: []*exago.EvaluatorResponse{
+		{Name: "testcoverage", Score: 40},
+		{Name: "gometalinter", Score: 80, Details: []*exago.EvaluatorResponse{{Name: "golint", Score: 90}}},
+		{Name: "thirdparties", Score: 100},
+	}}
+	head.Score = exago.Score{Value: 82.5, Rank: "B", Details: []*exago.EvaluatorResponse{
+		{Name: "gometalinter", Score: 70, Details: []*exago.EvaluatorResponse{{Name: "golint", Score: 60}}},
+		{Name: "testcoverage", Score: 60},
+	}}
+
+	base.Results.Linters.Data = exago.LinterResults{
+		"./foo/bar.go": {{Linter: "golint", Messages: []exago.LinterMessage{
+			{Row: 3, Message: "exported func Bar"},
+			{Row: 10, Message: "exported func Baz"},
+		}}},
+		"foo/gone.go": {{Linter: "vet", Messages: []exago.LinterMessage{{Row: 1, Message: "unreachable code"}}}},
+	}
+	head.Results.Linters.Data = exago.LinterResults{
+		"foo/bar.go": {{Linter: "golint", Messages: []exago.LinterMessage{
+			{Row: 5, Message: "exported func Bar"},
+			{Row: 12, Message: "exported func Qux"},
+		}}},
+	}
+
+	base.Results.Coverage.Data.Packages = []exago.CoveragePackage{
+		{Name: "foo", Path: "github.com/a/b/foo", Coverage: 50},
+		{Name: "old", Path: "github.com/a/b/old", Coverage: 20},
+	}
+	head.Results.Coverage.Data.Packages = []exago.CoveragePackage{
+		{Name: "foo", Path: "github.com/a/b/foo", Coverage: 75},
+		{Name: "new", Path: "github.com/a/b/new", Coverage: 10},
+	}
+
+	base.Results.ThirdParties.Data = []string{"github.com/pkg/errors", "github.com/sirupsen/logrus"}
+	head.Results.ThirdParties.Data = []string{"github.com/pkg/errors", "github.com/go-chi/chi"}
+
+	base.Results.Test.Data = []exago.TestPackage{
+		{Name: "foo", Success: false, Tests: []exago.TestFile{{Name: "TestA", Passed: true}, {Name: "TestB", Passed: false}}},
+	}
+	head.Results.Test.Data = []exago.TestPackage{
+		{Name: "foo", Success: false, Tests: []exago.TestFile{{Name: "TestA", Passed: false}, {Name: "TestB", Passed: false}}},
+		{Name: "new", Success: false},
+	}
+
+	c := exago.Compare(base, head)
+
+	expectedScore := exago.ScoreDelta{Base: 70, Head: 82.5, Delta: 12.5, BaseRank: "C", HeadRank: "B", Details: []exago.EvaluatorDelta{
+		{Name: "gometalinter", Base: 80, Head: 70, Delta: -10, Details: []exago.EvaluatorDelta{{Name: "golint", Base: 90, Head: 60, Delta: -30}}},
+		{Name: "testcoverage", Base: 40, Head: 60, Delta: 20},
+		{Name: "thirdparties", Base: 100, Delta: -100},
+	}}
+	if !reflect.DeepEqual(c.Score, expectedScore) {
+		t.Errorf("Wrong score delta %#v", c.Score)
+	}
+
+	expectedLint := map[string]exago.LintDelta{
+		"foo/bar.go": {
+			New:   []exago.LintMessage{{Linter: "golint", LinterMessage: exago.LinterMessage{Row: 12, Message: "exported func Qux"}}},
+			Fixed: []exago.LintMessage{{Linter: "golint", LinterMessage: exago.LinterMessage{Row: 10, Message: "exported func Baz"}}},
+		},
+		"foo/gone.go": {
+			Fixed: []exago.LintMessage{{Linter: "vet", LinterMessage: exago.LinterMessage{Row: 1, Message: "unreachable code"}}},
+		},
+	}
+	if !reflect.DeepEqual(c.LintMessages, expectedLint) {
+		t.Errorf("Wrong lint delta %#v", c.LintMessages)
+	}
+
+	expectedCoverage := []exago.CoverageDelta{
+		{Name: "foo", Path: "github.com/a/b/foo", Base: 50, Head: 75, Delta: 25},
+		{Name: "new", Path: "github.com/a/b/new", Head: 10, Delta: 10},
+		{Name: "old", Path: "github.com/a/b/old", Base: 20, Delta: -20},
+	}
+	if !reflect.DeepEqual(c.Coverage, expectedCoverage) {
+		t.Errorf("Wrong coverage delta %#v", c.Coverage)
+	}
+
+	expectedThirdParties := exago.ThirdPartiesDelta{
+		Added:   []string{"github.com/go-chi/chi"},
+		Removed: []string{"github.com/sirupsen/logrus"},
+	}
+	if !reflect.DeepEqual(c.ThirdParties, expectedThirdParties) {
+		t.Errorf("Wrong third parties delta %#v", c.ThirdParties)
+	}
+
+	expectedTests := []exago.FailingTest{{Package: "foo", Test: "TestA"}, {Package: "new"}}
+	if !reflect.DeepEqual(c.FailingTests, expectedTests) {
+		t.Errorf("Wrong failing tests %#v", c.FailingTests)
+	}
+}
+
+func TestCompareIdentical(t *testing.T) {
+	var d exago.Data
+	d.Score.Value = 50
+	d.Results.Linters.Data = exago.LinterResults{
+		"foo.go": {{Linter: "golint", Messages: []exago.LinterMessage{{Row: 1, Message: "a"}, {Row: 1, Message: "a"}}}},
+	}
+	d.Results.Test.Data = []exago.TestPackage{{Name: "foo", Tests: []exago.TestFile{{Name: "TestA"}}}}
+
+	c := exago.Compare(d, d)
+	if c.Score.Delta != 0 || len(c.LintMessages) != 0 || len(c.FailingTests) != 0 {
+		t.Errorf("Identical analyses should not differ %#v", c)
+	}
+}
@@ -16,9 +16,25 @@ type ChecklistItem struct {
 }
 
 type CoveragePackage struct {
-	Name     string  `json:"name"`
-	Path     string  `json:"path"`
-	Coverage float64 `json:"coverage"`
+	Name     string         `json:"name"`
+	Path     string         `json:"path"`
+	Coverage float64        `json:"coverage"`
+	Files    []CoverageFile `json:"files,omitempty"`
+}
+
+// CoverageFile holds the statements coverage of a file,
+// the path is relative to the repository root.
+type CoverageFile struct {
+	Path   string          `json:"path"`
+	Blocks []CoverageBlock `json:"blocks"`
+}
+
+// CoverageBlock is a range of consecutive lines of statements sharing the same
+// coverage state, the results stored before may hold one block per statement.
+type CoverageBlock struct {
+	Start   int  `json:"start"`
+	End     int  `json:"end"`
+	Covered bool `json:"covered"`
 }
 
 type TestPackage struct {
@@ -115,6 +131,16 @@ func (t Results) GetMeanTestDuration() float64 {
 	return xmath.Arithmetic(duration)
 }
 
+// CountLintMessages returns the number of messages reported by the linters.
+func (t Results) CountLintMessages() (n int) {
+	for _, results := range t.Linters.Data {
+		for _, r := range results {
+			n += len(r.Messages)
+		}
+	}
+	return n
+}
+
 // GetAvgCodeCov returns the code coverage average.
 func (t Results) GetMeanCodeCov() float64 {
 	return t.Coverage.Data.Coverage